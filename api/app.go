@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"iara-assistant/config"
+	"iara-assistant/handlers"
+	"iara-assistant/logging"
+	"iara-assistant/services"
+)
+
+// app owns the services of the API, wires them into the HTTP server and runs
+// their background work.
+type app struct {
+	cfg     *config.Config
+	rag     *services.RAGService
+	vault   *services.VaultSyncer
+	backups *services.BackupService
+	cron    *services.CronService
+	server  *handlers.Server
+}
+
+// newApp builds the services from cfg. It waits for ChromaDB to come up.
+func newApp(ctx context.Context, cfg *config.Config) (*app, error) {
+	rag, err := services.NewRAGService(ctx, cfg.RAG())
+	if err != nil {
+		return nil, err
+	}
+	vault := services.NewVaultSyncer(rag, cfg.DataDir, cfg.Vault.AllowedRoots())
+	backups := services.NewBackupService(rag, vault, cfg.DataDir, cfg.Backups)
+	cron := services.NewCronService(cfg.Cron, cfg.Crawler, cfg.DataDir)
+
+	apiKeys := services.NewAPIKeyStore(cfg.DataDir, cfg.Auth.AdminAPIKey)
+	if !apiKeys.Configured() {
+		slog.Warn("No API keys are configured and every protected endpoint will answer 401; set ADMIN_API_KEY to create keys")
+	}
+	n8nVerifier := services.NewSignatureVerifier(cfg.Webhooks.N8NSecret, cfg.Webhooks.N8NSignatureWindow)
+	if n8nVerifier == nil {
+		slog.Warn("N8N_WEBHOOK_SECRET is not set, requests from n8n are not signature checked")
+	}
+
+	server := handlers.NewServer(handlers.Dependencies{
+		Assistant:        rag,
+		Documents:        rag,
+		Reindexer:        rag,
+		Health:           rag,
+		Vault:            vault,
+		Backups:          backups,
+		APIKeys:          apiKeys,
+		Crawler:          cron,
+		Usage:            rag.Usage(),
+		Config:           cfg,
+		KeyLimiter:       services.NewRateLimiter(cfg.RateLimits.KeyPerMinute, cfg.RateLimits.KeyBurst),
+		UserLimiter:      services.NewRateLimiter(cfg.RateLimits.UserPerMinute, cfg.RateLimits.UserBurst),
+		N8NVerifier:      n8nVerifier,
+		TelegramVerifier: services.NewTelegramVerifier(cfg.Webhooks.TelegramSecret),
+	})
+
+	return &app{
+		cfg:     cfg,
+		rag:     rag,
+		vault:   vault,
+		backups: backups,
+		cron:    cron,
+		server:  server,
+	}, nil
+}
+
+// start schedules the cron jobs and syncs the configured vault. Work started
+// outside a request is bound to ctx.
+func (a *app) start(ctx context.Context) error {
+	if err := a.cron.Start(); err != nil {
+		return err
+	}
+	if err := a.cron.AddJob(a.cfg.Cron.URLRefresh, "Watched URL refresh", a.rag.RefreshWatchedURLs); err != nil {
+		return err
+	}
+	if a.cfg.Cron.Backup != services.BackupScheduleOff {
+		err := a.cron.AddJob(a.cfg.Cron.Backup, "Backup", func(ctx context.Context) error {
+			_, err := a.backups.Backup(ctx)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if a.cfg.Vault.Path != "" {
+		go a.syncVault(ctx, a.cfg.Vault.Path, a.cfg.Vault.UserID)
+	}
+	return nil
+}
+
+// syncVault runs an initial sync of the vault and then watches it.
+func (a *app) syncVault(ctx context.Context, path, userID string) {
+	if _, err := a.vault.Sync(ctx, path, userID); err != nil {
+		slog.ErrorContext(ctx, "Initial vault sync failed", "vault", path, logging.Err(err))
+	}
+	if err := a.vault.Watch(path, userID); err != nil {
+		slog.ErrorContext(ctx, "Failed to watch vault", "vault", path, logging.Err(err))
+	}
+}
+
+// stop waits for running cron jobs, then stops the vault watchers and any
+// re-index. cancelWork is called before the caches are flushed, so that
+// whatever is left stops writing to them.
+func (a *app) stop(ctx context.Context, cancelWork context.CancelFunc) {
+	if err := a.cron.Stop(ctx); err != nil {
+		slog.Error("Cron shutdown failed", logging.Err(err))
+	}
+	a.vault.StopAll()
+	a.server.StopReindex()
+	cancelWork()
+	a.rag.FlushCaches()
+}
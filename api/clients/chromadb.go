@@ -2,28 +2,57 @@ package clients
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
+
+	"iara-assistant/logging"
 )
 
+// ChromaService names the ChromaDB dependency in errors and logs.
+const ChromaService = "chromadb"
+
 type ChromaDBClient struct {
 	baseURL    string
 	httpClient *http.Client
 }
 
+// ChromaDBConfig locates ChromaDB and sets how long startup waits for it:
+// ConnectRetries heartbeats, ConnectRetryDelay apart. Timeout bounds each
+// request.
+type ChromaDBConfig struct {
+	URL               string
+	ConnectRetries    int
+	ConnectRetryDelay time.Duration
+	Timeout           time.Duration
+}
+
+func DefaultChromaDBConfig() ChromaDBConfig {
+	return ChromaDBConfig{
+		URL:               "http://chromadb:8000",
+		ConnectRetries:    10,
+		ConnectRetryDelay: 5 * time.Second,
+		Timeout:           10 * time.Second,
+	}
+}
+
 type Collection struct {
-	ID       string            `json:"id"`
-	Name     string            `json:"name"`
-	Metadata map[string]string `json:"metadata"`
+	ID       string                 `json:"id"`
+	Name     string                 `json:"name"`
+	Metadata map[string]interface{} `json:"metadata"`
 }
 
 type CreateCollectionRequest struct {
-	Name     string            `json:"name"`
-	Metadata map[string]string `json:"metadata,omitempty"`
+	Name     string                 `json:"name"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+type ModifyCollectionRequest struct {
+	NewMetadata map[string]interface{} `json:"new_metadata"`
 }
 
 type AddRequest struct {
@@ -34,8 +63,34 @@ type AddRequest struct {
 }
 
 type QueryRequest struct {
-	QueryEmbeddings [][]float32 `json:"query_embeddings"`
-	NResults        int         `json:"n_results,omitempty"`
+	QueryEmbeddings [][]float32            `json:"query_embeddings"`
+	NResults        int                    `json:"n_results,omitempty"`
+	Where           map[string]interface{} `json:"where,omitempty"`
+}
+
+type UpdateRequest struct {
+	IDs       []string                 `json:"ids"`
+	Metadatas []map[string]interface{} `json:"metadatas,omitempty"`
+}
+
+type GetRequest struct {
+	IDs     []string               `json:"ids,omitempty"`
+	Where   map[string]interface{} `json:"where,omitempty"`
+	Limit   int                    `json:"limit,omitempty"`
+	Offset  int                    `json:"offset,omitempty"`
+	Include []string               `json:"include,omitempty"`
+}
+
+type DeleteRequest struct {
+	IDs   []string               `json:"ids,omitempty"`
+	Where map[string]interface{} `json:"where,omitempty"`
+}
+
+type GetResponse struct {
+	IDs        []string                 `json:"ids"`
+	Documents  []string                 `json:"documents"`
+	Metadatas  []map[string]interface{} `json:"metadatas"`
+	Embeddings [][]float32              `json:"embeddings,omitempty"`
 }
 
 type QueryResponse struct {
@@ -45,9 +100,13 @@ type QueryResponse struct {
 	Metadatas [][]map[string]interface{} `json:"metadatas"`
 }
 
-func (c *ChromaDBClient) Heartbeat() error {
+func (c *ChromaDBClient) Heartbeat(ctx context.Context) error {
 	url := fmt.Sprintf("%s/api/v2/heartbeat", c.baseURL)
-	resp, err := c.httpClient.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create heartbeat request: %w", err)
+	}
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to make heartbeat request: %w", err)
 	}
@@ -59,31 +118,47 @@ func (c *ChromaDBClient) Heartbeat() error {
 	return nil
 }
 
-func NewChromaDBClientWithRetry(baseURL string, maxRetries int, retryDelay time.Duration) (*ChromaDBClient, error) {
+// BreakerState returns the state of the circuit breaker guarding ChromaDB.
+func (c *ChromaDBClient) BreakerState() string {
+	return breakerState(c.httpClient)
+}
+
+func NewChromaDBClientWithRetry(ctx context.Context, cfg ChromaDBConfig) (*ChromaDBClient, error) {
 	var client *ChromaDBClient
 	var err error
+	baseURL, maxRetries, retryDelay := cfg.URL, cfg.ConnectRetries, cfg.ConnectRetryDelay
 
-	log.Println("Attempting to connect to ChromaDB...")
+	slog.InfoContext(ctx, "Connecting to ChromaDB", "url", baseURL)
 
 	for attempt := 1; attempt <= maxRetries; attempt++ {
 		c := &ChromaDBClient{
 			baseURL: baseURL,
 			httpClient: &http.Client{
-				Timeout: 10 * time.Second,
+				Timeout: cfg.Timeout,
 			},
 		}
 
-		err = c.Heartbeat()
+		err = c.Heartbeat(ctx)
 		if err == nil {
-			log.Println("Successfully connected to ChromaDB!")
+			slog.InfoContext(ctx, "Connected to ChromaDB")
+			// Startup retries are handled by this loop; once connected,
+			// requests get retries and a circuit breaker of their own.
+			c.httpClient.Transport = NewResilientTransport(ChromaService)
 			client = c
 			break
 		}
 
-		log.Printf("Failed to connect (attempt %d/%d): %v", attempt, maxRetries, err)
+		slog.WarnContext(ctx, "Failed to connect to ChromaDB",
+			"attempt", attempt,
+			"attempts", maxRetries,
+			"retry_in", retryDelay,
+			logging.Err(err))
 		if attempt < maxRetries {
-			log.Printf("Retrying in %v...", retryDelay)
-			time.Sleep(retryDelay)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(retryDelay):
+			}
 		}
 	}
 
@@ -94,28 +169,28 @@ func NewChromaDBClientWithRetry(baseURL string, maxRetries int, retryDelay time.
 	return client, nil
 }
 
-func (c *ChromaDBClient) CreateCollection(name string) error {
+// CreateCollection creates a collection with the given metadata. An existing
+// collection is left as it is.
+func (c *ChromaDBClient) CreateCollection(ctx context.Context, name string, metadata map[string]interface{}) error {
 	reqBody := CreateCollectionRequest{
-		Name: name,
-		Metadata: map[string]string{
-			"description": "Iara assistant facts storage",
-		},
+		Name:     name,
+		Metadata: metadata,
 	}
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
-	
+
 	// Use v2 API with default tenant and database
 	url := fmt.Sprintf("%s/api/v2/collections", c.baseURL)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Chroma-Tenant", "default_tenant")
 	req.Header.Set("X-Chroma-Database", "default_database")
-	
+
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to make request: %w", err)
@@ -123,15 +198,51 @@ func (c *ChromaDBClient) CreateCollection(name string) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusConflict {
-		return fmt.Errorf("failed to create collection, status: %s", resp.Status)
+		return fmt.Errorf("failed to create collection: %w", newStatusError(ChromaService, resp))
+	}
+	return nil
+}
+
+func (c *ChromaDBClient) GetCollection(ctx context.Context, name string) (*Collection, error) {
+	var collection Collection
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/v2/collections/%s", name), nil, &collection); err != nil {
+		return nil, fmt.Errorf("failed to get collection: %w", err)
+	}
+	return &collection, nil
+}
+
+// DeleteCollection drops a collection and every document in it.
+func (c *ChromaDBClient) DeleteCollection(ctx context.Context, name string) error {
+	if err := c.do(ctx, http.MethodDelete, fmt.Sprintf("/api/v2/collections/%s", name), nil, nil); err != nil {
+		return fmt.Errorf("failed to delete collection: %w", err)
+	}
+	return nil
+}
+
+// UpdateCollectionMetadata replaces the metadata of a collection.
+func (c *ChromaDBClient) UpdateCollectionMetadata(ctx context.Context, name string, metadata map[string]interface{}) error {
+	reqBody := ModifyCollectionRequest{NewMetadata: metadata}
+	if err := c.do(ctx, http.MethodPut, fmt.Sprintf("/api/v2/collections/%s", name), reqBody, nil); err != nil {
+		return fmt.Errorf("failed to update collection metadata: %w", err)
 	}
 	return nil
 }
 
-func (c *ChromaDBClient) AddDocument(collectionName, id, document string, embedding []float32, metadata map[string]interface{}) error {
-	log.Printf("ChromaDB AddDocument: collection=%s, id=%s, doc_length=%d, embedding_length=%d", 
-		collectionName, id, len(document), len(embedding))
-		
+func (c *ChromaDBClient) CountDocuments(ctx context.Context, collectionName string) (int, error) {
+	var count int
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/v2/collections/%s/count", collectionName), nil, &count); err != nil {
+		return 0, fmt.Errorf("failed to count documents: %w", err)
+	}
+	return count, nil
+}
+
+func (c *ChromaDBClient) AddDocument(ctx context.Context, collectionName, id, document string, embedding []float32, metadata map[string]interface{}) error {
+	slog.DebugContext(ctx, "Adding document to ChromaDB",
+		"collection", collectionName,
+		"id", id,
+		"document_length", len(document),
+		"embedding_length", len(embedding))
+
 	reqBody := AddRequest{
 		IDs:        []string{id},
 		Embeddings: [][]float32{embedding},
@@ -142,44 +253,46 @@ func (c *ChromaDBClient) AddDocument(collectionName, id, document string, embedd
 	if err != nil {
 		return fmt.Errorf("failed to marshal request: %w", err)
 	}
-	
+
 	// Use v2 API with tenant and database headers
 	url := fmt.Sprintf("%s/api/v2/collections/%s/add", c.baseURL, collectionName)
-	log.Printf("ChromaDB request URL: %s", url)
-	
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Chroma-Tenant", "default_tenant")
 	req.Header.Set("X-Chroma-Database", "default_database")
-	
-	log.Printf("ChromaDB headers: Tenant=%s, Database=%s", "default_tenant", "default_database")
-	
+
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to make request: %w", err)
 	}
 	defer resp.Body.Close()
-	
-	log.Printf("ChromaDB response status: %d", resp.StatusCode)
-	
+
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		log.Printf("ChromaDB error response body: %s", string(body))
-		return fmt.Errorf("failed to add document, status: %d, body: %s", resp.StatusCode, string(body))
+		apiErr := newStatusError(ChromaService, resp)
+		slog.DebugContext(ctx, "ChromaDB rejected document", "status", resp.StatusCode, "body", apiErr.Message)
+		return fmt.Errorf("failed to add document: %w", apiErr)
 	}
 	return nil
 }
 
-func (c *ChromaDBClient) QuerySimilar(collectionName string, queryEmbedding []float32, nResults int) (*QueryResponse, error) {
+func (c *ChromaDBClient) QuerySimilar(ctx context.Context, collectionName string, queryEmbedding []float32, nResults int) (*QueryResponse, error) {
+	return c.QuerySimilarWhere(ctx, collectionName, queryEmbedding, nResults, nil)
+}
+
+// QuerySimilarWhere behaves like QuerySimilar but restricts the search to
+// documents whose metadata matches the given Chroma where filter.
+func (c *ChromaDBClient) QuerySimilarWhere(ctx context.Context, collectionName string, queryEmbedding []float32, nResults int, where map[string]interface{}) (*QueryResponse, error) {
 	if nResults == 0 {
 		nResults = 3
 	}
 	reqBody := QueryRequest{
 		QueryEmbeddings: [][]float32{queryEmbedding},
 		NResults:        nResults,
+		Where:           where,
 	}
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
@@ -188,21 +301,21 @@ func (c *ChromaDBClient) QuerySimilar(collectionName string, queryEmbedding []fl
 
 	// Use v2 API with tenant and database headers
 	url := fmt.Sprintf("%s/api/v2/collections/%s/query", c.baseURL, collectionName)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Chroma-Tenant", "default_tenant")
 	req.Header.Set("X-Chroma-Database", "default_database")
-	
+
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to query collection, status: %s", resp.Status)
+		return nil, fmt.Errorf("failed to query collection: %w", newStatusError(ChromaService, resp))
 	}
 	var queryResp QueryResponse
 	if err := json.NewDecoder(resp.Body).Decode(&queryResp); err != nil {
@@ -210,3 +323,147 @@ func (c *ChromaDBClient) QuerySimilar(collectionName string, queryEmbedding []fl
 	}
 	return &queryResp, nil
 }
+
+// UpsertDocuments adds the given documents, overwriting any existing
+// documents with the same IDs.
+func (c *ChromaDBClient) UpsertDocuments(ctx context.Context, collectionName string, docs AddRequest) error {
+	if err := c.post(ctx, fmt.Sprintf("/api/v2/collections/%s/upsert", collectionName), docs, nil); err != nil {
+		return fmt.Errorf("failed to upsert documents: %w", err)
+	}
+	return nil
+}
+
+func (c *ChromaDBClient) UpsertDocument(ctx context.Context, collectionName, id, document string, embedding []float32, metadata map[string]interface{}) error {
+	return c.UpsertDocuments(ctx, collectionName, AddRequest{
+		IDs:        []string{id},
+		Embeddings: [][]float32{embedding},
+		Documents:  []string{document},
+		Metadatas:  []map[string]interface{}{metadata},
+	})
+}
+
+// GetDocuments fetches documents by ID. IDs that do not exist are simply
+// missing from the response.
+func (c *ChromaDBClient) GetDocuments(ctx context.Context, collectionName string, ids []string) (*GetResponse, error) {
+	reqBody := GetRequest{
+		IDs:     ids,
+		Include: []string{"documents", "metadatas"},
+	}
+	var getResp GetResponse
+	if err := c.post(ctx, fmt.Sprintf("/api/v2/collections/%s/get", collectionName), reqBody, &getResp); err != nil {
+		return nil, fmt.Errorf("failed to get documents: %w", err)
+	}
+	return &getResp, nil
+}
+
+// ListIDs returns the ID of every document in a collection. They are read in
+// a single request, so writes made meanwhile cannot shift a page and hide
+// some of them, as they can when paging with an offset.
+func (c *ChromaDBClient) ListIDs(ctx context.Context, collectionName string) ([]string, error) {
+	reqBody := struct {
+		Include []string `json:"include"`
+	}{Include: []string{}}
+	var getResp GetResponse
+	if err := c.post(ctx, fmt.Sprintf("/api/v2/collections/%s/get", collectionName), reqBody, &getResp); err != nil {
+		return nil, fmt.Errorf("failed to list documents: %w", err)
+	}
+	return getResp.IDs, nil
+}
+
+// GetDocumentsWhere fetches the documents whose metadata matches the given
+// Chroma where filter. A zero limit returns every match.
+func (c *ChromaDBClient) GetDocumentsWhere(ctx context.Context, collectionName string, where map[string]interface{}, limit, offset int) (*GetResponse, error) {
+	reqBody := GetRequest{
+		Where:   where,
+		Limit:   limit,
+		Offset:  offset,
+		Include: []string{"documents", "metadatas"},
+	}
+	var getResp GetResponse
+	if err := c.post(ctx, fmt.Sprintf("/api/v2/collections/%s/get", collectionName), reqBody, &getResp); err != nil {
+		return nil, fmt.Errorf("failed to get documents: %w", err)
+	}
+	return &getResp, nil
+}
+
+// GetDocumentsWithEmbeddings fetches documents by ID together with their
+// stored embeddings.
+func (c *ChromaDBClient) GetDocumentsWithEmbeddings(ctx context.Context, collectionName string, ids []string) (*GetResponse, error) {
+	reqBody := GetRequest{
+		IDs:     ids,
+		Include: []string{"documents", "metadatas", "embeddings"},
+	}
+	var getResp GetResponse
+	if err := c.post(ctx, fmt.Sprintf("/api/v2/collections/%s/get", collectionName), reqBody, &getResp); err != nil {
+		return nil, fmt.Errorf("failed to get documents: %w", err)
+	}
+	return &getResp, nil
+}
+
+// DeleteDocuments removes documents by ID, by metadata filter, or both.
+func (c *ChromaDBClient) DeleteDocuments(ctx context.Context, collectionName string, ids []string, where map[string]interface{}) error {
+	reqBody := DeleteRequest{
+		IDs:   ids,
+		Where: where,
+	}
+	if err := c.post(ctx, fmt.Sprintf("/api/v2/collections/%s/delete", collectionName), reqBody, nil); err != nil {
+		return fmt.Errorf("failed to delete documents: %w", err)
+	}
+	return nil
+}
+
+// UpdateMetadata merges the given metadata into existing documents without
+// touching their text or embeddings.
+func (c *ChromaDBClient) UpdateMetadata(ctx context.Context, collectionName string, ids []string, metadatas []map[string]interface{}) error {
+	reqBody := UpdateRequest{
+		IDs:       ids,
+		Metadatas: metadatas,
+	}
+	if err := c.post(ctx, fmt.Sprintf("/api/v2/collections/%s/update", collectionName), reqBody, nil); err != nil {
+		return fmt.Errorf("failed to update documents: %w", err)
+	}
+	return nil
+}
+
+// post sends a JSON request to the default tenant and database and decodes
+// the response into out when it is not nil.
+func (c *ChromaDBClient) post(ctx context.Context, path string, body interface{}, out interface{}) error {
+	return c.do(ctx, http.MethodPost, path, body, out)
+}
+
+func (c *ChromaDBClient) do(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reqBody = bytes.NewReader(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Chroma-Tenant", "default_tenant")
+	req.Header.Set("X-Chroma-Database", "default_database")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return newStatusError(ChromaService, resp)
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
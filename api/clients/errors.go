@@ -0,0 +1,105 @@
+package clients
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Kinds of dependency failures callers may want to tell apart. Check for
+// them with errors.Is; errors.As with *APIError gives the details.
+var (
+	ErrRateLimited    = errors.New("rate limited")
+	ErrUnavailable    = errors.New("service unavailable")
+	ErrInvalidRequest = errors.New("invalid request")
+	ErrBlocked        = errors.New("blocked by safety filters")
+)
+
+// MaxErrorBodySize bounds how much of an error response is kept in errors.
+const MaxErrorBodySize = 2048
+
+// APIError is a failed call to a dependency.
+type APIError struct {
+	Service    string
+	StatusCode int
+	// RetryAfter is how long the dependency asked us to wait, if it did.
+	RetryAfter time.Duration
+	Message    string
+	Kind       error
+	Err        error
+}
+
+func (e *APIError) Error() string {
+	msg := e.Service
+	if e.Kind != nil {
+		msg += ": " + e.Kind.Error()
+	}
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(" (status %d)", e.StatusCode)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *APIError) Unwrap() []error {
+	var errs []error
+	if e.Kind != nil {
+		errs = append(errs, e.Kind)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
+}
+
+// newStatusError builds the error for an unexpected response status, reading
+// the start of the body for the message.
+func newStatusError(service string, resp *http.Response) *APIError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, MaxErrorBodySize))
+	return &APIError{
+		Service:    service,
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		Message:    string(body),
+		Kind:       errorKind(resp.StatusCode),
+	}
+}
+
+func errorKind(status int) error {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case status == http.StatusBadRequest, status == http.StatusNotFound,
+		status == http.StatusRequestEntityTooLarge, status == http.StatusUnprocessableEntity:
+		return ErrInvalidRequest
+	case status >= 500:
+		return ErrUnavailable
+	default:
+		return nil
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as
+// an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
@@ -2,21 +2,64 @@ package clients
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
 const (
-	EmbeddingAPIURL  = "https://generativelanguage.googleapis.com/v1beta/models/embedding-001:embedContent"
-	GenerationAPIURL = "https://generativelanguage.googleapis.com/v1beta/models/gemini-pro:generateContent"
+	// GeminiAPIBaseURL is the default endpoint models are addressed under.
+	GeminiAPIBaseURL = "https://generativelanguage.googleapis.com/v1beta/models"
+
+	DefaultMaxOutputTokens = 1024
+
+	// GeminiService names the Google AI dependency in errors and logs.
+	GeminiService = "gemini"
+
+	// DefaultEmbeddingModel is the model the stored embeddings were made
+	// with before the model became configurable.
+	DefaultEmbeddingModel = "embedding-001"
+
+	// MaxBatchEmbedSize is the most texts batchEmbedContents accepts in one
+	// request.
+	MaxBatchEmbedSize = 100
 )
 
 type GoogleAIClient struct {
-	apiKey     string
-	httpClient *http.Client
+	apiKey          string
+	baseURL         string
+	embeddingModel  string
+	maxOutputTokens int
+	httpClient      *http.Client
+}
+
+// GoogleAIConfig holds the Gemini API key and endpoint. MaxOutputTokens is
+// used by calls that do not set their own; Timeout bounds each request.
+type GoogleAIConfig struct {
+	APIKey          string
+	BaseURL         string
+	MaxOutputTokens int
+	Timeout         time.Duration
+}
+
+func DefaultGoogleAIConfig() GoogleAIConfig {
+	return GoogleAIConfig{
+		BaseURL:         GeminiAPIBaseURL,
+		MaxOutputTokens: DefaultMaxOutputTokens,
+		Timeout:         30 * time.Second,
+	}
+}
+
+// GenerationOptions describe a single generateContent call. An empty System
+// is left out of the request.
+type GenerationOptions struct {
+	Model           string
+	System          string
+	Prompt          string
+	MaxOutputTokens int
 }
 
 type EmbedRequest struct {
@@ -33,8 +76,36 @@ type EmbedResponse struct {
 	} `json:"embedding"`
 }
 
+// SystemInstruction is the instruction Gemini applies to the whole request,
+// kept apart from the user prompt.
+type SystemInstruction struct {
+	Parts []struct {
+		Text string `json:"text"`
+	} `json:"parts"`
+}
+
+type BatchEmbedRequest struct {
+	Requests []BatchEmbedItem `json:"requests"`
+}
+
+type BatchEmbedItem struct {
+	Model   string `json:"model"`
+	Content struct {
+		Parts []struct {
+			Text string `json:"text"`
+		} `json:"parts"`
+	} `json:"content"`
+}
+
+type BatchEmbedResponse struct {
+	Embeddings []struct {
+		Values []float32 `json:"values"`
+	} `json:"embeddings"`
+}
+
 type GenerateRequest struct {
-	Contents []struct {
+	SystemInstruction *SystemInstruction `json:"systemInstruction,omitempty"`
+	Contents          []struct {
 		Parts []struct {
 			Text string `json:"text"`
 		} `json:"parts"`
@@ -54,19 +125,80 @@ type GenerateResponse struct {
 				Text string `json:"text"`
 			} `json:"parts"`
 		} `json:"content"`
+		FinishReason string `json:"finishReason"`
 	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		TotalTokenCount      int `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+// Generation is the text a model produced and the tokens the call used,
+// prompt included.
+type Generation struct {
+	Text        string
+	TotalTokens int
+}
+
+// blockedFinishReasons are the finish reasons Gemini gives when it refuses to
+// produce (or finish) content.
+var blockedFinishReasons = map[string]bool{
+	"SAFETY":             true,
+	"RECITATION":         true,
+	"BLOCKLIST":          true,
+	"PROHIBITED_CONTENT": true,
+	"SPII":               true,
+}
+
+type CountTokensRequest struct {
+	Contents []struct {
+		Parts []struct {
+			Text string `json:"text"`
+		} `json:"parts"`
+	} `json:"contents"`
+}
+
+type CountTokensResponse struct {
+	TotalTokens int `json:"totalTokens"`
 }
 
-func NewGoogleAIClient(apiKey string) *GoogleAIClient {
+func NewGoogleAIClient(cfg GoogleAIConfig, embeddingModel string) *GoogleAIClient {
+	if embeddingModel == "" {
+		embeddingModel = DefaultEmbeddingModel
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = GeminiAPIBaseURL
+	}
+	if cfg.MaxOutputTokens <= 0 {
+		cfg.MaxOutputTokens = DefaultMaxOutputTokens
+	}
 	return &GoogleAIClient{
-		apiKey: apiKey,
+		apiKey:          cfg.APIKey,
+		baseURL:         strings.TrimSuffix(cfg.BaseURL, "/"),
+		embeddingModel:  strings.TrimPrefix(embeddingModel, "models/"),
+		maxOutputTokens: cfg.MaxOutputTokens,
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout:   cfg.Timeout,
+			Transport: NewResilientTransport(GeminiService),
 		},
 	}
 }
 
-func (c *GoogleAIClient) GenerateEmbedding(text string) ([]float32, error) {
+// EmbeddingModel returns the model GenerateEmbedding uses. Embeddings from
+// different models are not comparable, so caches key on it.
+func (c *GoogleAIClient) EmbeddingModel() string {
+	return c.embeddingModel
+}
+
+func (c *GoogleAIClient) modelURL(model, method string) string {
+	return fmt.Sprintf("%s/%s:%s?key=%s", c.baseURL, strings.TrimPrefix(model, "models/"), method, c.apiKey)
+}
+
+func (c *GoogleAIClient) GenerateEmbedding(ctx context.Context, text string) ([]float32, error) {
 	reqBody := EmbedRequest{}
 	reqBody.Content.Parts = []struct {
 		Text string `json:"text"`
@@ -79,16 +211,15 @@ func (c *GoogleAIClient) GenerateEmbedding(text string) ([]float32, error) {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	url := fmt.Sprintf("%s?key=%s", EmbeddingAPIURL, c.apiKey)
-	resp, err := c.httpClient.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	url := c.modelURL(c.embeddingModel, "embedContent")
+	resp, err := c.post(ctx, url, jsonData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, newStatusError(GeminiService, resp)
 	}
 
 	var embedResp EmbedResponse
@@ -99,8 +230,75 @@ func (c *GoogleAIClient) GenerateEmbedding(text string) ([]float32, error) {
 	return embedResp.Embedding.Values, nil
 }
 
-func (c *GoogleAIClient) GenerateText(prompt string) (string, error) {
+// GenerateEmbeddings embeds up to MaxBatchEmbedSize texts in a single
+// request. Embeddings are returned in the order of the texts.
+func (c *GoogleAIClient) GenerateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) > MaxBatchEmbedSize {
+		return nil, fmt.Errorf("cannot embed %d texts in one request, the limit is %d", len(texts), MaxBatchEmbedSize)
+	}
+
+	reqBody := BatchEmbedRequest{Requests: make([]BatchEmbedItem, len(texts))}
+	for i, text := range texts {
+		reqBody.Requests[i].Model = "models/" + c.embeddingModel
+		reqBody.Requests[i].Content.Parts = []struct {
+			Text string `json:"text"`
+		}{
+			{Text: text},
+		}
+	}
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := c.modelURL(c.embeddingModel, "batchEmbedContents")
+	resp, err := c.post(ctx, url, jsonData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newStatusError(GeminiService, resp)
+	}
+
+	var batchResp BatchEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&batchResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if len(batchResp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("got %d embeddings for %d texts", len(batchResp.Embeddings), len(texts))
+	}
+
+	embeddings := make([][]float32, len(texts))
+	for i, embedding := range batchResp.Embeddings {
+		embeddings[i] = embedding.Values
+	}
+	return embeddings, nil
+}
+
+// Generate produces a response with the given model. A response withheld by
+// the model's safety filters is reported as ErrBlocked, together with the
+// tokens it used.
+func (c *GoogleAIClient) Generate(ctx context.Context, opts GenerationOptions) (*Generation, error) {
+	maxOutputTokens := opts.MaxOutputTokens
+	if maxOutputTokens <= 0 {
+		maxOutputTokens = c.maxOutputTokens
+	}
+
+	prompt := opts.Prompt
 	reqBody := GenerateRequest{}
+	if opts.System != "" && !supportsSystemInstruction(opts.Model) {
+		prompt = opts.System + "\n\n" + prompt
+	} else if opts.System != "" {
+		reqBody.SystemInstruction = &SystemInstruction{}
+		reqBody.SystemInstruction.Parts = []struct {
+			Text string `json:"text"`
+		}{
+			{Text: opts.System},
+		}
+	}
 	reqBody.Contents = []struct {
 		Parts []struct {
 			Text string `json:"text"`
@@ -124,34 +322,126 @@ func (c *GoogleAIClient) GenerateText(prompt string) (string, error) {
 		Temperature:     0.7,
 		TopK:            40,
 		TopP:            0.95,
-		MaxOutputTokens: 1024,
+		MaxOutputTokens: maxOutputTokens,
 	}
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	url := fmt.Sprintf("%s?key=%s", GenerationAPIURL, c.apiKey)
-	resp, err := c.httpClient.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	url := c.modelURL(opts.Model, "generateContent")
+	resp, err := c.post(ctx, url, jsonData)
 	if err != nil {
-		return "", fmt.Errorf("failed to make request: %w", err)
+		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+		return nil, newStatusError(GeminiService, resp)
 	}
 
 	var genResp GenerateResponse
 	if err := json.NewDecoder(resp.Body).Decode(&genResp); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
+	generation := &Generation{TotalTokens: genResp.UsageMetadata.TotalTokenCount}
+	if reason := genResp.PromptFeedback.BlockReason; reason != "" {
+		return generation, &APIError{Service: GeminiService, Kind: ErrBlocked, Message: "prompt blocked: " + reason}
+	}
 	if len(genResp.Candidates) == 0 || len(genResp.Candidates[0].Content.Parts) == 0 {
-		return "", fmt.Errorf("no content generated")
+		if len(genResp.Candidates) > 0 && blockedFinishReasons[genResp.Candidates[0].FinishReason] {
+			return generation, &APIError{Service: GeminiService, Kind: ErrBlocked, Message: "response blocked: " + genResp.Candidates[0].FinishReason}
+		}
+		return generation, fmt.Errorf("no content generated")
 	}
 
-	return genResp.Candidates[0].Content.Parts[0].Text, nil
-}
\ No newline at end of file
+	generation.Text = genResp.Candidates[0].Content.Parts[0].Text
+	return generation, nil
+}
+
+// supportsSystemInstruction reports whether the model accepts a
+// systemInstruction. The Gemini 1.0 models reject the request, so their
+// system prompt is sent ahead of the user prompt instead.
+func supportsSystemInstruction(model string) bool {
+	return model != "gemini-pro" && !strings.HasPrefix(model, "gemini-1.0-")
+}
+
+// CountTokens returns the number of tokens the given model sees for the
+// text.
+func (c *GoogleAIClient) CountTokens(ctx context.Context, model, text string) (int, error) {
+	reqBody := CountTokensRequest{}
+	reqBody.Contents = []struct {
+		Parts []struct {
+			Text string `json:"text"`
+		} `json:"parts"`
+	}{
+		{
+			Parts: []struct {
+				Text string `json:"text"`
+			}{
+				{Text: text},
+			},
+		},
+	}
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := c.modelURL(model, "countTokens")
+	resp, err := c.post(ctx, url, jsonData)
+	if err != nil {
+		return 0, fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, newStatusError(GeminiService, resp)
+	}
+
+	var countResp CountTokensResponse
+	if err := json.NewDecoder(resp.Body).Decode(&countResp); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return countResp.TotalTokens, nil
+}
+
+// CheckModel fetches a model's description, which fails when the API key is
+// invalid or the model does not exist. It is a cheap way to tell whether
+// Gemini is usable.
+func (c *GoogleAIClient) CheckModel(ctx context.Context, model string) error {
+	url := fmt.Sprintf("%s/%s?key=%s", c.baseURL, strings.TrimPrefix(model, "models/"), c.apiKey)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newStatusError(GeminiService, resp)
+	}
+	return nil
+}
+
+// BreakerState returns the state of the circuit breaker guarding Gemini.
+func (c *GoogleAIClient) BreakerState() string {
+	return breakerState(c.httpClient)
+}
+
+// post sends a JSON request that is abandoned when ctx is done.
+func (c *GoogleAIClient) post(ctx context.Context, url string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.httpClient.Do(req)
+}
@@ -0,0 +1,50 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGenerateSystemPrompt(t *testing.T) {
+	tests := []struct {
+		model      string
+		wantSystem bool
+		wantPrompt string
+	}{
+		{model: "gemini-2.5-flash", wantSystem: true, wantPrompt: "question"},
+		{model: "gemini-pro", wantSystem: false, wantPrompt: "be brief\n\nquestion"},
+		{model: "gemini-1.0-pro-001", wantSystem: false, wantPrompt: "be brief\n\nquestion"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.model, func(t *testing.T) {
+			var got GenerateRequest
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					t.Errorf("decoding request: %v", err)
+				}
+				w.Write([]byte(`{"candidates": [{"content": {"parts": [{"text": "answer"}]}}]}`))
+			}))
+			defer server.Close()
+
+			client := NewGoogleAIClient(GoogleAIConfig{APIKey: "key", BaseURL: server.URL}, "")
+			_, err := client.Generate(context.Background(), GenerationOptions{Model: tt.model, System: "be brief", Prompt: "question"})
+			if err != nil {
+				t.Fatalf("Generate() err = %v", err)
+			}
+			if hasSystem := got.SystemInstruction != nil; hasSystem != tt.wantSystem {
+				t.Errorf("systemInstruction sent = %v, want %v", hasSystem, tt.wantSystem)
+			}
+			if len(got.Contents) != 1 || len(got.Contents[0].Parts) != 1 {
+				t.Fatalf("contents = %+v, want one part", got.Contents)
+			}
+			if prompt := got.Contents[0].Parts[0].Text; prompt != tt.wantPrompt {
+				t.Errorf("prompt = %q, want %q", prompt, tt.wantPrompt)
+			}
+		})
+	}
+}
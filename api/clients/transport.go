@@ -0,0 +1,295 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"iara-assistant/logging"
+	"iara-assistant/metrics"
+)
+
+// RetryPolicy controls how failed requests are retried. Delays grow
+// exponentially from BaseDelay up to MaxDelay, with jitter; a Retry-After
+// sent by the server takes precedence, up to MaxDelay.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    10 * time.Second,
+	}
+}
+
+// BreakerPolicy controls when a circuit breaker opens: after
+// FailureThreshold consecutive failed requests it rejects calls for
+// Cooldown, then lets a single probe through.
+type BreakerPolicy struct {
+	FailureThreshold int
+	Cooldown         time.Duration
+}
+
+func DefaultBreakerPolicy() BreakerPolicy {
+	return BreakerPolicy{
+		FailureThreshold: 5,
+		Cooldown:         30 * time.Second,
+	}
+}
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// CircuitBreaker stops calling a dependency that keeps failing, so requests
+// fail fast instead of piling up behind timeouts.
+type CircuitBreaker struct {
+	mu       sync.Mutex
+	policy   BreakerPolicy
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewCircuitBreaker(policy BreakerPolicy) *CircuitBreaker {
+	return &CircuitBreaker{policy: policy}
+}
+
+// Allow reports whether a call may proceed, and if not, how long until the
+// breaker lets a probe through. A call let through after the cooldown is the
+// probe: the caller must resolve it with Success, Failure or Release, or no
+// other call gets through.
+func (b *CircuitBreaker) Allow() (ok, probe bool, wait time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.policy.FailureThreshold {
+		return true, false, 0
+	}
+	if wait := b.policy.Cooldown - time.Since(b.openedAt); wait > 0 {
+		return false, false, wait
+	}
+	if b.probing {
+		return false, false, b.policy.Cooldown
+	}
+	b.probing = true
+	return true, true, 0
+}
+
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.failures >= b.policy.FailureThreshold {
+		// A failed probe restarts the cooldown.
+		b.openedAt = time.Now()
+		b.probing = false
+	}
+}
+
+// Release ends a probe whose outcome says nothing about the dependency, such
+// as a call canceled by its caller, so that the next call probes again.
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.failures < b.policy.FailureThreshold:
+		return BreakerClosed
+	case time.Since(b.openedAt) < b.policy.Cooldown:
+		return BreakerOpen
+	default:
+		return BreakerHalfOpen
+	}
+}
+
+// breakerState returns the breaker state of a client using a
+// ResilientTransport. Other clients are always closed.
+func breakerState(client *http.Client) string {
+	if t, ok := client.Transport.(*ResilientTransport); ok {
+		return t.Breaker.State()
+	}
+	return BreakerClosed
+}
+
+// ResilientTransport retries transient failures of a dependency (network
+// errors, 429 and 5xx responses) and guards it with a circuit breaker.
+// Non-transient responses are passed through for the client to handle.
+type ResilientTransport struct {
+	Service string
+	Base    http.RoundTripper
+	Retry   RetryPolicy
+	Breaker *CircuitBreaker
+}
+
+func NewResilientTransport(service string) *ResilientTransport {
+	return &ResilientTransport{
+		Service: service,
+		Base:    http.DefaultTransport,
+		Retry:   DefaultRetryPolicy(),
+		Breaker: NewCircuitBreaker(DefaultBreakerPolicy()),
+	}
+}
+
+func (t *ResilientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ok, probe, wait := t.Breaker.Allow()
+	if !ok {
+		metrics.UpstreamRequestDuration.WithLabelValues(t.Service, "circuit_open").Observe(0)
+		return nil, &APIError{
+			Service:    t.Service,
+			RetryAfter: wait,
+			Message:    "circuit breaker open",
+			Kind:       ErrUnavailable,
+		}
+	}
+	if probe {
+		// Success and Failure resolve the probe; every other way out of
+		// here (cancellation, a final 429, a body that cannot be replayed)
+		// must not leave the breaker waiting for it.
+		defer t.Breaker.Release()
+	}
+
+	if id := logging.RequestID(req.Context()); id != "" && req.Header.Get(logging.RequestIDHeader) == "" {
+		// A RoundTripper must not modify the caller's request.
+		req = req.Clone(req.Context())
+		req.Header.Set(logging.RequestIDHeader, id)
+	}
+
+	attempts := t.Retry.MaxAttempts
+	if req.Body != nil && req.GetBody == nil {
+		// The body cannot be replayed.
+		attempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		start := time.Now()
+		resp, err := t.Base.RoundTrip(req)
+		t.observe(start, resp, err)
+		retryable, retryAfter := t.classify(resp, err)
+		if !retryable {
+			t.record(resp, err)
+			return resp, err
+		}
+		if attempt >= attempts || req.Context().Err() != nil {
+			if err != nil {
+				t.Breaker.Failure()
+				return nil, t.networkError(err)
+			}
+			// Being rate limited means the dependency is up.
+			if resp.StatusCode != http.StatusTooManyRequests {
+				t.Breaker.Failure()
+			}
+			return resp, nil
+		}
+
+		delay := t.backoff(attempt, retryAfter)
+		reason := "network error"
+		if resp != nil {
+			reason = resp.Status
+			io.Copy(io.Discard, io.LimitReader(resp.Body, MaxErrorBodySize))
+			resp.Body.Close()
+		}
+		slog.WarnContext(req.Context(), "Retrying request",
+			"service", t.Service,
+			"reason", reason,
+			"delay", delay.Round(time.Millisecond),
+			"attempt", attempt,
+			"attempts", attempts)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			t.record(nil, req.Context().Err())
+			return nil, t.networkError(req.Context().Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// classify reports whether an attempt should be retried and how long the
+// server asked us to wait.
+func (t *ResilientTransport) classify(resp *http.Response, err error) (bool, time.Duration) {
+	if err != nil {
+		return !errors.Is(err, context.Canceled), 0
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true, parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+	return false, 0
+}
+
+// record updates the breaker with the final outcome of a request. Client
+// errors and callers canceling say nothing about the health of the
+// dependency.
+func (t *ResilientTransport) record(resp *http.Response, err error) {
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			t.Breaker.Failure()
+		}
+		return
+	}
+	t.Breaker.Success()
+}
+
+// observe records the duration and status code of one attempt.
+func (t *ResilientTransport) observe(start time.Time, resp *http.Response, err error) {
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	metrics.UpstreamRequestDuration.WithLabelValues(t.Service, code).Observe(time.Since(start).Seconds())
+}
+
+func (t *ResilientTransport) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return min(retryAfter, t.Retry.MaxDelay)
+	}
+	delay := t.Retry.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > t.Retry.MaxDelay {
+		delay = t.Retry.MaxDelay
+	}
+	// Full jitter in the upper half keeps clients from retrying in lockstep.
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (t *ResilientTransport) networkError(err error) error {
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	return &APIError{Service: t.Service, Kind: ErrUnavailable, Err: err}
+}
@@ -0,0 +1,164 @@
+package clients
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func testBreaker() *CircuitBreaker {
+	return NewCircuitBreaker(BreakerPolicy{FailureThreshold: 2, Cooldown: time.Minute})
+}
+
+// expireCooldown moves the breaker past its cooldown.
+func expireCooldown(b *CircuitBreaker) {
+	b.mu.Lock()
+	b.openedAt = time.Now().Add(-b.policy.Cooldown)
+	b.mu.Unlock()
+}
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	b := testBreaker()
+
+	b.Failure()
+	if ok, _, _ := b.Allow(); !ok || b.State() != BreakerClosed {
+		t.Fatalf("breaker refused a call below the threshold, state %s", b.State())
+	}
+	b.Failure()
+	ok, probe, wait := b.Allow()
+	if ok || probe {
+		t.Fatalf("Allow() = %v, %v after reaching the threshold, want a refusal", ok, probe)
+	}
+	if wait <= 0 || wait > time.Minute {
+		t.Errorf("wait = %s, want within the cooldown", wait)
+	}
+	if got := b.State(); got != BreakerOpen {
+		t.Errorf("state = %s, want %s", got, BreakerOpen)
+	}
+}
+
+func TestCircuitBreakerSuccessResets(t *testing.T) {
+	b := testBreaker()
+	b.Failure()
+	b.Success()
+	b.Failure()
+	if got := b.State(); got != BreakerClosed {
+		t.Errorf("state = %s, want %s: failures must be consecutive", got, BreakerClosed)
+	}
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	tests := []struct {
+		name      string
+		resolve   func(*CircuitBreaker)
+		wantState string
+		wantAllow bool
+	}{
+		{name: "success closes", resolve: (*CircuitBreaker).Success, wantState: BreakerClosed, wantAllow: true},
+		{name: "failure reopens", resolve: (*CircuitBreaker).Failure, wantState: BreakerOpen, wantAllow: false},
+		{name: "release lets the next call probe", resolve: (*CircuitBreaker).Release, wantState: BreakerHalfOpen, wantAllow: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			b := testBreaker()
+			b.Failure()
+			b.Failure()
+			expireCooldown(b)
+
+			if got := b.State(); got != BreakerHalfOpen {
+				t.Fatalf("state = %s, want %s", got, BreakerHalfOpen)
+			}
+			ok, probe, _ := b.Allow()
+			if !ok || !probe {
+				t.Fatalf("Allow() = %v, %v after the cooldown, want a probe", ok, probe)
+			}
+			if ok, _, _ := b.Allow(); ok {
+				t.Fatalf("a second call got through while the probe was running")
+			}
+
+			tt.resolve(b)
+			if got := b.State(); got != tt.wantState {
+				t.Errorf("state = %s, want %s", got, tt.wantState)
+			}
+			if ok, _, _ := b.Allow(); ok != tt.wantAllow {
+				t.Errorf("Allow() after resolving = %v, want %v", ok, tt.wantAllow)
+			}
+		})
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestResilientTransportResolvesProbe(t *testing.T) {
+	tests := []struct {
+		name string
+		base roundTripFunc
+	}{
+		{
+			name: "canceled by the caller",
+			base: func(req *http.Request) (*http.Response, error) {
+				return nil, context.Canceled
+			},
+		},
+		{
+			name: "rate limited",
+			base: func(req *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusTooManyRequests, Body: http.NoBody, Header: http.Header{}}, nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &ResilientTransport{
+				Service: "test",
+				Base:    tt.base,
+				Retry:   RetryPolicy{MaxAttempts: 1},
+				Breaker: testBreaker(),
+			}
+			transport.Breaker.Failure()
+			transport.Breaker.Failure()
+			expireCooldown(transport.Breaker)
+
+			req, _ := http.NewRequest(http.MethodGet, "http://dependency/", nil)
+			if resp, err := transport.RoundTrip(req); err == nil {
+				resp.Body.Close()
+			}
+
+			if ok, probe, _ := transport.Breaker.Allow(); !ok || !probe {
+				t.Errorf("Allow() = %v, %v after an inconclusive probe, want another probe", ok, probe)
+			}
+		})
+	}
+}
+
+func TestResilientTransportCancelDuringBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	transport := &ResilientTransport{
+		Service: "test",
+		Base: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			time.AfterFunc(10*time.Millisecond, cancel)
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: http.NoBody, Header: http.Header{}}, nil
+		}),
+		Retry:   RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Minute},
+		Breaker: NewCircuitBreaker(BreakerPolicy{FailureThreshold: 1, Cooldown: time.Minute}),
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://dependency/", nil)
+	_, err := transport.RoundTrip(req)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if got := transport.Breaker.State(); got != BreakerClosed {
+		t.Errorf("state = %s, want %s: a caller canceling is not a failure", got, BreakerClosed)
+	}
+}
@@ -0,0 +1,404 @@
+// Package config loads the settings of the API. Every setting has a
+// default, may be set in an optional YAML file named by CONFIG_FILE and may
+// be overridden by its environment variable. The result is validated once at
+// startup and handed to the constructors that need it.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+
+	"iara-assistant/clients"
+	"iara-assistant/logging"
+	"iara-assistant/services"
+)
+
+// FileEnv names the environment variable holding the path of the optional
+// YAML file.
+const FileEnv = "CONFIG_FILE"
+
+// Where a setting's value came from.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+)
+
+type Config struct {
+	Port    string
+	DataDir string
+	Log     logging.Config
+
+	Gemini     clients.GoogleAIConfig
+	ChromaDB   clients.ChromaDBConfig
+	Retrieval  services.RetrievalConfig
+	Prompts    services.PromptConfig
+	Caches     services.CacheConfig
+	Models     services.ModelConfig
+	Quotas     services.QuotaConfig
+	RateLimits services.RateLimitConfig
+	Backups    services.BackupConfig
+	Cron       services.CronConfig
+	Crawler    services.CrawlerConfig
+
+	Auth     AuthConfig
+	Webhooks WebhookConfig
+	Vault    VaultConfig
+
+	file    string
+	sources map[string]string
+}
+
+// AuthConfig holds the bootstrap admin key. It is never written to disk: the
+// API key store keeps only its hash in memory and accepts it as a key with
+// the admin scope.
+type AuthConfig struct {
+	AdminAPIKey string
+}
+
+// WebhookConfig holds the secrets webhook requests are checked against. An
+// empty secret disables the check.
+type WebhookConfig struct {
+	N8NSecret          string
+	N8NSignatureWindow time.Duration
+	TelegramSecret     string
+}
+
+// VaultConfig names the Obsidian vault to keep in sync, if any, and the user
+// its notes are learned for. /v1/vault/sync only reads vaults inside Roots or
+// Path.
+type VaultConfig struct {
+	Path   string
+	UserID string
+	Roots  []string
+}
+
+// AllowedRoots lists the directories vaults may be synced from.
+func (v VaultConfig) AllowedRoots() []string {
+	roots := append([]string(nil), v.Roots...)
+	if v.Path != "" {
+		roots = append(roots, v.Path)
+	}
+	return roots
+}
+
+func Default() *Config {
+	return &Config{
+		Port:       "8080",
+		DataDir:    "data",
+		Log:        logging.DefaultConfig(),
+		Gemini:     clients.DefaultGoogleAIConfig(),
+		ChromaDB:   clients.DefaultChromaDBConfig(),
+		Retrieval:  services.DefaultRetrievalConfig(),
+		Prompts:    services.DefaultPromptConfig(),
+		Caches:     services.DefaultCacheConfig(),
+		Models:     services.DefaultModelConfig(),
+		Quotas:     services.DefaultQuotaConfig(),
+		RateLimits: services.DefaultRateLimitConfig(),
+		Backups:    services.DefaultBackupConfig(),
+		Cron:       services.DefaultCronConfig(),
+		Crawler:    services.DefaultCrawlerConfig(),
+		Webhooks: WebhookConfig{
+			N8NSignatureWindow: services.DefaultSignatureWindow,
+		},
+	}
+}
+
+// Load reads the YAML file named by CONFIG_FILE, if set, then the
+// environment, and validates the result. All problems are reported at once.
+func Load() (*Config, error) {
+	return load(os.Getenv(FileEnv), os.LookupEnv)
+}
+
+func load(file string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
+	cfg.file = file
+	cfg.sources = make(map[string]string)
+
+	settings := cfg.settings()
+	byKey := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+
+	var errs []error
+	if file != "" {
+		values, err := readFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range values {
+			s, ok := byKey[key]
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s: unknown setting %q", file, key))
+				continue
+			}
+			if err := s.value.Set(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", file, key, err))
+				continue
+			}
+			cfg.sources[key] = SourceFile
+		}
+	}
+
+	for _, s := range settings {
+		value, ok := lookupEnv(s.env)
+		if !ok || value == "" {
+			continue
+		}
+		if err := s.value.Set(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+			continue
+		}
+		cfg.sources[s.key] = SourceEnv
+	}
+
+	if len(errs) == 0 {
+		errs = cfg.validate()
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return cfg, nil
+}
+
+// readFile flattens a YAML file into dotted keys, so that
+//
+//	retrieval:
+//	  top_k: 5
+//
+// sets retrieval.top_k. Lists are read like the comma-separated lists of the
+// environment.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var tree map[string]interface{}
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	var flatten func(prefix string, node map[string]interface{})
+	flatten = func(prefix string, node map[string]interface{}) {
+		for key, value := range node {
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			switch v := value.(type) {
+			case map[string]interface{}:
+				flatten(key, v)
+			case []interface{}:
+				items := make([]string, len(v))
+				for i, item := range v {
+					items[i] = fmt.Sprint(item)
+				}
+				values[key] = strings.Join(items, ",")
+			case nil:
+				values[key] = ""
+			default:
+				values[key] = fmt.Sprint(v)
+			}
+		}
+	}
+	flatten("", tree)
+	return values, nil
+}
+
+// Setting is one entry of the effective configuration. Secrets are masked.
+type Setting struct {
+	Key    string `json:"key"`
+	Env    string `json:"env"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	Secret bool   `json:"secret,omitempty"`
+}
+
+// MaskedSecret replaces the value of a secret that is set.
+const MaskedSecret = "********"
+
+// Effective lists every setting with its value and where it came from.
+func (c *Config) Effective() []Setting {
+	settings := c.settings()
+	effective := make([]Setting, len(settings))
+	for i, s := range settings {
+		value := s.value.String()
+		if s.secret && value != "" {
+			value = MaskedSecret
+		}
+		source := c.sources[s.key]
+		if source == "" {
+			source = SourceDefault
+		}
+		effective[i] = Setting{Key: s.key, Env: s.env, Value: value, Source: source, Secret: s.secret}
+	}
+	return effective
+}
+
+// File returns the YAML file the configuration was read from, if any.
+func (c *Config) File() string {
+	return c.file
+}
+
+// RAG returns the settings of the RAG service.
+func (c *Config) RAG() services.RAGConfig {
+	return services.RAGConfig{
+		DataDir:   c.DataDir,
+		Gemini:    c.Gemini,
+		ChromaDB:  c.ChromaDB,
+		Retrieval: c.Retrieval,
+		Prompts:   c.Prompts,
+		Caches:    c.Caches,
+		Models:    c.Models,
+		Quotas:    c.Quotas,
+	}
+}
+
+// validate checks every setting and returns one error per problem.
+func (c *Config) validate() []error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("server.port: %q is not a valid port", c.Port))
+	}
+	check(c.DataDir != "", "data_dir: must be set")
+	check(c.Log.Format == logging.FormatText || c.Log.Format == logging.FormatJSON, "log.format: must be %s or %s, got %q", logging.FormatText, logging.FormatJSON, c.Log.Format)
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log.level: must be debug, info, warn or error, got %q", c.Log.Level))
+	}
+
+	check(c.Gemini.APIKey != "", "gemini.api_key: must be set (GOOGLE_API_KEY)")
+	errs = appendErr(errs, "gemini.base_url", checkURL(c.Gemini.BaseURL))
+	check(c.Gemini.MaxOutputTokens > 0, "gemini.max_output_tokens: must be positive")
+	check(c.Gemini.Timeout > 0, "gemini.timeout: must be positive")
+
+	errs = appendErr(errs, "chromadb.url", checkURL(c.ChromaDB.URL))
+	check(c.ChromaDB.ConnectRetries > 0, "chromadb.connect_retries: must be positive")
+	check(c.ChromaDB.ConnectRetryDelay >= 0, "chromadb.connect_retry_delay: must not be negative")
+	check(c.ChromaDB.Timeout > 0, "chromadb.timeout: must be positive")
+
+	r := c.Retrieval
+	check(r.VectorTopK >= 0, "retrieval.vector_top_k: must not be negative")
+	check(r.KeywordTopK >= 0, "retrieval.keyword_top_k: must not be negative")
+	check(r.VectorTopK+r.KeywordTopK > 0, "retrieval: vector_top_k and keyword_top_k cannot both be zero")
+	check(r.TopK > 0, "retrieval.top_k: must be positive")
+	check(r.VectorWeight >= 0, "retrieval.vector_weight: must not be negative")
+	check(r.KeywordWeight >= 0, "retrieval.keyword_weight: must not be negative")
+	check(r.RRFK > 0, "retrieval.rrf_k: must be positive")
+	check(r.RerankCandidates >= 0, "retrieval.rerank_candidates: must not be negative")
+	check(oneOf(r.Reranker, services.RerankerLLM, services.RerankerLocal, services.RerankerNone), "retrieval.reranker: must be %s, %s or %s, got %q", services.RerankerLLM, services.RerankerLocal, services.RerankerNone, r.Reranker)
+	check(r.ContextTokenBudget > 0, "retrieval.context_token_budget: must be positive")
+	check(r.EmbeddingTimeout >= 0, "retrieval.embedding_timeout: must not be negative")
+	check(r.RetrievalTimeout >= 0, "retrieval.timeout: must not be negative")
+	check(r.RerankTimeout >= 0, "retrieval.rerank_timeout: must not be negative")
+
+	p := c.Prompts
+	check(p.MaxInputTokens > 0, "prompts.max_input_tokens: must be positive")
+	check(p.MaxOutputTokens > 0, "prompts.max_output_tokens: must be positive")
+	check(p.HistoryShare >= 0 && p.HistoryShare < 1, "prompts.history_share: must be at least 0 and below 1")
+	check(p.HistoryTurns >= 0, "prompts.history_turns: must not be negative")
+	check(oneOf(p.TokenCounter, services.TokenCounterEstimate, services.TokenCounterGemini), "prompts.token_counter: must be %s or %s, got %q", services.TokenCounterEstimate, services.TokenCounterGemini, p.TokenCounter)
+	if p.TemplateDir != "" {
+		info, err := os.Stat(p.TemplateDir)
+		check(err == nil && info.IsDir(), "prompts.template_dir: %q is not a directory", p.TemplateDir)
+	}
+	check(p.GenerationTimeout >= 0, "prompts.generation_timeout: must not be negative")
+
+	check(c.Caches.EmbeddingCacheSize >= 0, "caches.embedding_size: must not be negative")
+	check(c.Caches.AnswerCacheDistance >= 0, "caches.answer_distance: must not be negative")
+	check(!c.Caches.AnswerCache || c.Caches.AnswerCacheTTL > 0, "caches.answer_ttl_minutes: must be positive when the answer cache is enabled")
+
+	check(c.Models.Embedding != "", "models.embedding: must be set")
+	check(len(c.Models.Answer) > 0, "models.answer: must list at least one model")
+
+	check(c.Quotas.DailyLLMCalls >= 0, "quotas.daily_llm_calls: must not be negative")
+	check(c.Quotas.DailyTokens >= 0, "quotas.daily_tokens: must not be negative")
+
+	l := c.RateLimits
+	check(l.KeyPerMinute >= 0, "rate_limits.key_per_minute: must not be negative")
+	check(l.KeyPerMinute == 0 || l.KeyBurst > 0, "rate_limits.key_burst: must be positive when the key limit is enabled")
+	check(l.UserPerMinute >= 0, "rate_limits.user_per_minute: must not be negative")
+	check(l.UserPerMinute == 0 || l.UserBurst > 0, "rate_limits.user_burst: must be positive when the user limit is enabled")
+
+	check(c.Backups.Keep >= 0, "backups.keep: must not be negative")
+
+	if _, err := time.LoadLocation(c.Cron.Timezone); err != nil {
+		errs = append(errs, fmt.Errorf("cron.timezone: %w", err))
+	}
+	errs = appendErr(errs, "cron.crawler", checkSchedule(c.Cron.Crawler))
+	errs = appendErr(errs, "cron.url_refresh", checkSchedule(c.Cron.URLRefresh))
+	if c.Cron.Backup != services.BackupScheduleOff {
+		errs = appendErr(errs, "cron.backup", checkSchedule(c.Cron.Backup))
+	}
+
+	errs = appendErr(errs, "crawler.url", checkURL(c.Crawler.URL))
+	if c.Crawler.WebhookURL != "" {
+		errs = appendErr(errs, "crawler.webhook_url", checkURL(c.Crawler.WebhookURL))
+	}
+	check(len(c.Crawler.Keywords) > 0, "crawler.keywords: must list at least one keyword")
+	check(c.Crawler.Timeout > 0, "crawler.timeout: must be positive")
+	check(c.Crawler.MaxAge > 0, "crawler.max_age: must be positive")
+
+	check(c.Webhooks.N8NSignatureWindow > 0, "webhooks.n8n_signature_window: must be positive")
+
+	if c.Vault.Path != "" {
+		info, err := os.Stat(c.Vault.Path)
+		check(err == nil && info.IsDir(), "vault.path: %q is not a directory", c.Vault.Path)
+	}
+	for _, root := range c.Vault.Roots {
+		info, err := os.Stat(root)
+		check(err == nil && info.IsDir(), "vault.roots: %q is not a directory", root)
+	}
+
+	return errs
+}
+
+func appendErr(errs []error, key string, err error) []error {
+	if err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", key, err))
+	}
+	return errs
+}
+
+func checkURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an http(s) URL", raw)
+	}
+	return nil
+}
+
+func checkSchedule(spec string) error {
+	if _, err := cron.ParseStandard(spec); err != nil {
+		return fmt.Errorf("%q is not a valid cron schedule: %w", spec, err)
+	}
+	return nil
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
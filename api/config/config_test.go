@@ -0,0 +1,132 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// env returns a lookupEnv serving vars, with the API key every valid
+// configuration needs.
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		if value, ok := vars[name]; ok {
+			return value, true
+		}
+		if name == "GOOGLE_API_KEY" {
+			return "test-key", true
+		}
+		return "", false
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func effective(cfg *Config, key string) Setting {
+	for _, s := range cfg.Effective() {
+		if s.Key == key {
+			return s
+		}
+	}
+	return Setting{}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string
+		env        map[string]string
+		key        string
+		wantValue  string
+		wantSource string
+	}{
+		{name: "default", key: "retrieval.top_k", wantValue: "3", wantSource: SourceDefault},
+		{name: "nested file value", file: "retrieval:\n  top_k: 7\n", key: "retrieval.top_k", wantValue: "7", wantSource: SourceFile},
+		{name: "file list", file: "crawler:\n  keywords: [edital, convocação]\n", key: "crawler.keywords", wantValue: "edital,convocação", wantSource: SourceFile},
+		{name: "env overrides file", file: "retrieval:\n  top_k: 7\n", env: map[string]string{"RETRIEVAL_TOP_K": "9"}, key: "retrieval.top_k", wantValue: "9", wantSource: SourceEnv},
+		{name: "empty env is ignored", file: "server:\n  port: \"9090\"\n", env: map[string]string{"PORT": ""}, key: "server.port", wantValue: "9090", wantSource: SourceFile},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var file string
+			if tt.file != "" {
+				file = writeConfigFile(t, tt.file)
+			}
+			cfg, err := load(file, env(tt.env))
+			if err != nil {
+				t.Fatalf("load() err = %v", err)
+			}
+			got := effective(cfg, tt.key)
+			if got.Value != tt.wantValue || got.Source != tt.wantSource {
+				t.Errorf("%s = %q from %s, want %q from %s", tt.key, got.Value, got.Source, tt.wantValue, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		wantErr []string
+	}{
+		{name: "unknown setting", file: "retrieval:\n  topk: 7\n", wantErr: []string{`unknown setting "retrieval.topk"`}},
+		{name: "bad file value", file: "retrieval:\n  top_k: many\n", wantErr: []string{"retrieval.top_k"}},
+		{name: "bad env value", env: map[string]string{"RETRIEVAL_TOP_K": "many"}, wantErr: []string{"RETRIEVAL_TOP_K"}},
+		{name: "missing API key", env: map[string]string{"GOOGLE_API_KEY": ""}, wantErr: []string{"gemini.api_key: must be set"}},
+		{
+			name:    "every problem at once",
+			env:     map[string]string{"PORT": "99999", "LOG_FORMAT": "xml"},
+			wantErr: []string{`server.port: "99999" is not a valid port`, `log.format: must be text or json, got "xml"`},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var file string
+			if tt.file != "" {
+				file = writeConfigFile(t, tt.file)
+			}
+			_, err := load(file, env(tt.env))
+			if err == nil {
+				t.Fatal("load() err = nil, want an error")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("err = %q, want it to mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEffectiveMasksSecrets(t *testing.T) {
+	cfg, err := load("", env(map[string]string{"ADMIN_API_KEY": "super-secret"}))
+	if err != nil {
+		t.Fatalf("load() err = %v", err)
+	}
+
+	for _, s := range cfg.Effective() {
+		if strings.Contains(s.Value, "super-secret") || strings.Contains(s.Value, "test-key") {
+			t.Errorf("%s leaks its value %q", s.Key, s.Value)
+		}
+	}
+	if got := effective(cfg, "gemini.api_key"); got.Value != MaskedSecret || !got.Secret {
+		t.Errorf("gemini.api_key = %+v, want it masked", got)
+	}
+	if got := effective(cfg, "crawler.webhook_url"); got.Value != "" {
+		t.Errorf("unset secret = %q, want it empty", got.Value)
+	}
+}
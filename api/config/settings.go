@@ -0,0 +1,220 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting binds a YAML key and an environment variable to a field of
+// Config.
+type setting struct {
+	key    string
+	env    string
+	secret bool
+	value  value
+}
+
+// value parses a setting into the field it points to and formats it back,
+// like flag.Value.
+type value interface {
+	Set(string) error
+	String() string
+}
+
+// settings lists every setting of c. Environment variable names are the ones
+// the API read before it had a config file, so existing deployments keep
+// working.
+func (c *Config) settings() []setting {
+	return []setting{
+		{key: "server.port", env: "PORT", value: (*stringValue)(&c.Port)},
+		{key: "data_dir", env: "DATA_DIR", value: (*stringValue)(&c.DataDir)},
+		{key: "log.format", env: "LOG_FORMAT", value: (*stringValue)(&c.Log.Format)},
+		{key: "log.level", env: "LOG_LEVEL", value: (*stringValue)(&c.Log.Level)},
+		{key: "log.identifier_key", env: "LOG_IDENTIFIER_KEY", secret: true, value: (*stringValue)(&c.Log.IdentifierKey)},
+
+		{key: "gemini.api_key", env: "GOOGLE_API_KEY", secret: true, value: (*stringValue)(&c.Gemini.APIKey)},
+		{key: "gemini.base_url", env: "GEMINI_BASE_URL", value: (*stringValue)(&c.Gemini.BaseURL)},
+		{key: "gemini.max_output_tokens", env: "GEMINI_MAX_OUTPUT_TOKENS", value: (*intValue)(&c.Gemini.MaxOutputTokens)},
+		{key: "gemini.timeout", env: "GEMINI_TIMEOUT", value: (*durationValue)(&c.Gemini.Timeout)},
+
+		{key: "chromadb.url", env: "CHROMADB_URL", value: (*stringValue)(&c.ChromaDB.URL)},
+		{key: "chromadb.connect_retries", env: "CHROMADB_CONNECT_RETRIES", value: (*intValue)(&c.ChromaDB.ConnectRetries)},
+		{key: "chromadb.connect_retry_delay", env: "CHROMADB_CONNECT_RETRY_DELAY", value: (*durationValue)(&c.ChromaDB.ConnectRetryDelay)},
+		{key: "chromadb.timeout", env: "CHROMADB_TIMEOUT", value: (*durationValue)(&c.ChromaDB.Timeout)},
+
+		{key: "retrieval.vector_top_k", env: "RETRIEVAL_VECTOR_TOP_K", value: (*intValue)(&c.Retrieval.VectorTopK)},
+		{key: "retrieval.keyword_top_k", env: "RETRIEVAL_KEYWORD_TOP_K", value: (*intValue)(&c.Retrieval.KeywordTopK)},
+		{key: "retrieval.top_k", env: "RETRIEVAL_TOP_K", value: (*intValue)(&c.Retrieval.TopK)},
+		{key: "retrieval.vector_weight", env: "RETRIEVAL_VECTOR_WEIGHT", value: (*floatValue)(&c.Retrieval.VectorWeight)},
+		{key: "retrieval.keyword_weight", env: "RETRIEVAL_KEYWORD_WEIGHT", value: (*floatValue)(&c.Retrieval.KeywordWeight)},
+		{key: "retrieval.rrf_k", env: "RETRIEVAL_RRF_K", value: (*floatValue)(&c.Retrieval.RRFK)},
+		{key: "retrieval.rerank_candidates", env: "RETRIEVAL_RERANK_CANDIDATES", value: (*intValue)(&c.Retrieval.RerankCandidates)},
+		{key: "retrieval.reranker", env: "RERANKER", value: (*stringValue)(&c.Retrieval.Reranker)},
+		{key: "retrieval.min_rerank_score", env: "RETRIEVAL_MIN_RERANK_SCORE", value: (*floatValue)(&c.Retrieval.MinRerankScore)},
+		{key: "retrieval.context_token_budget", env: "RETRIEVAL_CONTEXT_TOKEN_BUDGET", value: (*intValue)(&c.Retrieval.ContextTokenBudget)},
+		{key: "retrieval.embedding_timeout", env: "EMBEDDING_TIMEOUT", value: (*durationValue)(&c.Retrieval.EmbeddingTimeout)},
+		{key: "retrieval.timeout", env: "RETRIEVAL_TIMEOUT", value: (*durationValue)(&c.Retrieval.RetrievalTimeout)},
+		{key: "retrieval.rerank_timeout", env: "RERANK_TIMEOUT", value: (*durationValue)(&c.Retrieval.RerankTimeout)},
+
+		{key: "prompts.max_input_tokens", env: "PROMPT_MAX_INPUT_TOKENS", value: (*intValue)(&c.Prompts.MaxInputTokens)},
+		{key: "prompts.max_output_tokens", env: "PROMPT_MAX_OUTPUT_TOKENS", value: (*intValue)(&c.Prompts.MaxOutputTokens)},
+		{key: "prompts.history_share", env: "PROMPT_HISTORY_SHARE", value: (*floatValue)(&c.Prompts.HistoryShare)},
+		{key: "prompts.history_turns", env: "PROMPT_HISTORY_TURNS", value: (*intValue)(&c.Prompts.HistoryTurns)},
+		{key: "prompts.summarize_history", env: "PROMPT_SUMMARIZE_HISTORY", value: (*boolValue)(&c.Prompts.SummarizeHistory)},
+		{key: "prompts.token_counter", env: "TOKEN_COUNTER", value: (*stringValue)(&c.Prompts.TokenCounter)},
+		{key: "prompts.template_dir", env: "PROMPTS_DIR", value: (*stringValue)(&c.Prompts.TemplateDir)},
+		{key: "prompts.generation_timeout", env: "GENERATION_TIMEOUT", value: (*durationValue)(&c.Prompts.GenerationTimeout)},
+
+		{key: "caches.embedding_size", env: "EMBEDDING_CACHE_SIZE", value: (*intValue)(&c.Caches.EmbeddingCacheSize)},
+		{key: "caches.answer", env: "ANSWER_CACHE", value: (*boolValue)(&c.Caches.AnswerCache)},
+		{key: "caches.answer_distance", env: "ANSWER_CACHE_DISTANCE", value: (*floatValue)(&c.Caches.AnswerCacheDistance)},
+		{key: "caches.answer_ttl_minutes", env: "ANSWER_CACHE_TTL_MINUTES", value: (*minutesValue)(&c.Caches.AnswerCacheTTL)},
+
+		{key: "models.embedding", env: "EMBEDDING_MODEL", value: (*stringValue)(&c.Models.Embedding)},
+		{key: "models.answer", env: "MODELS_ANSWER", value: (*listValue)(&c.Models.Answer)},
+		{key: "models.rerank", env: "MODELS_RERANK", value: (*listValue)(&c.Models.Rerank)},
+		{key: "models.classify", env: "MODELS_CLASSIFY", value: (*listValue)(&c.Models.Classify)},
+		{key: "models.summarize", env: "MODELS_SUMMARIZE", value: (*listValue)(&c.Models.Summarize)},
+
+		{key: "quotas.daily_llm_calls", env: "QUOTA_DAILY_LLM_CALLS", value: (*int64Value)(&c.Quotas.DailyLLMCalls)},
+		{key: "quotas.daily_tokens", env: "QUOTA_DAILY_TOKENS", value: (*int64Value)(&c.Quotas.DailyTokens)},
+
+		{key: "rate_limits.key_per_minute", env: "RATE_LIMIT_KEY_PER_MINUTE", value: (*floatValue)(&c.RateLimits.KeyPerMinute)},
+		{key: "rate_limits.key_burst", env: "RATE_LIMIT_KEY_BURST", value: (*intValue)(&c.RateLimits.KeyBurst)},
+		{key: "rate_limits.user_per_minute", env: "RATE_LIMIT_USER_PER_MINUTE", value: (*floatValue)(&c.RateLimits.UserPerMinute)},
+		{key: "rate_limits.user_burst", env: "RATE_LIMIT_USER_BURST", value: (*intValue)(&c.RateLimits.UserBurst)},
+
+		{key: "backups.dir", env: "BACKUP_DIR", value: (*stringValue)(&c.Backups.Dir)},
+		{key: "backups.keep", env: "BACKUP_KEEP", value: (*intValue)(&c.Backups.Keep)},
+		{key: "backups.include_embeddings", env: "BACKUP_EMBEDDINGS", value: (*boolValue)(&c.Backups.IncludeEmbeddings)},
+
+		{key: "cron.timezone", env: "CRON_TIMEZONE", value: (*stringValue)(&c.Cron.Timezone)},
+		{key: "cron.crawler", env: "CRAWLER_SCHEDULE", value: (*stringValue)(&c.Cron.Crawler)},
+		{key: "cron.url_refresh", env: "URL_REFRESH_SCHEDULE", value: (*stringValue)(&c.Cron.URLRefresh)},
+		{key: "cron.backup", env: "BACKUP_SCHEDULE", value: (*stringValue)(&c.Cron.Backup)},
+
+		{key: "crawler.url", env: "CRAWLER_URL", value: (*stringValue)(&c.Crawler.URL)},
+		// The webhook URL carries the token n8n authenticates the crawler by.
+		{key: "crawler.webhook_url", env: "CRAWLER_WEBHOOK_URL", secret: true, value: (*stringValue)(&c.Crawler.WebhookURL)},
+		{key: "crawler.keywords", env: "CRAWLER_KEYWORDS", value: (*listValue)(&c.Crawler.Keywords)},
+		{key: "crawler.timeout", env: "CRAWLER_TIMEOUT", value: (*durationValue)(&c.Crawler.Timeout)},
+		{key: "crawler.max_age", env: "CRAWLER_MAX_AGE", value: (*durationValue)(&c.Crawler.MaxAge)},
+
+		{key: "auth.admin_api_key", env: "ADMIN_API_KEY", secret: true, value: (*stringValue)(&c.Auth.AdminAPIKey)},
+
+		{key: "webhooks.n8n_secret", env: "N8N_WEBHOOK_SECRET", secret: true, value: (*stringValue)(&c.Webhooks.N8NSecret)},
+		{key: "webhooks.n8n_signature_window", env: "N8N_SIGNATURE_WINDOW", value: (*durationValue)(&c.Webhooks.N8NSignatureWindow)},
+		{key: "webhooks.telegram_secret", env: "TELEGRAM_WEBHOOK_SECRET", secret: true, value: (*stringValue)(&c.Webhooks.TelegramSecret)},
+
+		{key: "vault.path", env: "VAULT_PATH", value: (*stringValue)(&c.Vault.Path)},
+		{key: "vault.user_id", env: "VAULT_USER_ID", value: (*stringValue)(&c.Vault.UserID)},
+		{key: "vault.roots", env: "VAULT_ROOTS", value: (*listValue)(&c.Vault.Roots)},
+	}
+}
+
+type stringValue string
+
+func (v *stringValue) Set(s string) error {
+	*v = stringValue(strings.TrimSpace(s))
+	return nil
+}
+
+func (v *stringValue) String() string { return string(*v) }
+
+type intValue int
+
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("%q is not an integer", s)
+	}
+	*v = intValue(n)
+	return nil
+}
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+
+type int64Value int64
+
+func (v *int64Value) Set(s string) error {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not an integer", s)
+	}
+	*v = int64Value(n)
+	return nil
+}
+
+func (v *int64Value) String() string { return strconv.FormatInt(int64(*v), 10) }
+
+type floatValue float64
+
+func (v *floatValue) Set(s string) error {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", s)
+	}
+	*v = floatValue(f)
+	return nil
+}
+
+func (v *floatValue) String() string { return strconv.FormatFloat(float64(*v), 'g', -1, 64) }
+
+type boolValue bool
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("%q is not true or false", s)
+	}
+	*v = boolValue(b)
+	return nil
+}
+
+func (v *boolValue) String() string { return strconv.FormatBool(bool(*v)) }
+
+type durationValue time.Duration
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("%q is not a duration such as 30s or 5m", s)
+	}
+	*v = durationValue(d)
+	return nil
+}
+
+func (v *durationValue) String() string { return time.Duration(*v).String() }
+
+// minutesValue is a duration given as a whole number of minutes.
+type minutesValue time.Duration
+
+func (v *minutesValue) Set(s string) error {
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("%q is not a number of minutes", s)
+	}
+	*v = minutesValue(time.Duration(n) * time.Minute)
+	return nil
+}
+
+func (v *minutesValue) String() string { return strconv.Itoa(int(time.Duration(*v) / time.Minute)) }
+
+// listValue is a comma-separated list, such as an ordered list of models.
+// Blank items are dropped.
+type listValue []string
+
+func (v *listValue) Set(s string) error {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*v = list
+	return nil
+}
+
+func (v *listValue) String() string { return strings.Join(*v, ",") }
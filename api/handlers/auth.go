@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iara-assistant/logging"
+	"iara-assistant/services"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type apiKeyContextKey struct{}
+
+type createAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	UserID string   `json:"user_id,omitempty"`
+}
+
+type createAPIKeyResponse struct {
+	services.APIKey
+	Key string `json:"key"`
+}
+
+// RequireScope only lets requests through that carry an active API key with
+// the given scope, either as "Authorization: Bearer <key>" or in X-API-Key.
+// An empty scope admits any active key. Requests are drawn from the key's
+// rate limit and counted towards its usage.
+func (s *Server) RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := s.apiKeys.Authenticate(requestAPIKey(r))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="iara"`)
+			sendError(w, "Missing or invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if scope != "" && !key.HasScope(scope) {
+			sendError(w, fmt.Sprintf("API key lacks the %s scope", scope), http.StatusForbidden)
+			return
+		}
+		if allowed, wait := s.keyLimiter.Allow(key.ID); !allowed {
+			sendRateLimited(w, "Too many requests for this API key", wait)
+			return
+		}
+
+		subject := services.UsageKeySubject(key.ID)
+		s.usage.CountRequest(subject)
+		ctx := context.WithValue(r.Context(), apiKeyContextKey{}, key)
+		next(w, r.WithContext(services.WithUsageSubject(ctx, subject)))
+	}
+}
+
+func requestAPIKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, token, found := strings.Cut(auth, " ")
+		if found && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// bindUserID applies the user an API key is bound to. An empty userID takes
+// the bound user; naming another user is refused with a 403, in which case
+// bindUserID reports false.
+func bindUserID(w http.ResponseWriter, r *http.Request, userID *string) bool {
+	key, ok := r.Context().Value(apiKeyContextKey{}).(services.APIKey)
+	if !ok || key.UserID == "" {
+		return true
+	}
+	if *userID != "" && *userID != key.UserID {
+		sendError(w, "API key is bound to another user", http.StatusForbidden)
+		return false
+	}
+	*userID = key.UserID
+	return true
+}
+
+// limitUser draws a request of userID from the per-user rate limit and counts
+// it towards the user's usage. It reports false after sending a 429.
+func (s *Server) limitUser(w http.ResponseWriter, userID string) bool {
+	if userID == "" {
+		return true
+	}
+	if allowed, wait := s.userLimiter.Allow(userID); !allowed {
+		sendRateLimited(w, "Too many requests for this user", wait)
+		return false
+	}
+	s.usage.CountRequest(services.UsageUserSubject(userID))
+	return true
+}
+
+func sendRateLimited(w http.ResponseWriter, message string, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds())))))
+	sendError(w, message, http.StatusTooManyRequests)
+}
+
+// UsageHandler reports today's usage of the calling key and, with ?user_id=,
+// of a user. A key bound to a user can only ask for that user; other keys
+// need the admin scope to read a user's usage. Admin keys can list every key
+// and user with ?all=true.
+func (s *Server) UsageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key, _ := r.Context().Value(apiKeyContextKey{}).(services.APIKey)
+	if r.URL.Query().Get("all") == "true" {
+		if !key.HasScope(services.ScopeAdmin) {
+			sendError(w, "Listing all usage requires the admin scope", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.usage.Report())
+		return
+	}
+
+	subjects := []string{services.UsageKeySubject(key.ID)}
+	userID := r.URL.Query().Get("user_id")
+	if !bindUserID(w, r, &userID) {
+		return
+	}
+	if userID != "" && userID != key.UserID && !key.HasScope(services.ScopeAdmin) {
+		sendError(w, "Reading another user's usage requires the admin scope", http.StatusForbidden)
+		return
+	}
+	if userID != "" {
+		subjects = append(subjects, services.UsageUserSubject(userID))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.usage.Report(subjects...))
+}
+
+// APIKeysHandler lists keys (GET), creates one (POST) and revokes one
+// (DELETE ?id=). A created key's secret is only returned once.
+func (s *Server) APIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.apiKeys.List()})
+
+	case http.MethodPost:
+		var req createAPIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			slog.WarnContext(r.Context(), "Error decoding API key request", logging.Err(err))
+			sendError(w, "Invalid JSON request", http.StatusBadRequest)
+			return
+		}
+
+		key, secret, err := s.apiKeys.Create(req.Name, req.Scopes, req.UserID)
+		if err != nil {
+			sendError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.InfoContext(r.Context(), "Created API key", "key_id", key.ID, "name", key.Name, "scopes", key.Scopes)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(createAPIKeyResponse{APIKey: key, Key: secret})
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			sendError(w, "Missing key id", http.StatusBadRequest)
+			return
+		}
+
+		found, err := s.apiKeys.Revoke(id)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error revoking API key", logging.Err(err))
+			sendError(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			sendError(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		slog.InfoContext(r.Context(), "Revoked API key", "key_id", id)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "revoked", "id": id})
+
+	default:
+		sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
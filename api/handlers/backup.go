@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iara-assistant/logging"
+	"iara-assistant/services"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// MaxImportSize bounds an uploaded archive. Archives with embeddings are far
+// larger than the documents themselves.
+const MaxImportSize = 1 << 30
+
+// ExportHandler streams an archive of the whole memory. Embeddings are
+// included unless ?embeddings=false.
+func (s *Server) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Large archives take longer than the server's write timeout.
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		slog.WarnContext(r.Context(), "Failed to lift write deadline for export", logging.Err(err))
+	}
+
+	includeEmbeddings := r.URL.Query().Get("embeddings") != "false"
+	filename := fmt.Sprintf("%s%s.zip", services.BackupFilePrefix, time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	// Once the archive starts streaming the status can no longer change, so
+	// a failure only shows up as a truncated archive.
+	if _, err := s.backups.Export(r.Context(), w, includeEmbeddings); err != nil {
+		slog.ErrorContext(r.Context(), "Error exporting archive", logging.Err(err))
+	}
+}
+
+// ImportHandler restores an archive sent as the request body or as the
+// "file" field of a multipart upload. ?mode= is merge (default) or replace.
+func (s *Server) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode != "" && mode != services.ImportModeMerge && mode != services.ImportModeReplace {
+		sendError(w, "Mode must be merge or replace", http.StatusBadRequest)
+		return
+	}
+
+	controller := http.NewResponseController(w)
+	if err := controller.SetReadDeadline(time.Time{}); err != nil {
+		slog.WarnContext(r.Context(), "Failed to lift read deadline for import", logging.Err(err))
+	}
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		slog.WarnContext(r.Context(), "Failed to lift write deadline for import", logging.Err(err))
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxImportSize)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			sendError(w, "Missing archive file", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	// zip needs random access, so the archive is spooled to disk first.
+	tmp, err := os.CreateTemp("", "iara-import-*.zip")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error buffering archive", logging.Err(err))
+		sendError(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			sendError(w, "Archive too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		slog.ErrorContext(r.Context(), "Error reading archive", logging.Err(err))
+		sendError(w, "Failed to read archive", http.StatusBadRequest)
+		return
+	}
+
+	result, err := s.backups.Import(r.Context(), tmp, size, mode)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error importing archive", logging.Err(err))
+		if errors.Is(err, services.ErrReindexRunning) {
+			sendError(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if result == nil {
+			sendError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sendServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"encoding/json"
+	"iara-assistant/config"
+	"net/http"
+)
+
+type configResponse struct {
+	File     string           `json:"file,omitempty"`
+	Settings []config.Setting `json:"settings"`
+}
+
+// ConfigHandler shows the effective configuration: every setting with its
+// value and whether it came from the default, the config file or the
+// environment. Secrets are masked.
+func (s *Server) ConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(configResponse{
+		File:     s.settings.File(),
+		Settings: s.settings.Effective(),
+	})
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"iara-assistant/logging"
+	"iara-assistant/services"
+	"log/slog"
+	"net/http"
+)
+
+// TriggerCrawlerHandler runs the DOM crawler now.
+func (s *Server) TriggerCrawlerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := s.crawler.TriggerCrawler(r.Context()); errors.Is(err, services.ErrCrawlerDisabled) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		slog.ErrorContext(r.Context(), "Manual crawler trigger failed", logging.Err(err))
+		http.Error(w, "Crawler error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "crawler triggered"})
+}
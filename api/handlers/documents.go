@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"iara-assistant/logging"
+	"iara-assistant/services"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+const MaxUploadSize = 20 << 20
+
+type ingestJSONRequest struct {
+	Text   string `json:"text"`
+	Title  string `json:"title,omitempty"`
+	Format string `json:"format,omitempty"`
+	Source string `json:"source,omitempty"`
+	UserID string `json:"user_id,omitempty"`
+}
+
+// IngestHandler accepts either a multipart upload with a "file" (or "text")
+// field, or a JSON body with the document text.
+func (s *Server) IngestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxUploadSize)
+
+	var req services.IngestRequest
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		req, err = parseMultipartIngest(r)
+	} else {
+		req, err = parseJSONIngest(r)
+	}
+	if err != nil {
+		slog.WarnContext(r.Context(), "Error decoding ingest request", logging.Err(err))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			sendError(w, "Document too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !bindUserID(w, r, &req.UserID) || !s.limitUser(w, req.UserID) {
+		return
+	}
+
+	response, err := s.documents.IngestDocument(r.Context(), req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error ingesting document", logging.Err(err))
+		sendServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !response.Success {
+		w.WriteHeader(http.StatusBadRequest)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func parseMultipartIngest(r *http.Request) (services.IngestRequest, error) {
+	if err := r.ParseMultipartForm(MaxUploadSize); err != nil {
+		return services.IngestRequest{}, err
+	}
+
+	req := services.IngestRequest{
+		Title:  r.FormValue("title"),
+		Source: r.FormValue("source"),
+		UserID: r.FormValue("user_id"),
+	}
+
+	var filename, contentType string
+	file, header, err := r.FormFile("file")
+	switch {
+	case err == nil:
+		defer file.Close()
+		req.Content, err = io.ReadAll(file)
+		if err != nil {
+			return req, err
+		}
+		filename = header.Filename
+		contentType = header.Header.Get("Content-Type")
+		if req.Source == "" {
+			req.Source = filename
+		}
+	case errors.Is(err, http.ErrMissingFile):
+		req.Content = []byte(r.FormValue("text"))
+	default:
+		return req, err
+	}
+
+	if len(req.Content) == 0 {
+		return req, errors.New("either a file or text is required")
+	}
+
+	req.Format, err = services.DetectFormat(r.FormValue("format"), filename, contentType)
+	return req, err
+}
+
+func parseJSONIngest(r *http.Request) (services.IngestRequest, error) {
+	var body ingestJSONRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return services.IngestRequest{}, errors.New("invalid JSON request")
+	}
+
+	format, err := services.DetectFormat(body.Format, body.Source, "")
+	if err != nil {
+		return services.IngestRequest{}, err
+	}
+
+	return services.IngestRequest{
+		Title:   body.Title,
+		Source:  body.Source,
+		Format:  format,
+		UserID:  body.UserID,
+		Content: []byte(body.Text),
+	}, nil
+}
+
+// DocumentsHandler lists stored documents (GET, optionally ?user_id=) and
+// deletes a whole document with all its chunks (DELETE ?id=).
+func (s *Server) DocumentsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		userID := r.URL.Query().Get("user_id")
+		if !bindUserID(w, r, &userID) {
+			return
+		}
+		documents, err := s.documents.ListDocuments(r.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error listing documents", logging.Err(err))
+			sendServiceError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"documents": documents})
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			sendError(w, "Missing document id", http.StatusBadRequest)
+			return
+		}
+		if !s.ownsDocument(w, r, id) {
+			return
+		}
+
+		found, err := s.documents.DeleteDocument(r.Context(), id)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error deleting document", logging.Err(err))
+			sendServiceError(w, err)
+			return
+		}
+		if !found {
+			sendError(w, "Document not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "deleted", "document_id": id})
+
+	default:
+		sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ownsDocument checks that a key bound to a user only deletes that user's
+// documents. It reports false after sending the error response.
+func (s *Server) ownsDocument(w http.ResponseWriter, r *http.Request, documentID string) bool {
+	var userID string
+	if !bindUserID(w, r, &userID) {
+		return false
+	}
+	if userID == "" {
+		return true
+	}
+
+	documents, err := s.documents.ListDocuments(r.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error listing documents", logging.Err(err))
+		sendServiceError(w, err)
+		return false
+	}
+	for _, doc := range documents {
+		if doc.ID == documentID {
+			return true
+		}
+	}
+	sendError(w, "Document not found", http.StatusNotFound)
+	return false
+}
+
+// IngestURLHandler learns a web page (POST) and lists the pages that are
+// re-fetched on a schedule (GET), optionally only those of ?user_id=.
+func (s *Server) IngestURLHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		userID := r.URL.Query().Get("user_id")
+		if !bindUserID(w, r, &userID) {
+			return
+		}
+		watched := []services.WatchedURL{}
+		for _, page := range s.documents.WatchedURLs() {
+			if userID == "" || page.UserID == userID {
+				watched = append(watched, page)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"watched": watched})
+		return
+	case http.MethodPost:
+	default:
+		sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req services.IngestURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.WarnContext(r.Context(), "Error decoding ingest URL request", logging.Err(err))
+		sendError(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+	if !bindUserID(w, r, &req.UserID) || !s.limitUser(w, req.UserID) {
+		return
+	}
+
+	response, err := s.documents.IngestURL(r.Context(), req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error ingesting URL", logging.Err(err))
+		sendServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !response.Success {
+		w.WriteHeader(http.StatusBadRequest)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
@@ -1,11 +1,16 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"iara-assistant/clients"
+	"iara-assistant/logging"
 	"iara-assistant/services"
-	"log"
+	"log/slog"
+	"math"
 	"net/http"
-	"os"
+	"strconv"
 )
 
 type ErrorResponse struct {
@@ -13,19 +18,7 @@ type ErrorResponse struct {
 	Message string `json:"message"`
 }
 
-var ragService *services.RAGService
-
-func init() {
-	googleAPIKey := os.Getenv("GOOGLE_API_KEY")
-	chromaDBURL := os.Getenv("CHROMADB_URL")
-	if chromaDBURL == "" {
-		chromaDBURL = "http://chromadb:8000"
-	}
-
-	ragService = services.NewRAGService(googleAPIKey, chromaDBURL)
-}
-
-func MessageHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) MessageHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -33,15 +26,25 @@ func MessageHandler(w http.ResponseWriter, r *http.Request) {
 
 	var req services.MessageRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("Error decoding message request: %v", err)
+		slog.WarnContext(r.Context(), "Error decoding message request", logging.Err(err))
 		sendError(w, "Invalid JSON request", http.StatusBadRequest)
 		return
 	}
+	if !bindUserID(w, r, &req.UserID) || !s.limitUser(w, req.UserID) {
+		return
+	}
+	// Debug output carries the retrieved documents and the prompt.
+	if key, _ := r.Context().Value(apiKeyContextKey{}).(services.APIKey); req.Debug && !key.HasScope(services.ScopeAdmin) {
+		sendError(w, "Debug output requires the admin scope", http.StatusForbidden)
+		return
+	}
 
-	response, err := ragService.ProcessMessage(req)
+	ctx, cancel := context.WithTimeout(r.Context(), MessageTimeout)
+	defer cancel()
+	response, err := s.assistant.ProcessMessage(ctx, req)
 	if err != nil {
-		log.Printf("Error processing message: %v", err)
-		sendError(w, "Internal server error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error processing message", logging.Err(err))
+		sendResponseError(w, response, err)
 		return
 	}
 
@@ -55,7 +58,7 @@ func MessageHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func LearnHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) LearnHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -63,15 +66,18 @@ func LearnHandler(w http.ResponseWriter, r *http.Request) {
 
 	var req services.LearnRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		log.Printf("Error decoding learn request: %v", err)
+		slog.WarnContext(r.Context(), "Error decoding learn request", logging.Err(err))
 		sendError(w, "Invalid JSON request", http.StatusBadRequest)
 		return
 	}
+	if !bindUserID(w, r, &req.UserID) || !s.limitUser(w, req.UserID) {
+		return
+	}
 
-	response, err := ragService.LearnFact(req)
+	response, err := s.assistant.LearnFact(r.Context(), req)
 	if err != nil {
-		log.Printf("Error learning fact: %v", err)
-		sendError(w, "Internal server error", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error learning fact", logging.Err(err))
+		sendResponseError(w, response, err)
 		return
 	}
 
@@ -85,6 +91,60 @@ func LearnHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// sendResponseError reports a failure with the service's own (localized)
+// response when there is one.
+func sendResponseError(w http.ResponseWriter, response *services.Response, err error) {
+	if response == nil {
+		sendServiceError(w, err)
+		return
+	}
+	status, _ := errorStatus(err)
+	setRetryAfter(w, err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// sendServiceError reports a failed service call, telling rate limits and
+// unavailable dependencies apart from internal errors.
+func sendServiceError(w http.ResponseWriter, err error) {
+	status, message := errorStatus(err)
+	setRetryAfter(w, err)
+	sendError(w, message, status)
+}
+
+func errorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, services.ErrQuotaExceeded):
+		return http.StatusTooManyRequests, "Daily quota exceeded, please retry tomorrow"
+	case errors.Is(err, clients.ErrRateLimited):
+		return http.StatusTooManyRequests, "Upstream rate limit reached, please retry later"
+	case errors.Is(err, clients.ErrUnavailable):
+		return http.StatusServiceUnavailable, "A dependency is unavailable, please retry later"
+	case errors.Is(err, clients.ErrInvalidRequest):
+		return http.StatusBadRequest, "The request was rejected by an upstream service"
+	case errors.Is(err, clients.ErrBlocked):
+		return http.StatusUnprocessableEntity, "The answer was blocked by the model's safety filters"
+	case errors.Is(err, services.ErrEmbeddingMismatch):
+		return http.StatusConflict, "The knowledge base was embedded with another model and must be re-indexed"
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "The request took too long, please retry later"
+	default:
+		return http.StatusInternalServerError, "Internal server error"
+	}
+}
+
+func setRetryAfter(w http.ResponseWriter, err error) {
+	var apiErr *clients.APIError
+	var quotaErr *services.QuotaError
+	switch {
+	case errors.As(err, &quotaErr):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(quotaErr.RetryAfter.Seconds()))))
+	case errors.As(err, &apiErr) && apiErr.RetryAfter > 0:
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(apiErr.RetryAfter.Seconds()))))
+	}
+}
+
 func sendError(w http.ResponseWriter, message string, status int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -96,4 +156,3 @@ func sendError(w http.ResponseWriter, message string, status int) {
 
 	json.NewEncoder(w).Encode(response)
 }
-
@@ -0,0 +1,725 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iara-assistant/clients"
+	"iara-assistant/services"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeAssistant records the requests it gets and answers with response and
+// err. Methods the tests do not use panic through the nil embedded interface.
+type fakeAssistant struct {
+	Assistant
+	response *services.Response
+	err      error
+	messages []services.MessageRequest
+	learned  []services.LearnRequest
+	deadline time.Time
+	language string
+}
+
+func (f *fakeAssistant) ProcessMessage(ctx context.Context, req services.MessageRequest) (*services.Response, error) {
+	f.messages = append(f.messages, req)
+	f.deadline, _ = ctx.Deadline()
+	return f.response, f.err
+}
+
+func (f *fakeAssistant) ResponseLanguage(userID, text string) string {
+	if f.language == "" {
+		return services.DefaultPersonaLanguage
+	}
+	return f.language
+}
+
+func (f *fakeAssistant) LearnFact(ctx context.Context, req services.LearnRequest) (*services.Response, error) {
+	f.learned = append(f.learned, req)
+	return f.response, f.err
+}
+
+// fakeKeyStore authenticates the secrets it holds.
+type fakeKeyStore struct {
+	KeyStore
+	keys map[string]services.APIKey
+}
+
+func (f *fakeKeyStore) Authenticate(secret string) (services.APIKey, bool) {
+	key, ok := f.keys[secret]
+	return key, ok
+}
+
+// fakeDocuments serves a fixed list of watched pages.
+type fakeDocuments struct {
+	DocumentStore
+	watched []services.WatchedURL
+}
+
+func (f *fakeDocuments) WatchedURLs() []services.WatchedURL {
+	return f.watched
+}
+
+func newTestServer(t *testing.T, deps Dependencies) *Server {
+	t.Helper()
+	if deps.Usage == nil {
+		deps.Usage = services.NewUsageTracker(t.TempDir(), services.DefaultQuotaConfig())
+	}
+	return NewServer(deps)
+}
+
+// withAPIKey passes r through as RequireScope would after accepting key.
+func withAPIKey(r *http.Request, key services.APIKey) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key))
+}
+
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("failed to decode response %q: %v", rec.Body.String(), err)
+	}
+}
+
+func TestMessageHandler(t *testing.T) {
+	quotaErr := &services.QuotaError{Subject: "user:alice", Quota: "llm_calls", Limit: 10, RetryAfter: 90 * time.Second}
+
+	tests := []struct {
+		name        string
+		method      string
+		body        string
+		response    *services.Response
+		err         error
+		wantStatus  int
+		wantMessage string
+		wantCalled  bool
+		retryAfter  string
+	}{
+		{
+			name:       "wrong method",
+			method:     http.MethodGet,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:        "invalid JSON",
+			method:      http.MethodPost,
+			body:        `{"text":`,
+			wantStatus:  http.StatusBadRequest,
+			wantMessage: "Invalid JSON request",
+		},
+		{
+			name:        "answered",
+			method:      http.MethodPost,
+			body:        `{"text":"Quando é a reunião?","user_id":"alice"}`,
+			response:    &services.Response{Success: true, Message: "Amanhã às 10h."},
+			wantStatus:  http.StatusOK,
+			wantMessage: "Amanhã às 10h.",
+			wantCalled:  true,
+		},
+		{
+			name:        "unsuccessful response",
+			method:      http.MethodPost,
+			body:        `{"text":""}`,
+			response:    &services.Response{Success: false, Message: "Message cannot be empty"},
+			wantStatus:  http.StatusBadRequest,
+			wantMessage: "Message cannot be empty",
+			wantCalled:  true,
+		},
+		{
+			name:        "quota exceeded",
+			method:      http.MethodPost,
+			body:        `{"text":"oi","user_id":"alice"}`,
+			err:         quotaErr,
+			wantStatus:  http.StatusTooManyRequests,
+			wantMessage: "Daily quota exceeded, please retry tomorrow",
+			wantCalled:  true,
+			retryAfter:  "90",
+		},
+		{
+			name:        "dependency down with a localized response",
+			method:      http.MethodPost,
+			body:        `{"text":"oi"}`,
+			response:    &services.Response{Success: false, Message: "Estou fora do ar, tente mais tarde."},
+			err:         fmt.Errorf("generate: %w", clients.ErrUnavailable),
+			wantStatus:  http.StatusServiceUnavailable,
+			wantMessage: "Estou fora do ar, tente mais tarde.",
+			wantCalled:  true,
+		},
+		{
+			name:        "timed out",
+			method:      http.MethodPost,
+			body:        `{"text":"oi"}`,
+			err:         fmt.Errorf("rerank: %w", context.DeadlineExceeded),
+			wantStatus:  http.StatusGatewayTimeout,
+			wantMessage: "The request took too long, please retry later",
+			wantCalled:  true,
+		},
+		{
+			name:        "internal error",
+			method:      http.MethodPost,
+			body:        `{"text":"oi"}`,
+			err:         errors.New("boom"),
+			wantStatus:  http.StatusInternalServerError,
+			wantMessage: "Internal server error",
+			wantCalled:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assistant := &fakeAssistant{response: tt.response, err: tt.err}
+			s := newTestServer(t, Dependencies{Assistant: assistant})
+
+			req := httptest.NewRequest(tt.method, "/v1/message", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			s.MessageHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if called := len(assistant.messages) > 0; called != tt.wantCalled {
+				t.Fatalf("assistant called = %v, want %v", called, tt.wantCalled)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.retryAfter)
+			}
+			if tt.wantMessage == "" {
+				return
+			}
+			var body struct {
+				Message string `json:"message"`
+			}
+			decodeBody(t, rec, &body)
+			if body.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", body.Message, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestMessageHandlerPassesRequest(t *testing.T) {
+	assistant := &fakeAssistant{response: &services.Response{Success: true, Message: "ok"}}
+	s := newTestServer(t, Dependencies{Assistant: assistant})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/message", strings.NewReader(`{"text":"Oi","user_id":"alice","debug":true}`))
+	req = withAPIKey(req, services.APIKey{ID: "admin", Scopes: []string{services.ScopeAdmin}})
+	s.MessageHandler(httptest.NewRecorder(), req)
+	latest := time.Now().Add(MessageTimeout)
+
+	if len(assistant.messages) != 1 {
+		t.Fatalf("assistant got %d messages, want 1", len(assistant.messages))
+	}
+	want := services.MessageRequest{Text: "Oi", UserID: "alice", Debug: true}
+	if got := assistant.messages[0]; got != want {
+		t.Errorf("request = %+v, want %+v", got, want)
+	}
+	if assistant.deadline.IsZero() || assistant.deadline.After(latest) {
+		t.Errorf("deadline = %v, want one within %v of the request", assistant.deadline, MessageTimeout)
+	}
+}
+
+func TestMessageHandlerBoundKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantUser   string
+	}{
+		{name: "takes the bound user", body: `{"text":"oi"}`, wantStatus: http.StatusOK, wantUser: "alice"},
+		{name: "accepts the bound user", body: `{"text":"oi","user_id":"alice"}`, wantStatus: http.StatusOK, wantUser: "alice"},
+		{name: "refuses another user", body: `{"text":"oi","user_id":"bob"}`, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assistant := &fakeAssistant{response: &services.Response{Success: true, Message: "ok"}}
+			s := newTestServer(t, Dependencies{Assistant: assistant})
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/message", strings.NewReader(tt.body))
+			req = withAPIKey(req, services.APIKey{ID: "key1", Scopes: []string{services.ScopeChat}, UserID: "alice"})
+			rec := httptest.NewRecorder()
+			s.MessageHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantUser == "" {
+				if len(assistant.messages) != 0 {
+					t.Fatalf("assistant was called for a refused request")
+				}
+				return
+			}
+			if got := assistant.messages[0].UserID; got != tt.wantUser {
+				t.Errorf("user = %q, want %q", got, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestMessageHandlerDebugNeedsAdmin(t *testing.T) {
+	tests := []struct {
+		name       string
+		scopes     []string
+		wantStatus int
+	}{
+		{name: "chat key", scopes: []string{services.ScopeChat}, wantStatus: http.StatusForbidden},
+		{name: "admin key", scopes: []string{services.ScopeAdmin}, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assistant := &fakeAssistant{response: &services.Response{Success: true, Message: "ok"}}
+			s := newTestServer(t, Dependencies{Assistant: assistant})
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/message", strings.NewReader(`{"text":"oi","debug":true}`))
+			req = withAPIKey(req, services.APIKey{ID: "key1", Scopes: tt.scopes, UserID: "alice"})
+			rec := httptest.NewRecorder()
+			s.MessageHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %q)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestMessageHandlerUserRateLimit(t *testing.T) {
+	assistant := &fakeAssistant{response: &services.Response{Success: true, Message: "ok"}}
+	usage := services.NewUsageTracker(t.TempDir(), services.DefaultQuotaConfig())
+	s := newTestServer(t, Dependencies{
+		Assistant:   assistant,
+		Usage:       usage,
+		UserLimiter: services.NewRateLimiter(1, 1),
+	})
+
+	send := func(userID string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"text":"oi","user_id":%q}`, userID)
+		rec := httptest.NewRecorder()
+		s.MessageHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/message", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := send("alice"); rec.Code != http.StatusOK {
+		t.Fatalf("first request: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	rec := send("alice")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	if retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After")); err != nil || retryAfter < 1 {
+		t.Errorf("Retry-After = %q, want a positive number of seconds", rec.Header().Get("Retry-After"))
+	}
+	if rec := send("bob"); rec.Code != http.StatusOK {
+		t.Errorf("other user: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	if len(assistant.messages) != 2 {
+		t.Errorf("assistant got %d messages, want 2", len(assistant.messages))
+	}
+	report := usage.Report(services.UsageUserSubject("alice"))
+	if got := report.Usage[services.UsageUserSubject("alice")].Requests; got != 1 {
+		t.Errorf("alice's counted requests = %d, want 1", got)
+	}
+}
+
+func TestUsageHandlerUserID(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        services.APIKey
+		query      string
+		wantStatus int
+	}{
+		{name: "own key", key: services.APIKey{ID: "key1", Scopes: []string{services.ScopeChat}}, wantStatus: http.StatusOK},
+		{name: "bound user", key: services.APIKey{ID: "key1", Scopes: []string{services.ScopeChat}, UserID: "alice"}, query: "?user_id=alice", wantStatus: http.StatusOK},
+		{name: "bound to another user", key: services.APIKey{ID: "key1", Scopes: []string{services.ScopeChat}, UserID: "alice"}, query: "?user_id=bob", wantStatus: http.StatusForbidden},
+		{name: "unbound key", key: services.APIKey{ID: "key1", Scopes: []string{services.ScopeChat}}, query: "?user_id=bob", wantStatus: http.StatusForbidden},
+		{name: "admin key", key: services.APIKey{ID: "key1", Scopes: []string{services.ScopeAdmin}}, query: "?user_id=bob", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, Dependencies{})
+			req := withAPIKey(httptest.NewRequest(http.MethodGet, "/v1/usage"+tt.query, nil), tt.key)
+			rec := httptest.NewRecorder()
+			s.UsageHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %q)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestLearnHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		body        string
+		response    *services.Response
+		err         error
+		wantStatus  int
+		wantMessage string
+		wantCalled  bool
+	}{
+		{
+			name:       "wrong method",
+			method:     http.MethodPut,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:        "invalid JSON",
+			method:      http.MethodPost,
+			body:        `not json`,
+			wantStatus:  http.StatusBadRequest,
+			wantMessage: "Invalid JSON request",
+		},
+		{
+			name:        "learned",
+			method:      http.MethodPost,
+			body:        `{"text":"A reunião é às 10h","user_id":"alice"}`,
+			response:    &services.Response{Success: true, Message: "Fact learned successfully", Status: "learned"},
+			wantStatus:  http.StatusOK,
+			wantMessage: "Fact learned successfully",
+			wantCalled:  true,
+		},
+		{
+			name:        "rejected fact",
+			method:      http.MethodPost,
+			body:        `{"text":""}`,
+			response:    &services.Response{Success: false, Message: "Fact text cannot be empty"},
+			wantStatus:  http.StatusBadRequest,
+			wantMessage: "Fact text cannot be empty",
+			wantCalled:  true,
+		},
+		{
+			name:        "embedding model mismatch",
+			method:      http.MethodPost,
+			body:        `{"text":"fato"}`,
+			err:         fmt.Errorf("learn: %w", services.ErrEmbeddingMismatch),
+			wantStatus:  http.StatusConflict,
+			wantMessage: "The knowledge base was embedded with another model and must be re-indexed",
+			wantCalled:  true,
+		},
+		{
+			name:        "upstream rate limited",
+			method:      http.MethodPost,
+			body:        `{"text":"fato"}`,
+			err:         fmt.Errorf("embed: %w", clients.ErrRateLimited),
+			wantStatus:  http.StatusTooManyRequests,
+			wantMessage: "Upstream rate limit reached, please retry later",
+			wantCalled:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assistant := &fakeAssistant{response: tt.response, err: tt.err}
+			s := newTestServer(t, Dependencies{Assistant: assistant})
+
+			req := httptest.NewRequest(tt.method, "/v1/learn", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			s.LearnHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if called := len(assistant.learned) > 0; called != tt.wantCalled {
+				t.Fatalf("assistant called = %v, want %v", called, tt.wantCalled)
+			}
+			if tt.wantMessage == "" {
+				return
+			}
+			var body struct {
+				Message string `json:"message"`
+			}
+			decodeBody(t, rec, &body)
+			if body.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", body.Message, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestLearnHandlerBoundKey(t *testing.T) {
+	assistant := &fakeAssistant{response: &services.Response{Success: true, Message: "ok"}}
+	s := newTestServer(t, Dependencies{Assistant: assistant})
+	key := services.APIKey{ID: "key1", Scopes: []string{services.ScopeLearn}, UserID: "alice"}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/learn", strings.NewReader(`{"text":"fato","user_id":"bob"}`))
+	s.LearnHandler(rec, withAPIKey(req, key))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("other user: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/v1/learn", strings.NewReader(`{"text":"fato"}`))
+	s.LearnHandler(rec, withAPIKey(req, key))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("bound user: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	want := []services.LearnRequest{{Text: "fato", UserID: "alice"}}
+	if len(assistant.learned) != 1 || assistant.learned[0] != want[0] {
+		t.Errorf("learned = %+v, want %+v", assistant.learned, want)
+	}
+}
+
+// TestRoutes sends requests through the full handler, so authentication and
+// signature checks are applied as in production.
+func TestRoutes(t *testing.T) {
+	keys := &fakeKeyStore{keys: map[string]services.APIKey{
+		"chat-key":  {ID: "chat", Scopes: []string{services.ScopeChat}},
+		"learn-key": {ID: "learn", Scopes: []string{services.ScopeLearn}},
+	}}
+
+	tests := []struct {
+		name       string
+		path       string
+		key        string
+		signed     bool
+		wantStatus int
+	}{
+		{name: "message without key", path: "/v1/message", signed: true, wantStatus: http.StatusUnauthorized},
+		{name: "message with unknown key", path: "/v1/message", key: "nope", signed: true, wantStatus: http.StatusUnauthorized},
+		{name: "message without chat scope", path: "/v1/message", key: "learn-key", signed: true, wantStatus: http.StatusForbidden},
+		{name: "unsigned message", path: "/v1/message", key: "chat-key", wantStatus: http.StatusUnauthorized},
+		{name: "signed message", path: "/v1/message", key: "chat-key", signed: true, wantStatus: http.StatusOK},
+		{name: "learn without learn scope", path: "/v1/learn", key: "chat-key", signed: true, wantStatus: http.StatusForbidden},
+		{name: "unsigned learn", path: "/v1/learn", key: "learn-key", wantStatus: http.StatusUnauthorized},
+		{name: "signed learn", path: "/v1/learn", key: "learn-key", signed: true, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			// Each case gets its own verifier, since identical signed
+			// requests would be refused as replays.
+			verifier := services.NewSignatureVerifier("n8n-secret", time.Minute)
+			assistant := &fakeAssistant{response: &services.Response{Success: true, Message: "ok"}}
+			handler := newTestServer(t, Dependencies{
+				Assistant:   assistant,
+				APIKeys:     keys,
+				N8NVerifier: verifier,
+			}).Handler()
+
+			body := `{"text":"oi","user_id":"alice"}`
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(body))
+			if tt.key != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.key)
+			}
+			if tt.signed {
+				ts := time.Now().Unix()
+				req.Header.Set(services.TimestampHeader, strconv.FormatInt(ts, 10))
+				req.Header.Set(services.SignatureHeader, verifier.Sign(ts, []byte(body)))
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if called := len(assistant.messages)+len(assistant.learned) > 0; called != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("assistant called = %v for status %d", called, rec.Code)
+			}
+			if rec.Header().Get("X-Request-ID") == "" {
+				t.Errorf("response has no request ID")
+			}
+		})
+	}
+}
+
+// The cache counters are served by /metrics, behind the metrics scope.
+// Nothing may publish them, or the runtime stats, without a key.
+func TestDebugEndpointsNotServed(t *testing.T) {
+	handler := newTestServer(t, Dependencies{APIKeys: &fakeKeyStore{}}).Handler()
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{path: "/debug/vars", wantStatus: http.StatusNotFound},
+		{path: "/debug/pprof/", wantStatus: http.StatusNotFound},
+		{path: "/metrics", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.wantStatus {
+			t.Errorf("GET %s: status = %d, want %d", tt.path, rec.Code, tt.wantStatus)
+		}
+	}
+}
+
+func TestIngestURLHandlerListsOwnPages(t *testing.T) {
+	documents := &fakeDocuments{watched: []services.WatchedURL{
+		{URL: "https://example.com/a", UserID: "alice"},
+		{URL: "https://example.com/b", UserID: "bob"},
+	}}
+	tests := []struct {
+		name       string
+		key        services.APIKey
+		query      string
+		wantStatus int
+		wantURLs   int
+	}{
+		{name: "bound key", key: services.APIKey{ID: "key1", Scopes: []string{services.ScopeLearn}, UserID: "alice"}, wantStatus: http.StatusOK, wantURLs: 1},
+		{name: "bound key asking for another user", key: services.APIKey{ID: "key1", Scopes: []string{services.ScopeLearn}, UserID: "alice"}, query: "?user_id=bob", wantStatus: http.StatusForbidden},
+		{name: "unbound key filtering", key: services.APIKey{ID: "key1", Scopes: []string{services.ScopeLearn}}, query: "?user_id=bob", wantStatus: http.StatusOK, wantURLs: 1},
+		{name: "unbound key", key: services.APIKey{ID: "key1", Scopes: []string{services.ScopeLearn}}, wantStatus: http.StatusOK, wantURLs: 2},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, Dependencies{Documents: documents})
+			req := withAPIKey(httptest.NewRequest(http.MethodGet, "/v1/ingest/url"+tt.query, nil), tt.key)
+			rec := httptest.NewRecorder()
+			s.IngestURLHandler(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var body struct {
+				Watched []services.WatchedURL `json:"watched"`
+			}
+			decodeBody(t, rec, &body)
+			if len(body.Watched) != tt.wantURLs {
+				t.Errorf("got %d pages, want %d", len(body.Watched), tt.wantURLs)
+			}
+		})
+	}
+}
+
+// telegramRequest builds a webhook call for an update with the given secret
+// token.
+func telegramRequest(secret string, updateID int64, text string) *http.Request {
+	body := fmt.Sprintf(`{"update_id":%d,"message":{"message_id":7,"from":{"id":42},"chat":{"id":99},"text":%q}}`, updateID, text)
+	req := httptest.NewRequest(http.MethodPost, "/v1/telegram/webhook", strings.NewReader(body))
+	if secret != "" {
+		req.Header.Set(services.TelegramSecretHeader, secret)
+	}
+	return req
+}
+
+func TestTelegramWebhookHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		verifier   *services.TelegramVerifier
+		secret     string
+		response   *services.Response
+		err        error
+		wantStatus int
+		wantReply  string
+	}{
+		{
+			name:       "not configured",
+			secret:     "secret",
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:       "missing secret",
+			verifier:   services.NewTelegramVerifier("secret"),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "wrong secret",
+			verifier:   services.NewTelegramVerifier("secret"),
+			secret:     "guess",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "answered",
+			verifier:   services.NewTelegramVerifier("secret"),
+			secret:     "secret",
+			response:   &services.Response{Success: true, Message: "Amanhã às 10h."},
+			wantStatus: http.StatusOK,
+			wantReply:  "Amanhã às 10h.",
+		},
+		{
+			name:       "quota exceeded",
+			verifier:   services.NewTelegramVerifier("secret"),
+			secret:     "secret",
+			err:        fmt.Errorf("generate: %w", services.ErrQuotaExceeded),
+			wantStatus: http.StatusOK,
+			wantReply:  "Você atingiu o limite diário de mensagens. Tente de novo amanhã.",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assistant := &fakeAssistant{response: tt.response, err: tt.err}
+			s := newTestServer(t, Dependencies{Assistant: assistant, TelegramVerifier: tt.verifier})
+
+			rec := httptest.NewRecorder()
+			s.TelegramWebhookHandler(rec, telegramRequest(tt.secret, 1, "Quando é a reunião?"))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantReply == "" {
+				if len(assistant.messages) != 0 {
+					t.Errorf("assistant got %d messages, want 0", len(assistant.messages))
+				}
+				return
+			}
+			var reply telegramReply
+			decodeBody(t, rec, &reply)
+			want := telegramReply{Method: "sendMessage", ChatID: 99, Text: tt.wantReply, ReplyToMessageID: 7}
+			if reply != want {
+				t.Errorf("reply = %+v, want %+v", reply, want)
+			}
+			if len(assistant.messages) != 1 || assistant.messages[0].UserID != "telegram:42" {
+				t.Errorf("requests = %+v, want one from telegram:42", assistant.messages)
+			}
+		})
+	}
+}
+
+func TestTelegramWebhookHandlerRepeats(t *testing.T) {
+	assistant := &fakeAssistant{response: &services.Response{Success: true, Message: "ok"}, language: "en"}
+	s := newTestServer(t, Dependencies{
+		Assistant:        assistant,
+		TelegramVerifier: services.NewTelegramVerifier("secret"),
+		UserLimiter:      services.NewRateLimiter(1, 1),
+	})
+
+	send := func(updateID int64) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.TelegramWebhookHandler(rec, telegramRequest("secret", updateID, "When is the meeting?"))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("update %d: status = %d, want %d", updateID, rec.Code, http.StatusOK)
+		}
+		return rec
+	}
+
+	send(1)
+	if rec := send(1); rec.Body.Len() != 0 {
+		t.Errorf("redelivered update answered with %q, want no reply", rec.Body.String())
+	}
+	if len(assistant.messages) != 1 {
+		t.Errorf("assistant got %d messages, want 1", len(assistant.messages))
+	}
+
+	var reply telegramReply
+	decodeBody(t, send(2), &reply)
+	if !strings.HasPrefix(reply.Text, "Too many messages. Please try again in ") {
+		t.Errorf("rate limited reply = %q, want it in English", reply.Text)
+	}
+	if len(assistant.messages) != 1 {
+		t.Errorf("assistant got %d messages after the rate limit, want 1", len(assistant.messages))
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"iara-assistant/logging"
+	"iara-assistant/services"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// readinessTimeout bounds the dependency checks of a readiness probe.
+const readinessTimeout = 5 * time.Second
+
+type HealthResponse struct {
+	Status     string                     `json:"status"`
+	Timestamp  time.Time                  `json:"timestamp"`
+	Version    string                     `json:"version"`
+	Components []services.ComponentHealth `json:"components,omitempty"`
+}
+
+// LiveHandler reports that the process is up, without checking any
+// dependency, so a failing dependency does not get the container restarted.
+func LiveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeHealth(w, r, http.StatusOK, HealthResponse{
+		Status:    "alive",
+		Timestamp: time.Now().UTC(),
+		Version:   Version(),
+	})
+}
+
+// ReadyHandler checks every dependency. It answers 503 when a critical one is
+// down and reports "degraded" when something needs attention but requests can
+// still be served.
+func (s *Server) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	components := append(s.health.CheckHealth(ctx), s.crawler.CrawlerHealth())
+
+	response := HealthResponse{
+		Status:     "ready",
+		Timestamp:  time.Now().UTC(),
+		Version:    Version(),
+		Components: components,
+	}
+	status := http.StatusOK
+	for _, component := range components {
+		switch {
+		case component.Status == services.ComponentOK:
+		case component.Status == services.ComponentDown && component.Critical:
+			response.Status = "not_ready"
+			status = http.StatusServiceUnavailable
+		case response.Status == "ready":
+			response.Status = "degraded"
+		}
+	}
+	if status != http.StatusOK {
+		slog.WarnContext(r.Context(), "Readiness check failed", "components", components)
+	}
+
+	writeHealth(w, r, status, response)
+}
+
+func writeHealth(w http.ResponseWriter, r *http.Request, status int, response HealthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding health response", logging.Err(err))
+	}
+}
+
+// Version identifies the build: the module version when built from a tagged
+// module, otherwise the VCS revision stamped by go build.
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+
+	var revision string
+	var modified bool
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return "dev"
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if modified {
+		revision += "-dirty"
+	}
+	return revision
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iara-assistant/logging"
+	"iara-assistant/services"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// LearnBatchHandler learns many facts at once. The body is either a JSON
+// array of learn requests or NDJSON with one request per line; facts without
+// a user_id get the one from the query string.
+func (s *Server) LearnBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxUploadSize)
+	requests, err := parseLearnBatch(r.Body)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Error decoding learn batch request", logging.Err(err))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			sendError(w, "Request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		sendError(w, "Invalid JSON or NDJSON request", http.StatusBadRequest)
+		return
+	}
+	if len(requests) == 0 {
+		sendError(w, "No facts to learn", http.StatusBadRequest)
+		return
+	}
+	if len(requests) > services.MaxLearnBatchItems {
+		sendError(w, fmt.Sprintf("Too many facts, the limit is %d", services.MaxLearnBatchItems), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if !bindUserID(w, r, &userID) {
+		return
+	}
+	users := make(map[string]bool)
+	for i := range requests {
+		if requests[i].UserID == "" {
+			requests[i].UserID = userID
+		}
+		if !bindUserID(w, r, &requests[i].UserID) {
+			return
+		}
+		users[requests[i].UserID] = true
+	}
+	for user := range users {
+		if !s.limitUser(w, user) {
+			return
+		}
+	}
+
+	response, err := s.assistant.LearnFacts(r.Context(), requests)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error learning facts", logging.Err(err))
+		sendServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !response.Success {
+		w.WriteHeader(http.StatusBadRequest)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func parseLearnBatch(body io.Reader) ([]services.LearnRequest, error) {
+	reader := bufio.NewReader(body)
+	first, err := peekNonSpace(reader)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(reader)
+	var requests []services.LearnRequest
+	if first == '[' {
+		if err := decoder.Decode(&requests); err != nil {
+			return nil, err
+		}
+		return requests, nil
+	}
+
+	// NDJSON is a stream of objects, which the decoder reads one at a time.
+	for {
+		var req services.LearnRequest
+		err := decoder.Decode(&req)
+		if err == io.EOF {
+			return requests, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", len(requests)+1, err)
+		}
+		requests = append(requests, req)
+	}
+}
+
+func peekNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, reader.UnreadByte()
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"iara-assistant/logging"
+	"iara-assistant/metrics"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// statusRecorder remembers the status written through it. Unwrap lets
+// http.ResponseController reach the underlying writer.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// RequestLogger gives every request an ID, taken from X-Request-ID when the
+// caller sent a usable one, and logs the request once it is handled. The ID
+// is echoed in the response and carried by the request context into services
+// and clients. Requests are also counted and timed per mux route.
+func RequestLogger(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(logging.RequestIDHeader)
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(logging.RequestIDHeader, id)
+
+		ctx := logging.WithRequestID(r.Context(), id)
+		recorder := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		mux.ServeHTTP(recorder, r.WithContext(ctx))
+		elapsed := time.Since(start)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		// Label by the matched pattern rather than the path, so the number of
+		// series stays bounded.
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		method := metrics.Method(r.Method)
+		metrics.HTTPRequests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(route, method).Observe(elapsed.Seconds())
+
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.Log(ctx, level, "Request handled",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", recorder.bytes,
+			"duration_ms", elapsed.Milliseconds())
+	})
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"iara-assistant/logging"
+	"iara-assistant/metrics"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRequestLogger(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/test/items/", func(w http.ResponseWriter, r *http.Request) {
+		if logging.RequestID(r.Context()) == "" {
+			t.Error("request context carries no request ID")
+		}
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := RequestLogger(mux)
+
+	tests := []struct {
+		method     string
+		path       string
+		wantRoute  string
+		wantMethod string
+		wantStatus string
+	}{
+		{method: http.MethodGet, path: "/test/items/1", wantRoute: "/test/items/", wantMethod: "GET", wantStatus: "418"},
+		{method: "BREW", path: "/test/items/2", wantRoute: "/test/items/", wantMethod: "other", wantStatus: "418"},
+		{method: http.MethodGet, path: "/test/missing", wantRoute: "unmatched", wantMethod: "GET", wantStatus: "404"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			counter := metrics.HTTPRequests.WithLabelValues(tt.wantRoute, tt.wantMethod, tt.wantStatus)
+			before := testutil.ToFloat64(counter)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if got := testutil.ToFloat64(counter) - before; got != 1 {
+				t.Errorf("requests counted = %v, want 1", got)
+			}
+			if rec.Header().Get(logging.RequestIDHeader) == "" {
+				t.Error("response has no request ID header")
+			}
+		})
+	}
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "iara_http_requests_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "method" && label.GetValue() == "BREW" {
+					t.Error("unknown method used as a label value")
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"iara-assistant/logging"
+	"iara-assistant/services"
+	"log/slog"
+	"net/http"
+)
+
+// PromptPreviewHandler renders the prompt that would be sent for a message,
+// including retrieved context and history, without generating an answer. The
+// model is still called when an LLM reranker or history summarizing is
+// configured, and those calls count against the user's quota.
+func (s *Server) PromptPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req services.MessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.WarnContext(r.Context(), "Error decoding preview request", logging.Err(err))
+		sendError(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+	if req.Text == "" {
+		sendError(w, "Message cannot be empty", http.StatusBadRequest)
+		return
+	}
+
+	preview, err := s.assistant.PreviewPrompt(r.Context(), req)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error previewing prompt", logging.Err(err))
+		sendServiceError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
+}
+
+type personaRequest struct {
+	UserID string `json:"user_id"`
+	services.Persona
+}
+
+// PersonaHandler returns (GET ?user_id=) or replaces (PUT) the persona
+// overrides of a user. Fields left empty use the defaults.
+func (s *Server) PersonaHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		userID := r.URL.Query().Get("user_id")
+		if !bindUserID(w, r, &userID) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.assistant.Persona(userID))
+
+	case http.MethodPut, http.MethodPost:
+		var req personaRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			slog.WarnContext(r.Context(), "Error decoding persona request", logging.Err(err))
+			sendError(w, "Invalid JSON request", http.StatusBadRequest)
+			return
+		}
+		if !bindUserID(w, r, &req.UserID) {
+			return
+		}
+		if req.UserID == "" {
+			sendError(w, "Missing user_id", http.StatusBadRequest)
+			return
+		}
+
+		persona, err := s.assistant.SetPersona(req.UserID, req.Persona)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error saving persona", logging.Err(err))
+			sendError(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(persona)
+
+	default:
+		sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"iara-assistant/logging"
+	"iara-assistant/services"
+	"log/slog"
+	"net/http"
+)
+
+// ReindexHandler reports the progress of the last re-index (GET) and starts
+// or resumes one in the background (POST).
+func (s *Server) ReindexHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := s.reindexer.StartReindex(s.reindexCtx); err != nil {
+			if errors.Is(err, services.ErrReindexRunning) {
+				sendError(w, err.Error(), http.StatusConflict)
+				return
+			}
+			slog.ErrorContext(r.Context(), "Error starting re-index", logging.Err(err))
+			sendServiceError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]string{"status": "re-index started"})
+		return
+	default:
+		sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	progress, err := s.reindexer.ReindexStatus()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error reading re-index progress", logging.Err(err))
+		sendServiceError(w, err)
+		return
+	}
+	if progress == nil {
+		sendError(w, "No re-index has been run", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(progress)
+}
+
+// StopReindex cancels a re-index started through ReindexHandler and waits
+// for it to stop. The job resumes on the next start.
+func (s *Server) StopReindex() {
+	s.cancelReindex()
+	s.reindexer.WaitReindex()
+}
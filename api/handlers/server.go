@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"context"
+	"iara-assistant/config"
+	"iara-assistant/metrics"
+	"iara-assistant/services"
+	"io"
+	"net/http"
+	"time"
+)
+
+// WriteTimeout is the server's write timeout. Messages are answered within
+// MessageTimeout, which leaves time to write the error when a stage runs
+// out of it instead of the connection being dropped.
+const (
+	WriteTimeout   = 30 * time.Second
+	MessageTimeout = WriteTimeout - 5*time.Second
+)
+
+// Assistant answers messages and learns facts. It is implemented by
+// services.RAGService.
+type Assistant interface {
+	ProcessMessage(ctx context.Context, req services.MessageRequest) (*services.Response, error)
+	LearnFact(ctx context.Context, req services.LearnRequest) (*services.Response, error)
+	LearnFacts(ctx context.Context, requests []services.LearnRequest) (*services.LearnBatchResponse, error)
+	PreviewPrompt(ctx context.Context, req services.MessageRequest) (*services.PromptPreview, error)
+	Persona(userID string) services.Persona
+	SetPersona(userID string, persona services.Persona) (services.Persona, error)
+	ResponseLanguage(userID, text string) string
+}
+
+// DocumentStore ingests, lists and deletes documents. It is implemented by
+// services.RAGService.
+type DocumentStore interface {
+	IngestDocument(ctx context.Context, req services.IngestRequest) (*services.IngestResponse, error)
+	IngestURL(ctx context.Context, req services.IngestURLRequest) (*services.IngestResponse, error)
+	ListDocuments(ctx context.Context, userID string) ([]services.DocumentInfo, error)
+	DeleteDocument(ctx context.Context, documentID string) (bool, error)
+	WatchedURLs() []services.WatchedURL
+}
+
+// Reindexer runs background re-indexes. It is implemented by
+// services.RAGService.
+type Reindexer interface {
+	StartReindex(ctx context.Context) error
+	ReindexStatus() (*services.ReindexProgress, error)
+	WaitReindex()
+}
+
+// HealthChecker checks the dependencies of the service.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) []services.ComponentHealth
+}
+
+// VaultSync imports and watches Markdown vaults.
+type VaultSync interface {
+	Sync(ctx context.Context, root, userID string) (*services.VaultSyncResult, error)
+	Watch(root, userID string) error
+}
+
+// Backups exports and imports archives of the knowledge base.
+type Backups interface {
+	Export(ctx context.Context, w io.Writer, includeEmbeddings bool) (*services.ArchiveManifest, error)
+	Import(ctx context.Context, r io.ReaderAt, size int64, mode string) (*services.ImportResult, error)
+}
+
+// KeyStore authenticates and manages API keys.
+type KeyStore interface {
+	Authenticate(secret string) (services.APIKey, bool)
+	Create(name string, scopes []string, userID string) (services.APIKey, string, error)
+	List() []services.APIKey
+	Revoke(id string) (bool, error)
+}
+
+// Crawler runs the DOM crawler on demand and reports its health. It is
+// implemented by services.CronService.
+type Crawler interface {
+	TriggerCrawler(ctx context.Context) error
+	CrawlerHealth() services.ComponentHealth
+}
+
+// Dependencies are what a Server is built from. A nil limiter allows every
+// request and a nil verifier disables its check, as with the services'
+// own constructors.
+type Dependencies struct {
+	Assistant Assistant
+	Documents DocumentStore
+	Reindexer Reindexer
+	Health    HealthChecker
+	Vault     VaultSync
+	Backups   Backups
+	APIKeys   KeyStore
+	Crawler   Crawler
+	Usage     *services.UsageTracker
+	Config    *config.Config
+
+	KeyLimiter       *services.RateLimiter
+	UserLimiter      *services.RateLimiter
+	N8NVerifier      *services.SignatureVerifier
+	TelegramVerifier *services.TelegramVerifier
+}
+
+// Server serves the HTTP API on top of its dependencies.
+type Server struct {
+	assistant Assistant
+	documents DocumentStore
+	reindexer Reindexer
+	health    HealthChecker
+	vault     VaultSync
+	backups   Backups
+	apiKeys   KeyStore
+	crawler   Crawler
+	usage     *services.UsageTracker
+	settings  *config.Config
+
+	keyLimiter       *services.RateLimiter
+	userLimiter      *services.RateLimiter
+	n8nVerifier      *services.SignatureVerifier
+	telegramVerifier *services.TelegramVerifier
+
+	// reindexCtx outlives the request that starts a re-index; StopReindex
+	// cancels it on shutdown, leaving the job to resume on the next start.
+	reindexCtx    context.Context
+	cancelReindex context.CancelFunc
+}
+
+func NewServer(deps Dependencies) *Server {
+	reindexCtx, cancelReindex := context.WithCancel(context.Background())
+	return &Server{
+		assistant:        deps.Assistant,
+		documents:        deps.Documents,
+		reindexer:        deps.Reindexer,
+		health:           deps.Health,
+		vault:            deps.Vault,
+		backups:          deps.Backups,
+		apiKeys:          deps.APIKeys,
+		crawler:          deps.Crawler,
+		usage:            deps.Usage,
+		settings:         deps.Config,
+		keyLimiter:       deps.KeyLimiter,
+		userLimiter:      deps.UserLimiter,
+		n8nVerifier:      deps.N8NVerifier,
+		telegramVerifier: deps.TelegramVerifier,
+		reindexCtx:       reindexCtx,
+		cancelReindex:    cancelReindex,
+	}
+}
+
+// Handler routes the API, with every request logged and measured.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	// /health is kept as an alias of the liveness probe for existing
+	// monitors.
+	mux.HandleFunc("/health", LiveHandler)
+	mux.HandleFunc("/health/live", LiveHandler)
+	mux.HandleFunc("/health/ready", s.ReadyHandler)
+	mux.HandleFunc("/metrics", s.RequireScope(services.ScopeMetrics, metrics.Handler().ServeHTTP))
+	mux.HandleFunc("/v1/message", s.RequireScope(services.ScopeChat, s.RequireSignature(s.MessageHandler)))
+	mux.HandleFunc("/v1/persona", s.RequireScope(services.ScopeChat, s.PersonaHandler))
+	mux.HandleFunc("/v1/learn", s.RequireScope(services.ScopeLearn, s.RequireSignature(s.LearnHandler)))
+	mux.HandleFunc("/v1/learn/batch", s.RequireScope(services.ScopeLearn, s.RequireSignature(s.LearnBatchHandler)))
+	mux.HandleFunc("/v1/ingest", s.RequireScope(services.ScopeLearn, s.IngestHandler))
+	mux.HandleFunc("/v1/ingest/url", s.RequireScope(services.ScopeLearn, s.IngestURLHandler))
+	mux.HandleFunc("/v1/documents", s.RequireScope(services.ScopeLearn, s.DocumentsHandler))
+	mux.HandleFunc("/v1/vault/sync", s.RequireScope(services.ScopeAdmin, s.VaultSyncHandler))
+	mux.HandleFunc("/v1/prompt/preview", s.RequireScope(services.ScopeAdmin, s.PromptPreviewHandler))
+	mux.HandleFunc("/v1/admin/reindex", s.RequireScope(services.ScopeAdmin, s.ReindexHandler))
+	mux.HandleFunc("/v1/telegram/webhook", s.TelegramWebhookHandler)
+	mux.HandleFunc("/v1/usage", s.RequireScope("", s.UsageHandler))
+	mux.HandleFunc("/v1/admin/config", s.RequireScope(services.ScopeAdmin, s.ConfigHandler))
+	mux.HandleFunc("/v1/admin/keys", s.RequireScope(services.ScopeAdmin, s.APIKeysHandler))
+	mux.HandleFunc("/v1/export", s.RequireScope(services.ScopeAdmin, s.ExportHandler))
+	mux.HandleFunc("/v1/import", s.RequireScope(services.ScopeAdmin, s.ImportHandler))
+	mux.HandleFunc("/v1/trigger-crawler", s.RequireScope(services.ScopeCrawler, s.TriggerCrawlerHandler))
+
+	return RequestLogger(mux)
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"iara-assistant/logging"
+	"iara-assistant/services"
+	"log/slog"
+	"net/http"
+)
+
+// VaultSyncHandler imports a directory of Markdown notes and optionally keeps
+// watching it for changes. Only directories inside the configured vault roots
+// are accepted.
+func (s *Server) VaultSyncHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req services.VaultSyncRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		slog.WarnContext(r.Context(), "Error decoding vault sync request", logging.Err(err))
+		sendError(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+	if req.Path == "" {
+		sendError(w, "Missing vault path", http.StatusBadRequest)
+		return
+	}
+
+	if req.Watch {
+		if err := s.vault.Watch(req.Path, req.UserID); err != nil {
+			slog.ErrorContext(r.Context(), "Error watching vault", logging.Err(err))
+			sendVaultError(w, err)
+			return
+		}
+	}
+
+	result, err := s.vault.Sync(r.Context(), req.Path, req.UserID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error syncing vault", logging.Err(err))
+		sendVaultError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func sendVaultError(w http.ResponseWriter, err error) {
+	if errors.Is(err, services.ErrVaultNotAllowed) {
+		sendError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	sendError(w, err.Error(), http.StatusBadRequest)
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iara-assistant/logging"
+	"iara-assistant/services"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// MaxWebhookBodySize bounds the Telegram updates read by the webhook.
+const MaxWebhookBodySize = 1 << 20
+
+// telegramUsageSubject is charged with the model calls made for Telegram
+// updates, which carry no API key.
+var telegramUsageSubject = services.UsageKeySubject("telegram")
+
+type telegramUpdate struct {
+	UpdateID int64            `json:"update_id"`
+	Message  *telegramMessage `json:"message"`
+}
+
+type telegramMessage struct {
+	MessageID int64 `json:"message_id"`
+	From      *struct {
+		ID int64 `json:"id"`
+	} `json:"from"`
+	Chat struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+	Text string `json:"text"`
+}
+
+// telegramReply answers an update in the webhook response itself, so no bot
+// token is needed to send it.
+type telegramReply struct {
+	Method           string `json:"method"`
+	ChatID           int64  `json:"chat_id"`
+	Text             string `json:"text"`
+	ReplyToMessageID int64  `json:"reply_to_message_id,omitempty"`
+}
+
+// RequireSignature only lets requests through that are signed by n8n with the
+// shared N8N_WEBHOOK_SECRET; see services.SignatureVerifier. Without a secret
+// it lets every request through.
+func (s *Server) RequireSignature(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.n8nVerifier == nil {
+			next(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxUploadSize))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				sendError(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			sendError(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+
+		err = s.n8nVerifier.Verify(r.Header.Get(services.TimestampHeader), r.Header.Get(services.SignatureHeader), body)
+		if err != nil {
+			slog.WarnContext(r.Context(), "Rejected unsigned or replayed request",
+				"path", r.URL.Path,
+				"remote_addr", r.RemoteAddr,
+				logging.Err(err))
+			sendError(w, fmt.Sprintf("Request signature rejected: %v", err), http.StatusUnauthorized)
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r)
+	}
+}
+
+// TelegramWebhookHandler answers text messages sent to the bot. Telegram must
+// be given TELEGRAM_WEBHOOK_SECRET as the webhook's secret_token; the endpoint
+// refuses every call while it is not configured. Each Telegram user is the
+// user "telegram:<id>".
+func (s *Server) TelegramWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.telegramVerifier == nil {
+		slog.WarnContext(r.Context(), "Rejected Telegram webhook call, TELEGRAM_WEBHOOK_SECRET is not set", "remote_addr", r.RemoteAddr)
+		sendError(w, "Telegram webhook is not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if err := s.telegramVerifier.Verify(r.Header.Get(services.TelegramSecretHeader)); err != nil {
+		slog.WarnContext(r.Context(), "Rejected Telegram webhook call", "remote_addr", r.RemoteAddr, logging.Err(err))
+		sendError(w, "Invalid secret token", http.StatusUnauthorized)
+		return
+	}
+
+	var update telegramUpdate
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxWebhookBodySize)).Decode(&update); err != nil {
+		slog.WarnContext(r.Context(), "Error decoding Telegram update", logging.Err(err))
+		sendError(w, "Invalid JSON request", http.StatusBadRequest)
+		return
+	}
+	if s.telegramVerifier.Duplicate(update.UpdateID) {
+		slog.InfoContext(r.Context(), "Ignoring redelivered Telegram update", "update_id", update.UpdateID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	message := update.Message
+	if message == nil || message.From == nil || message.Text == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Errors are answered in the chat with a 200, since Telegram would keep
+	// redelivering the update otherwise.
+	reply := telegramReply{Method: "sendMessage", ChatID: message.Chat.ID, ReplyToMessageID: message.MessageID}
+	userID := "telegram:" + strconv.FormatInt(message.From.ID, 10)
+	language := s.assistant.ResponseLanguage(userID, message.Text)
+	if allowed, wait := s.userLimiter.Allow(userID); !allowed {
+		reply.Text = services.RateLimitedMessage(language, wait)
+	} else {
+		s.usage.CountRequest(telegramUsageSubject)
+		s.usage.CountRequest(services.UsageUserSubject(userID))
+		ctx, cancel := context.WithTimeout(services.WithUsageSubject(r.Context(), telegramUsageSubject), MessageTimeout)
+		defer cancel()
+		response, err := s.assistant.ProcessMessage(ctx, services.MessageRequest{Text: message.Text, UserID: userID})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error processing Telegram message", logging.Err(err))
+			reply.Text = services.FailureMessage(language, err)
+		} else if !response.Success {
+			reply.Text = response.Error
+		} else {
+			reply.Text = response.Message
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
@@ -0,0 +1,113 @@
+// Package logging sets up structured logging with log/slog. Records are
+// tagged with the request ID of their context and pass through a redaction
+// layer, so fact contents, API keys and personal identifiers stay out of the
+// logs.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+
+	// RequestIDHeader carries the request ID in requests and responses.
+	RequestIDHeader = "X-Request-ID"
+
+	// MaxRequestIDLength bounds request IDs taken from incoming requests.
+	MaxRequestIDLength = 64
+)
+
+// Config selects the output format (FormatText or FormatJSON) and the
+// minimum level: debug, info, warn or error. IdentifierKey keys the
+// pseudonyms personal identifiers are logged as; when it is empty a random
+// key is used and pseudonyms change on every restart.
+type Config struct {
+	Format        string
+	Level         string
+	IdentifierKey string
+}
+
+func DefaultConfig() Config {
+	return Config{Format: FormatText, Level: "info"}
+}
+
+// Setup makes a redacting logger writing to w the default, for slog and for
+// the standard log package alike.
+func Setup(cfg Config, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: ParseLevel(cfg.Level)}
+	var handler slog.Handler
+	if strings.EqualFold(cfg.Format, FormatJSON) {
+		handler = slog.NewJSONHandler(w, opts)
+	} else {
+		handler = slog.NewTextHandler(w, opts)
+	}
+
+	setIdentifierKey(cfg.IdentifierKey)
+	logger := slog.New(&redactingHandler{next: handler})
+	slog.SetDefault(logger)
+	log.SetFlags(0)
+	return logger
+}
+
+// ParseLevel maps a level name to its slog level, defaulting to info.
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+type requestIDKey struct{}
+
+// WithRequestID tags ctx, and the records logged with it, with id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID of ctx, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random 16 character hex ID.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestID reports whether an ID taken from a request is short and
+// plain enough to be logged and passed on.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > MaxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// Err is the attribute errors are logged under.
+func Err(err error) slog.Attr {
+	return slog.Any("error", err)
+}
@@ -0,0 +1,174 @@
+package logging
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sync/atomic"
+)
+
+// contentKeys name attributes holding user content, which is logged as its
+// length only.
+var contentKeys = map[string]bool{
+	"text":     true,
+	"fact":     true,
+	"query":    true,
+	"question": true,
+	"prompt":   true,
+	"answer":   true,
+	"content":  true,
+	"document": true,
+}
+
+// identifierKeys name attributes identifying a person. They are logged
+// hashed, so the entries of one person can still be told apart.
+var identifierKeys = map[string]bool{
+	"user_id": true,
+	"user":    true,
+	"chat_id": true,
+	"email":   true,
+}
+
+var redactions = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// API keys in URLs, as in the Gemini ?key= parameter.
+	{regexp.MustCompile(`(?i)([?&](?:key|api_key|apikey|access_token|token)=)[^&\s"']+`), "${1}REDACTED"},
+	{regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`), "${1}REDACTED"},
+	{regexp.MustCompile(`\biara_[A-Za-z0-9_-]{8,}`), "iara_REDACTED"},
+	{regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`), "REDACTED"},
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "[email]"},
+	// Brazilian CPF numbers and phone numbers in international format.
+	{regexp.MustCompile(`\b\d{3}\.\d{3}\.\d{3}-\d{2}\b`), "[cpf]"},
+	{regexp.MustCompile(`\+\d{1,3}[\s-]?\(?\d{2,3}\)?[\s-]?\d{4,5}[\s-]?\d{4}\b`), "[phone]"},
+}
+
+// Redact masks credentials and personal identifiers in free text.
+func Redact(s string) string {
+	for _, r := range redactions {
+		s = r.pattern.ReplaceAllString(s, r.replacement)
+	}
+	return s
+}
+
+// identifierKey keys the HMAC behind Identifier. Without it, anyone could
+// hash a guessed user ID and look for it in the logs.
+var identifierKey atomic.Pointer[[]byte]
+
+// setIdentifierKey sets the key pseudonyms are derived from. An empty key is
+// replaced by a random one, so pseudonyms are then stable only until the
+// process restarts.
+func setIdentifierKey(key string) {
+	secret := []byte(key)
+	if len(secret) == 0 {
+		secret = randomIdentifierKey()
+	}
+	identifierKey.Store(&secret)
+}
+
+func randomIdentifierKey() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}
+
+// Identifier returns a stable pseudonym for a personal identifier, keyed by
+// the deployment's identifier key. Before Setup, a random key is used.
+func Identifier(id string) string {
+	if id == "" {
+		return ""
+	}
+	key := identifierKey.Load()
+	if key == nil {
+		secret := randomIdentifierKey()
+		identifierKey.CompareAndSwap(nil, &secret)
+		key = identifierKey.Load()
+	}
+	mac := hmac.New(sha256.New, *key)
+	mac.Write([]byte(id))
+	return "id_" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// redactingHandler redacts records before passing them on and adds the
+// request ID of their context.
+type redactingHandler struct {
+	next slog.Handler
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	redacted := slog.NewRecord(r.Time, r.Level, Redact(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(a))
+		return true
+	})
+	if id := RequestID(ctx); id != "" {
+		redacted.AddAttrs(slog.String("request_id", id))
+	}
+	return h.next.Handle(ctx, redacted)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redactAttr(a)
+	}
+	return &redactingHandler{next: h.next.WithAttrs(redacted)}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{next: h.next.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	switch {
+	case contentKeys[a.Key]:
+		if a.Value.Kind() == slog.KindString {
+			return slog.String(a.Key, fmt.Sprintf("[redacted %d chars]", len(a.Value.String())))
+		}
+		return slog.String(a.Key, "[redacted]")
+	case identifierKeys[a.Key]:
+		return slog.String(a.Key, Identifier(a.Value.String()))
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(a.Value.String()))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		redacted := make([]any, len(group))
+		for i, member := range group {
+			redacted[i] = redactAttr(member)
+		}
+		return slog.Group(a.Key, redacted...)
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case error:
+			return slog.String(a.Key, Redact(v.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, Redact(v.String()))
+		default:
+			// Slices, maps and structs can hold anything the patterns
+			// catch, so they are logged as redacted JSON.
+			encoded, err := json.Marshal(v)
+			if err != nil {
+				return slog.String(a.Key, Redact(fmt.Sprint(v)))
+			}
+			return slog.String(a.Key, Redact(string(encoded)))
+		}
+	}
+	return a
+}
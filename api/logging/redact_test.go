@@ -0,0 +1,69 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedactAttrAny(t *testing.T) {
+	type component struct {
+		Name  string `json:"name"`
+		Error string `json:"error"`
+	}
+	tests := []struct {
+		name    string
+		value   any
+		leaked  string
+		wantSub string
+	}{
+		{name: "slice", value: []string{"alice@example.com"}, leaked: "alice@example.com", wantSub: "[email]"},
+		{name: "map", value: map[string]string{"url": "https://x/?key=secret123"}, leaked: "secret123", wantSub: "REDACTED"},
+		{name: "struct", value: []component{{Name: "gemini", Error: "Bearer abc.def"}}, leaked: "abc.def", wantSub: "gemini"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got := redactAttr(slog.Any("value", tt.value)).Value.String()
+			if strings.Contains(got, tt.leaked) {
+				t.Errorf("got = %q, leaks %q", got, tt.leaked)
+			}
+			if !strings.Contains(got, tt.wantSub) {
+				t.Errorf("got = %q, want it to contain %q", got, tt.wantSub)
+			}
+		})
+	}
+}
+
+func TestIdentifierIsKeyed(t *testing.T) {
+	setIdentifierKey("first")
+	first := Identifier("alice")
+	if again := Identifier("alice"); again != first {
+		t.Errorf("pseudonym changed under the same key: %q, then %q", first, again)
+	}
+	setIdentifierKey("second")
+	if second := Identifier("alice"); second == first {
+		t.Errorf("pseudonym %q does not depend on the key", second)
+	}
+}
+
+func TestSetupRedactsUserID(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	var buf bytes.Buffer
+	logger := Setup(Config{Format: FormatJSON, Level: "info", IdentifierKey: "key"}, &buf)
+	logger.Info("Learning fact", "user_id", "alice", "text", "my secret fact")
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if got := record["user_id"]; got != Identifier("alice") {
+		t.Errorf("user_id = %v, want %q", got, Identifier("alice"))
+	}
+	if strings.Contains(buf.String(), "my secret fact") {
+		t.Errorf("log leaks the fact: %s", buf.String())
+	}
+}
@@ -2,85 +2,68 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"iara-assistant/config"
 	"iara-assistant/handlers"
+	"iara-assistant/logging"
 	"iara-assistant/services"
 )
 
-type HealthResponse struct {
-	Status    string    `json:"status"`
-	Timestamp time.Time `json:"timestamp"`
-	Version   string    `json:"version"`
-}
-
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Failed to load configuration", err)
 	}
-
-	response := HealthResponse{
-		Status:    "healthy",
-		Timestamp: time.Now().UTC(),
-		Version:   "1.0.0",
+	logging.Setup(cfg.Log, os.Stderr)
+	if cfg.File() != "" {
+		slog.Info("Configuration loaded", "file", cfg.File())
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error encoding health response: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+	if cfg.Log.IdentifierKey == "" {
+		slog.Warn("LOG_IDENTIFIER_KEY is not set, user pseudonyms in the logs change on every restart")
 	}
-}
 
-func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// The server and the reindex command share the state in the data
+	// directory and neither sees the other's writes, so only one runs at a
+	// time.
+	releaseDataDir, err := services.LockDataDir(cfg.DataDir)
+	if errors.Is(err, services.ErrDataDirLocked) && len(os.Args) > 1 && os.Args[1] == "reindex" {
+		fatal("The API server is running; start the re-index with POST /v1/admin/reindex instead", err)
 	}
-
-	// Initialize cron service
-	cronService := services.NewCronService()
-	if err := cronService.Start(); err != nil {
-		log.Fatalf("Failed to start cron service: %v", err)
+	if err != nil {
+		fatal("Failed to lock the data directory", err)
 	}
+	defer releaseDataDir()
 
-	mux := http.NewServeMux()
-
-	mux.HandleFunc("/health", healthHandler)
-	mux.HandleFunc("/v1/message", handlers.MessageHandler)
-	mux.HandleFunc("/v1/learn", handlers.LearnHandler)
-
-	mux.HandleFunc("/v1/trigger-crawler", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
+	if len(os.Args) > 1 && os.Args[1] == "reindex" {
+		runReindex(cfg)
+		return
+	}
 
-		if err := cronService.TriggerCrawler(); err != nil {
-			log.Printf("Manual crawler trigger error: %v", err)
-			http.Error(w, "Crawler error", http.StatusInternalServerError)
-			return
-		}
+	// appCtx lives as long as the server; background work started outside a
+	// request is bound to it.
+	appCtx, cancelApp := context.WithCancel(context.Background())
+	defer cancelApp()
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"status": "crawler triggered"})
-	})
+	app, err := newApp(appCtx, cfg)
+	if err != nil {
+		fatal("Failed to start services", err)
+	}
+	if err := app.start(appCtx); err != nil {
+		fatal("Failed to schedule background jobs", err)
+	}
 
 	server := &http.Server{
-		Addr:         ":" + port,
-		Handler:      mux,
+		Addr:         ":" + cfg.Port,
+		Handler:      app.server.Handler(),
 		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		WriteTimeout: handlers.WriteTimeout,
 		IdleTimeout:  120 * time.Second,
 	}
 
@@ -88,25 +71,52 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	shutdownDone := make(chan struct{})
 	go func() {
+		defer close(shutdownDone)
 		<-sigChan
-		log.Println("Shutdown signal received")
-
-		cronService.Stop()
+		slog.Info("Shutdown signal received")
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 
+		// Stop taking requests and let in-flight ones finish, then wait for
+		// running cron jobs before cancelling whatever is left.
 		if err := server.Shutdown(ctx); err != nil {
-			log.Printf("Server shutdown error: %v", err)
+			slog.Error("Server shutdown failed", logging.Err(err))
 		}
+		app.stop(ctx, cancelApp)
 	}()
 
-	log.Printf("Starting Iara API server on port %s", port)
-	log.Println("DOM crawler scheduled to run every 2 hours from 8AM to 11PM (America/Sao_Paulo)")
+	slog.Info("Starting Iara API server", "port", cfg.Port)
 
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("Server failed to start: %v", err)
+		fatal("Server failed to start", err)
 	}
+	<-shutdownDone
+	slog.Info("Shutdown complete")
 }
 
+// runReindex re-embeds the knowledge base with the configured embedding model
+// and exits. Interrupting it keeps its progress for the next run.
+func runReindex(cfg *config.Config) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	rag, err := services.NewRAGService(ctx, cfg.RAG())
+	if err != nil {
+		fatal("Failed to start services", err)
+	}
+	progress, err := rag.Reindex(ctx)
+	rag.FlushCaches()
+	if err != nil {
+		fatal("Re-index failed", err)
+	}
+	slog.Info("Re-index finished", "processed", progress.Processed, "embedded", progress.Embedded, "target", progress.Target)
+}
+
+// fatal logs err and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, logging.Err(err))
+	os.Exit(1)
+}
@@ -0,0 +1,163 @@
+// Package metrics defines the Prometheus metrics of the API. They are
+// registered with the default registry, which /metrics serves together with
+// the Go runtime and process collectors.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "iara"
+
+// Outcomes used as label values.
+const (
+	OutcomeSuccess   = "success"
+	OutcomeError     = "error"
+	OutcomeBlocked   = "blocked"
+	OutcomeUnchanged = "unchanged"
+)
+
+// Crawler sources.
+const (
+	SourceDOM         = "dom"
+	SourceWatchedURLs = "watched_urls"
+)
+
+// slowBuckets suit requests that may wait on a model for tens of seconds.
+var slowBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60}
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests handled, by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by route and method.",
+		Buckets:   slowBuckets,
+	}, []string{"route", "method"})
+
+	UpstreamRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "upstream",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of each attempt at calling Gemini or ChromaDB, by service and status code (or error).",
+		Buckets:   slowBuckets,
+	}, []string{"service", "code"})
+
+	LLMCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "llm",
+		Name:      "calls_total",
+		Help:      "Model calls, by task, model and outcome.",
+	}, []string{"task", "model", "outcome"})
+
+	LLMTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "llm",
+		Name:      "tokens_total",
+		Help:      "Tokens used by model calls, by task and model.",
+	}, []string{"task", "model"})
+
+	RetrievalQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "retrieval",
+		Name:      "queries_total",
+		Help:      "Retrievals, by whether they found any document (hit) or not (miss).",
+	}, []string{"result"})
+
+	RetrievalHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "retrieval",
+		Name:      "hits_total",
+		Help:      "Documents found by retrieval, by retriever (vector or keyword).",
+	}, []string{"retriever"})
+
+	RetrievalDistance = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "retrieval",
+		Name:      "distance",
+		Help:      "Vector distance of the documents found by similarity search.",
+		Buckets:   prometheus.LinearBuckets(0.1, 0.1, 15),
+	})
+
+	CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "requests_total",
+		Help:      "Cache lookups, by cache (embedding or answer) and result (hit or miss).",
+	}, []string{"cache", "result"})
+
+	EmbeddingCacheEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "cache",
+		Name:      "embedding_entries",
+		Help:      "Embeddings held in the embedding cache.",
+	})
+
+	CrawlerRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "crawler",
+		Name:      "runs_total",
+		Help:      "Crawler runs, by source and outcome.",
+	}, []string{"source", "outcome"})
+
+	CrawlerLastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "crawler",
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful crawler run, by source.",
+	}, []string{"source"})
+
+	CronJobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "cron",
+		Name:      "job_duration_seconds",
+		Help:      "Duration of cron job runs, by job and outcome.",
+		Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600, 1800},
+	}, []string{"job", "outcome"})
+)
+
+// Handler serves the default registry.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveCrawl records the outcome of a crawler run. Unchanged runs count as
+// successful.
+func ObserveCrawl(source, outcome string) {
+	CrawlerRuns.WithLabelValues(source, outcome).Inc()
+	if outcome != OutcomeError {
+		CrawlerLastSuccess.WithLabelValues(source).Set(float64(time.Now().Unix()))
+	}
+}
+
+// Method returns the HTTP method as a label value. Methods outside the
+// standard set are reported as "other", since the method is chosen by the
+// client and would otherwise add a series per invented method.
+func Method(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// Outcome maps an error to OutcomeSuccess or OutcomeError.
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeError
+	}
+	return OutcomeSuccess
+}
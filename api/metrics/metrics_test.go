@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveCrawl(t *testing.T) {
+	tests := []struct {
+		source      string
+		outcome     string
+		wantSuccess bool
+	}{
+		{source: "test_success", outcome: OutcomeSuccess, wantSuccess: true},
+		{source: "test_unchanged", outcome: OutcomeUnchanged, wantSuccess: true},
+		{source: "test_error", outcome: OutcomeError, wantSuccess: false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.source, func(t *testing.T) {
+			before := time.Now().Unix()
+			ObserveCrawl(tt.source, tt.outcome)
+
+			if got := testutil.ToFloat64(CrawlerRuns.WithLabelValues(tt.source, tt.outcome)); got != 1 {
+				t.Errorf("runs = %v, want 1", got)
+			}
+			lastSuccess := testutil.ToFloat64(CrawlerLastSuccess.WithLabelValues(tt.source))
+			if got := lastSuccess >= float64(before); got != tt.wantSuccess {
+				t.Errorf("last success = %v, set = %v, want %v", lastSuccess, got, tt.wantSuccess)
+			}
+		})
+	}
+}
+
+func TestMethod(t *testing.T) {
+	tests := []struct {
+		method string
+		want   string
+	}{
+		{method: "GET", want: "GET"},
+		{method: "DELETE", want: "DELETE"},
+		{method: "get", want: "other"},
+		{method: "BREW", want: "other"},
+	}
+
+	for _, tt := range tests {
+		if got := Method(tt.method); got != tt.want {
+			t.Errorf("Method(%q) = %q, want %q", tt.method, got, tt.want)
+		}
+	}
+}
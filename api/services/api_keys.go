@@ -0,0 +1,215 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"iara-assistant/logging"
+)
+
+const (
+	apiKeysFile  = "api_keys.json"
+	APIKeyPrefix = "iara_"
+
+	ScopeChat    = "chat"
+	ScopeLearn   = "learn"
+	ScopeAdmin   = "admin"
+	ScopeCrawler = "crawler"
+	ScopeMetrics = "metrics"
+
+	// BootstrapKeyID identifies the admin key configured through the
+	// environment rather than created through the API.
+	BootstrapKeyID = "bootstrap"
+)
+
+var validScopes = map[string]bool{
+	ScopeChat:    true,
+	ScopeLearn:   true,
+	ScopeAdmin:   true,
+	ScopeCrawler: true,
+	ScopeMetrics: true,
+}
+
+// APIKey describes a key without its secret. A key bound to a user can only
+// act as that user.
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Scopes    []string   `json:"scopes"`
+	UserID    string     `json:"user_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// HasScope reports whether the key grants scope. Admin keys grant every
+// scope.
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+type storedAPIKey struct {
+	APIKey
+	Hash string `json:"hash"`
+}
+
+// APIKeyStore keeps API keys, persisted as JSON in the data directory. Only
+// a SHA-256 hash of each key is stored; keys are random enough that a slow
+// hash would add nothing.
+type APIKeyStore struct {
+	mu            sync.Mutex
+	path          string
+	keys          map[string]*storedAPIKey
+	bootstrapHash string
+}
+
+// NewAPIKeyStore loads the stored keys. A non-empty bootstrapKey is accepted
+// as an admin key, so the first keys can be created.
+func NewAPIKeyStore(dataDir, bootstrapKey string) *APIKeyStore {
+	store := &APIKeyStore{
+		path: filepath.Join(dataDir, apiKeysFile),
+		keys: make(map[string]*storedAPIKey),
+	}
+	if bootstrapKey != "" {
+		store.bootstrapHash = hashAPIKey(bootstrapKey)
+	}
+	if err := readJSONFile(store.path, &store.keys); err != nil {
+		slog.Warn("Failed to load API keys", logging.Err(err))
+	}
+	return store
+}
+
+// Create generates a new key and returns it together with its secret, which
+// is not stored and cannot be shown again.
+func (s *APIKeyStore) Create(name string, scopes []string, userID string) (APIKey, string, error) {
+	if len(scopes) == 0 {
+		return APIKey{}, "", fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !validScopes[scope] {
+			return APIKey{}, "", fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	id, err := randomToken(9)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+	secret = APIKeyPrefix + secret
+
+	key := &storedAPIKey{
+		APIKey: APIKey{
+			ID:        "key_" + id,
+			Name:      strings.TrimSpace(name),
+			Prefix:    secret[:len(APIKeyPrefix)+6],
+			Scopes:    scopes,
+			UserID:    userID,
+			CreatedAt: time.Now().UTC(),
+		},
+		Hash: hashAPIKey(secret),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key.ID] = key
+	if err := writeJSONFile(s.path, s.keys); err != nil {
+		delete(s.keys, key.ID)
+		return APIKey{}, "", err
+	}
+	return key.APIKey, secret, nil
+}
+
+// List returns every key, revoked ones included, oldest first.
+func (s *APIKeyStore) List() []APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key.APIKey)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+// Revoke disables a key. Revoked keys are kept so the list shows them. It
+// reports false when no such key exists.
+func (s *APIKeyStore) Revoke(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return false, nil
+	}
+	if key.RevokedAt == nil {
+		now := time.Now().UTC()
+		key.RevokedAt = &now
+	}
+	return true, writeJSONFile(s.path, s.keys)
+}
+
+// Authenticate returns the active key matching secret.
+func (s *APIKeyStore) Authenticate(secret string) (APIKey, bool) {
+	if secret == "" {
+		return APIKey{}, false
+	}
+	hash := hashAPIKey(secret)
+	if s.bootstrapHash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(s.bootstrapHash)) == 1 {
+		return APIKey{ID: BootstrapKeyID, Name: "ADMIN_API_KEY", Scopes: []string{ScopeAdmin}}, true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range s.keys {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(key.Hash)) == 1 {
+			if key.RevokedAt != nil {
+				return APIKey{}, false
+			}
+			return key.APIKey, true
+		}
+	}
+	return APIKey{}, false
+}
+
+// Configured reports whether any key could authenticate.
+func (s *APIKeyStore) Configured() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range s.keys {
+		if key.RevokedAt == nil {
+			return true
+		}
+	}
+	return s.bootstrapHash != ""
+}
+
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,563 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"iara-assistant/clients"
+	"iara-assistant/logging"
+)
+
+const (
+	ArchiveVersion    = 1
+	ImportModeMerge   = "merge"
+	ImportModeReplace = "replace"
+	BackupFilePrefix  = "iara-backup-"
+	ExportPageSize    = 500
+	ImportBatchSize   = 100
+
+	archiveManifest      = "manifest.json"
+	archiveDocuments     = "documents.jsonl"
+	archiveSessions      = "state/sessions.json"
+	archivePersonas      = "state/personas.json"
+	archiveWatchedURLs   = "state/watched_urls.json"
+	archiveVaultManifest = "state/vault_manifest.json"
+	archiveLastDOM       = "state/last_dom"
+)
+
+// BackupConfig controls the scheduled backups. An empty Dir means a
+// "backups" directory inside the data directory; a Keep of zero keeps every
+// archive.
+type BackupConfig struct {
+	Dir               string
+	Keep              int
+	IncludeEmbeddings bool
+}
+
+func DefaultBackupConfig() BackupConfig {
+	return BackupConfig{
+		Keep:              7,
+		IncludeEmbeddings: true,
+	}
+}
+
+// ArchiveManifest describes an exported archive. Embeddings are only reused
+// on import when they were made with the model the importing service uses.
+type ArchiveManifest struct {
+	Version            int    `json:"version"`
+	CreatedAt          string `json:"created_at"`
+	Collection         string `json:"collection"`
+	EmbeddingModel     string `json:"embedding_model"`
+	EmbeddingDimension int    `json:"embedding_dimension,omitempty"`
+	Documents          int    `json:"documents"`
+	Embeddings         bool   `json:"embeddings"`
+}
+
+type archivedDocument struct {
+	ID        string                 `json:"id"`
+	Document  string                 `json:"document"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	Embedding []float32              `json:"embedding,omitempty"`
+}
+
+type ImportResult struct {
+	Mode         string `json:"mode"`
+	Documents    int    `json:"documents"`
+	Embedded     int    `json:"embedded"`
+	Sessions     int    `json:"sessions"`
+	Personas     int    `json:"personas"`
+	WatchedURLs  int    `json:"watched_urls"`
+	Vaults       int    `json:"vaults"`
+	CrawlerState bool   `json:"crawler_state"`
+	// Backup is the archive of the memory a replace import replaced.
+	Backup string `json:"backup,omitempty"`
+}
+
+// BackupService exports the assistant's memory — every stored document and
+// the state files next to it — as a zip archive, imports such archives, and
+// writes rotated backups.
+type BackupService struct {
+	rag         *RAGService
+	vault       *VaultSyncer
+	cfg         BackupConfig
+	lastDOMPath string
+
+	// mu keeps imports and backups from interleaving.
+	mu sync.Mutex
+}
+
+func NewBackupService(rag *RAGService, vault *VaultSyncer, dataDir string, cfg BackupConfig) *BackupService {
+	if cfg.Dir == "" {
+		cfg.Dir = filepath.Join(dataDir, "backups")
+	}
+	return &BackupService{rag: rag, vault: vault, cfg: cfg, lastDOMPath: LastDOMPath(dataDir)}
+}
+
+// Export writes an archive of the active collection and the state files to w.
+func (b *BackupService) Export(ctx context.Context, w io.Writer, includeEmbeddings bool) (*ArchiveManifest, error) {
+	collection := b.rag.activeCollection()
+	model, dimension := collection.embedding()
+	manifest := &ArchiveManifest{
+		Version:            ArchiveVersion,
+		CreatedAt:          time.Now().UTC().Format(time.RFC3339),
+		Collection:         collection.Name(),
+		EmbeddingModel:     model,
+		EmbeddingDimension: dimension,
+		Embeddings:         includeEmbeddings,
+	}
+
+	zw := zip.NewWriter(w)
+	entry, err := zw.Create(archiveDocuments)
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(entry)
+
+	// Paging by offset would skip documents when others are deleted
+	// meanwhile, so the IDs are read first and the documents fetched by ID.
+	ids, err := b.rag.chromaClient.ListIDs(ctx, collection.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read documents: %w", err)
+	}
+	for start := 0; start < len(ids); start += ExportPageSize {
+		pageIDs := ids[start:min(start+ExportPageSize, len(ids))]
+		var page *clients.GetResponse
+		if includeEmbeddings {
+			page, err = b.rag.chromaClient.GetDocumentsWithEmbeddings(ctx, collection.Name(), pageIDs)
+		} else {
+			page, err = b.rag.chromaClient.GetDocuments(ctx, collection.Name(), pageIDs)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read documents: %w", err)
+		}
+		for i, id := range page.IDs {
+			if i >= len(page.Documents) {
+				break
+			}
+			doc := archivedDocument{ID: id, Document: page.Documents[i]}
+			if i < len(page.Metadatas) {
+				doc.Metadata = page.Metadatas[i]
+			}
+			if i < len(page.Embeddings) {
+				doc.Embedding = page.Embeddings[i]
+			}
+			if err := enc.Encode(doc); err != nil {
+				return nil, err
+			}
+			manifest.Documents++
+		}
+	}
+
+	state := map[string]interface{}{
+		archiveSessions:      b.rag.sessions.snapshot(),
+		archivePersonas:      b.rag.personas.snapshot(),
+		archiveWatchedURLs:   b.rag.watchedURLs.List(),
+		archiveVaultManifest: b.vault.snapshot(),
+		archiveManifest:      manifest,
+	}
+	for name, v := range state {
+		if err := writeArchiveJSON(zw, name, v); err != nil {
+			return nil, err
+		}
+	}
+
+	if lastDOM, err := os.ReadFile(b.lastDOMPath); err == nil {
+		entry, err := zw.Create(archiveLastDOM)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := entry.Write(lastDOM); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Import restores an archive written by Export. In merge mode archived
+// documents and state entries are added over the current ones. In replace
+// mode the documents are imported into a new collection, which only becomes
+// the active one once all of them are stored; the previous collection is
+// kept, and a backup of the current memory is written first.
+func (b *BackupService) Import(ctx context.Context, r io.ReaderAt, size int64, mode string) (*ImportResult, error) {
+	if mode == "" {
+		mode = ImportModeMerge
+	}
+	if mode != ImportModeMerge && mode != ImportModeReplace {
+		return nil, fmt.Errorf("unknown import mode %q", mode)
+	}
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive: %w", err)
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	var manifest ArchiveManifest
+	if found, err := readArchiveJSON(files, archiveManifest, &manifest); err != nil {
+		return nil, err
+	} else if !found {
+		return nil, errors.New("invalid archive: missing manifest")
+	}
+	if manifest.Version > ArchiveVersion {
+		return nil, fmt.Errorf("archive version %d is newer than supported version %d", manifest.Version, ArchiveVersion)
+	}
+	// Every state entry is decoded before anything is changed, so a corrupt
+	// one cannot leave the import half done.
+	state, err := readArchiveState(files)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	replace := mode == ImportModeReplace
+	result := &ImportResult{Mode: mode}
+	reuseEmbeddings := manifest.Embeddings && manifest.EmbeddingModel == b.rag.googleClient.EmbeddingModel()
+	if replace {
+		if err := b.replaceDocuments(ctx, files[archiveDocuments], reuseEmbeddings, result); err != nil {
+			return result, err
+		}
+	} else if f, ok := files[archiveDocuments]; ok {
+		if err := b.importDocuments(ctx, f, reuseEmbeddings, b.rag.upsertDocuments, result); err != nil {
+			return result, err
+		}
+	}
+
+	if err := b.restoreState(state, replace, result); err != nil {
+		return result, err
+	}
+
+	slog.InfoContext(ctx, "Imported archive",
+		"created_at", manifest.CreatedAt,
+		"mode", mode,
+		"documents", result.Documents,
+		"embedded", result.Embedded)
+	return result, nil
+}
+
+// replaceDocuments imports the archived documents into a new collection and
+// makes it the active one. Writes made meanwhile go to both collections, as
+// during a re-index, which this excludes. If the import fails, the new
+// collection is dropped and the active one is left as it was.
+func (b *BackupService) replaceDocuments(ctx context.Context, f *zip.File, reuseEmbeddings bool, result *ImportResult) error {
+	rag := b.rag
+	if !rag.reindexMu.TryLock() {
+		return ErrReindexRunning
+	}
+	defer rag.reindexMu.Unlock()
+
+	path, err := b.backup(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to back up the current memory: %w", err)
+	}
+	result.Backup = path
+
+	previous := rag.activeCollection()
+	target := &vectorCollection{name: newCollectionName(), model: rag.googleClient.EmbeddingModel()}
+	if err := rag.chromaClient.CreateCollection(ctx, target.Name(), target.metadata()); err != nil {
+		return err
+	}
+	rag.setReindexTarget(target)
+
+	if f != nil {
+		store := func(ctx context.Context, batch clients.AddRequest) error {
+			if err := rag.checkEmbeddings(ctx, target, batch.Embeddings); err != nil {
+				return err
+			}
+			return rag.chromaClient.UpsertDocuments(ctx, target.Name(), batch)
+		}
+		err = b.importDocuments(ctx, f, reuseEmbeddings, store, result)
+	}
+	if err == nil {
+		err = rag.replaceCollection(ctx, target)
+	}
+	if err != nil {
+		rag.setReindexTarget(nil)
+		// The request may be what was canceled, and the new collection
+		// should go regardless.
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		if err := rag.chromaClient.DeleteCollection(cleanupCtx, target.Name()); err != nil {
+			slog.WarnContext(ctx, "Failed to drop collection of failed import", "collection", target.Name(), logging.Err(err))
+		}
+		return err
+	}
+
+	slog.InfoContext(ctx, "Replaced collection from archive", "active", target.Name(), "kept", previous.Name(), "backup", path)
+	return nil
+}
+
+// archiveState holds the state entries of an archive; a nil field is an
+// entry the archive does not have.
+type archiveState struct {
+	sessions map[string][]ChatTurn
+	personas map[string]Persona
+	watched  []WatchedURL
+	vaults   map[string]map[string]vaultFileState
+	lastDOM  *zip.File
+}
+
+func readArchiveState(files map[string]*zip.File) (*archiveState, error) {
+	state := &archiveState{lastDOM: files[archiveLastDOM]}
+	entries := map[string]interface{}{
+		archiveSessions:      &state.sessions,
+		archivePersonas:      &state.personas,
+		archiveWatchedURLs:   &state.watched,
+		archiveVaultManifest: &state.vaults,
+	}
+	for name, v := range entries {
+		if _, err := readArchiveJSON(files, name, v); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+func (b *BackupService) restoreState(state *archiveState, replace bool, result *ImportResult) error {
+	if state.sessions != nil {
+		if err := b.rag.sessions.restore(state.sessions, replace); err != nil {
+			return err
+		}
+		result.Sessions = len(state.sessions)
+	}
+	if state.personas != nil {
+		if err := b.rag.personas.restore(state.personas, replace); err != nil {
+			return err
+		}
+		result.Personas = len(state.personas)
+	}
+	if state.watched != nil {
+		if err := b.rag.watchedURLs.restore(state.watched, replace); err != nil {
+			return err
+		}
+		result.WatchedURLs = len(state.watched)
+	}
+	if state.vaults != nil {
+		if err := b.vault.restore(state.vaults, replace); err != nil {
+			return err
+		}
+		result.Vaults = len(state.vaults)
+	}
+	if state.lastDOM != nil {
+		restored, err := restoreLastDOM(state.lastDOM, b.lastDOMPath, replace)
+		if err != nil {
+			return err
+		}
+		result.CrawlerState = restored
+	}
+	return nil
+}
+
+// importDocuments reads the archived documents in batches, embedding them
+// unless their embeddings can be reused, and hands each batch to store.
+func (b *BackupService) importDocuments(ctx context.Context, f *zip.File, reuseEmbeddings bool, store func(context.Context, clients.AddRequest) error, result *ImportResult) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	var docs []archivedDocument
+	flush := func() error {
+		if len(docs) == 0 {
+			return nil
+		}
+		batch := clients.AddRequest{}
+		var missing []string
+		for _, doc := range docs {
+			batch.IDs = append(batch.IDs, doc.ID)
+			batch.Documents = append(batch.Documents, doc.Document)
+			batch.Metadatas = append(batch.Metadatas, doc.Metadata)
+			batch.Embeddings = append(batch.Embeddings, doc.Embedding)
+			if !reuseEmbeddings || len(doc.Embedding) == 0 {
+				missing = append(missing, doc.Document)
+			}
+		}
+		if len(missing) > 0 {
+			// Mixing reused and fresh embeddings would only save a few
+			// calls, so a batch is re-embedded as a whole.
+			embeddings, err := b.rag.embedBatch(ctx, batch.Documents)
+			if err != nil {
+				return fmt.Errorf("embedding generation failed: %w", err)
+			}
+			batch.Embeddings = embeddings
+			result.Embedded += len(docs)
+		}
+		if err := store(ctx, batch); err != nil {
+			return err
+		}
+		result.Documents += len(docs)
+		docs = docs[:0]
+		return nil
+	}
+
+	dec := json.NewDecoder(rc)
+	for {
+		var doc archivedDocument
+		if err := dec.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("invalid %s: %w", archiveDocuments, err)
+		}
+		if doc.ID == "" {
+			continue
+		}
+		docs = append(docs, doc)
+		if len(docs) == ImportBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// Backup writes an archive into the backup directory and removes the oldest
+// archives beyond the configured number. It returns the archive's path.
+func (b *BackupService) Backup(ctx context.Context) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.backup(ctx)
+}
+
+// backup is Backup for callers holding mu.
+func (b *BackupService) backup(ctx context.Context) (string, error) {
+	if err := os.MkdirAll(b.cfg.Dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	path := filepath.Join(b.cfg.Dir, backupFileName(time.Now()))
+
+	tmp, err := os.CreateTemp(b.cfg.Dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	manifest, err := b.Export(ctx, tmp, b.cfg.IncludeEmbeddings)
+	if err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to export: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+	slog.InfoContext(ctx, "Backup written", "documents", manifest.Documents, "path", path)
+
+	if err := b.rotate(); err != nil {
+		slog.WarnContext(ctx, "Failed to remove old backups", logging.Err(err))
+	}
+	return path, nil
+}
+
+// backupFileName names the archive of a backup taken at t. The timestamp has
+// nanoseconds, so a backup taken right before an import does not replace a
+// scheduled one from the same second, and names still sort chronologically.
+func backupFileName(t time.Time) string {
+	return BackupFilePrefix + t.UTC().Format("20060102-150405.000000000") + ".zip"
+}
+
+func (b *BackupService) rotate() error {
+	if b.cfg.Keep <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(b.cfg.Dir)
+	if err != nil {
+		return err
+	}
+
+	var archives []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, BackupFilePrefix) && strings.HasSuffix(name, ".zip") {
+			archives = append(archives, name)
+		}
+	}
+	// Timestamped names sort chronologically.
+	sort.Strings(archives)
+	for len(archives) > b.cfg.Keep {
+		if err := os.Remove(filepath.Join(b.cfg.Dir, archives[0])); err != nil {
+			return err
+		}
+		archives = archives[1:]
+	}
+	return nil
+}
+
+// restoreLastDOM restores the crawler's last notified publication. When
+// merging, the later of the two publications wins so no notification is
+// sent twice.
+func restoreLastDOM(f *zip.File, path string, replace bool) (bool, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+	archived, err := io.ReadAll(rc)
+	if err != nil {
+		return false, err
+	}
+
+	if !replace {
+		current, _ := os.ReadFile(path)
+		archivedNumber, err := strconv.Atoi(strings.TrimSpace(string(archived)))
+		currentNumber, currentErr := strconv.Atoi(strings.TrimSpace(string(current)))
+		if err != nil || (currentErr == nil && currentNumber >= archivedNumber) {
+			return false, nil
+		}
+	}
+	if err := writeFileAtomic(path, archived); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func writeArchiveJSON(zw *zip.Writer, name string, v interface{}) error {
+	entry, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(entry)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// readArchiveJSON decodes an archive entry into v, reporting whether the
+// entry exists.
+func readArchiveJSON(files map[string]*zip.File, name string, v interface{}) (bool, error) {
+	f, ok := files[name]
+	if !ok {
+		return false, nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return true, err
+	}
+	defer rc.Close()
+	if err := json.NewDecoder(rc).Decode(v); err != nil {
+		return true, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestBackupFileName(t *testing.T) {
+	at := time.Date(2026, 10, 18, 3, 0, 0, 0, time.UTC)
+	times := []time.Time{at, at.Add(time.Millisecond), at.Add(500 * time.Millisecond), at.Add(time.Second)}
+
+	var names []string
+	for _, tm := range times {
+		names = append(names, backupFileName(tm))
+	}
+	if names[0] == names[1] {
+		t.Fatalf("backups in the same second share the name %q", names[0])
+	}
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("names = %v, want them sorted chronologically", names)
+	}
+}
+
+func TestBackupRotateKeepsNewest(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2026, 10, 18, 3, 0, 0, 0, time.UTC)
+	var names []string
+	for i := 0; i < 4; i++ {
+		name := backupFileName(at.Add(time.Duration(i) * 300 * time.Millisecond))
+		names = append(names, name)
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &BackupService{cfg: BackupConfig{Dir: dir, Keep: 2}}
+	if err := b.rotate(); err != nil {
+		t.Fatalf("rotate() err = %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Name())
+	}
+	want := []string{names[2], names[3], "notes.txt"}
+	if len(got) != len(want) {
+		t.Fatalf("left %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("left %v, want %v", got, want)
+			break
+		}
+	}
+}
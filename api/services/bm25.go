@@ -0,0 +1,204 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+const (
+	BM25K1 = 1.2
+	BM25B  = 0.75
+)
+
+type indexedDoc struct {
+	text     string
+	metadata map[string]interface{}
+	terms    map[string]int
+	length   int
+}
+
+type ScoredDoc struct {
+	ID       string
+	Text     string
+	Metadata map[string]interface{}
+	Score    float64
+}
+
+// BM25Index is an in-memory inverted index over the stored documents. It
+// complements embedding search, which is weak at exact tokens like plate
+// numbers or edital numbers.
+type BM25Index struct {
+	mu          sync.RWMutex
+	docs        map[string]*indexedDoc
+	postings    map[string]map[string]int
+	totalLength int
+}
+
+func NewBM25Index() *BM25Index {
+	return &BM25Index{
+		docs:     make(map[string]*indexedDoc),
+		postings: make(map[string]map[string]int),
+	}
+}
+
+// Add indexes a document, replacing any previous version with the same ID.
+func (idx *BM25Index) Add(id, text string, metadata map[string]interface{}) {
+	terms := make(map[string]int)
+	tokens := tokenize(text)
+	for _, token := range tokens {
+		terms[token]++
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(id)
+	idx.docs[id] = &indexedDoc{
+		text:     text,
+		metadata: copyMetadata(metadata),
+		terms:    terms,
+		length:   len(tokens),
+	}
+	idx.totalLength += len(tokens)
+	for term, tf := range terms {
+		if idx.postings[term] == nil {
+			idx.postings[term] = make(map[string]int)
+		}
+		idx.postings[term][id] = tf
+	}
+}
+
+// UpdateMetadata merges metadata into an indexed document, mirroring
+// ChromaDB's update semantics.
+func (idx *BM25Index) UpdateMetadata(id string, metadata map[string]interface{}) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	for k, v := range metadata {
+		doc.metadata[k] = v
+	}
+}
+
+// Reset empties the index.
+func (idx *BM25Index) Reset() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.docs = make(map[string]*indexedDoc)
+	idx.postings = make(map[string]map[string]int)
+	idx.totalLength = 0
+}
+
+func (idx *BM25Index) Remove(ids ...string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, id := range ids {
+		idx.remove(id)
+	}
+}
+
+// RemoveWhere removes every document whose metadata matches the Chroma where
+// filter.
+func (idx *BM25Index) RemoveWhere(where map[string]interface{}) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for id, doc := range idx.docs {
+		if matchesWhere(doc.metadata, where) {
+			idx.remove(id)
+		}
+	}
+}
+
+func (idx *BM25Index) remove(id string) {
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	for term := range doc.terms {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	idx.totalLength -= doc.length
+	delete(idx.docs, id)
+}
+
+func (idx *BM25Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+// Search returns up to k documents matching the where filter, ranked by their
+// BM25 score for the query.
+func (idx *BM25Index) Search(query string, k int, where map[string]interface{}) []ScoredDoc {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if len(idx.docs) == 0 {
+		return nil
+	}
+
+	n := float64(len(idx.docs))
+	avgLength := float64(idx.totalLength) / n
+	scores := make(map[string]float64)
+
+	seen := make(map[string]bool)
+	for _, term := range tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		postings := idx.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+		for id, tf := range postings {
+			length := float64(idx.docs[id].length)
+			f := float64(tf)
+			scores[id] += idf * f * (BM25K1 + 1) / (f + BM25K1*(1-BM25B+BM25B*length/avgLength))
+		}
+	}
+
+	results := make([]ScoredDoc, 0, len(scores))
+	for id, score := range scores {
+		doc := idx.docs[id]
+		if where != nil && !matchesWhere(doc.metadata, where) {
+			continue
+		}
+		results = append(results, ScoredDoc{
+			ID:       id,
+			Text:     doc.text,
+			Metadata: copyMetadata(doc.metadata),
+			Score:    score,
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	if k > 0 && len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
+
+func copyMetadata(metadata map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(metadata))
+	for k, v := range metadata {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,71 @@
+package services
+
+import "testing"
+
+func newTestBM25Index() *BM25Index {
+	idx := NewBM25Index()
+	idx.Add("plate", "A placa do carro da Maria é ABC-1D23.", map[string]interface{}{"user_id": "alice"})
+	idx.Add("cars", "Os carros da família ficam na garagem.", map[string]interface{}{"user_id": "alice"})
+	idx.Add("doctor", "A médica atende às terças.", map[string]interface{}{"user_id": "bob"})
+	idx.Add("edital", "O edital 01/2025 abre inscrições em março.", map[string]interface{}{"user_id": ""})
+	return idx
+}
+
+func TestBM25IndexSearch(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		where map[string]interface{}
+		want  []string
+	}{
+		{name: "exact plate", query: "ABC-1D23", want: []string{"plate"}},
+		{name: "plate without hyphen", query: "qual o carro com placa abc 1d23?", want: []string{"plate", "cars"}},
+		{name: "plural matches singular", query: "carro", want: []string{"cars", "plate"}},
+		{name: "gender forms", query: "médicos", want: []string{"doctor"}},
+		{name: "edital number", query: "edital 01/2025", want: []string{"edital"}},
+		{name: "filtered by user", query: "carro médica", where: retrievalFilter("bob"), want: []string{"doctor"}},
+		{name: "stopwords only", query: "o que é isso", want: nil},
+	}
+
+	idx := newTestBM25Index()
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			hits := idx.Search(tt.query, 10, tt.where)
+			var got []string
+			for _, hit := range hits {
+				got = append(got, hit.ID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestBM25IndexReplaceAndRemove(t *testing.T) {
+	idx := newTestBM25Index()
+
+	idx.Add("plate", "A placa da moto é XYZ-9A87.", map[string]interface{}{"user_id": "alice"})
+	if hits := idx.Search("ABC-1D23", 10, nil); len(hits) != 0 {
+		t.Errorf("old version still found: %v", hits)
+	}
+	if hits := idx.Search("XYZ-9A87", 10, nil); len(hits) != 1 || hits[0].ID != "plate" {
+		t.Errorf("new version hits = %v, want plate", hits)
+	}
+
+	idx.Remove("plate")
+	idx.RemoveWhere(map[string]interface{}{"user_id": "bob"})
+	if got := idx.Len(); got != 2 {
+		t.Errorf("Len() = %d, want 2", got)
+	}
+	if hits := idx.Search("médica", 10, nil); len(hits) != 0 {
+		t.Errorf("removed document still found: %v", hits)
+	}
+}
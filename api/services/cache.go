@@ -0,0 +1,363 @@
+package services
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"iara-assistant/clients"
+	"iara-assistant/logging"
+	"iara-assistant/metrics"
+)
+
+const (
+	embeddingCacheFile = "embedding_cache.gob"
+
+	// EmbeddingCacheSaveInterval is how often a changed embedding cache is
+	// written to disk.
+	EmbeddingCacheSaveInterval = time.Minute
+	// MaxCachedAnswersPerUser bounds the answer cache of each user; the
+	// oldest answers are evicted first.
+	MaxCachedAnswersPerUser = 50
+)
+
+// CacheConfig controls the embedding and answer caches. An EmbeddingCacheSize
+// of zero disables the embedding cache. The answer cache is off by default:
+// it reuses an answer when a new question embeds within AnswerCacheDistance
+// (cosine distance) of one answered before, ignoring conversation history.
+type CacheConfig struct {
+	EmbeddingCacheSize  int
+	AnswerCache         bool
+	AnswerCacheDistance float64
+	AnswerCacheTTL      time.Duration
+}
+
+func DefaultCacheConfig() CacheConfig {
+	return CacheConfig{
+		EmbeddingCacheSize:  10000,
+		AnswerCache:         false,
+		AnswerCacheDistance: 0.05,
+		AnswerCacheTTL:      time.Hour,
+	}
+}
+
+const (
+	cacheEmbedding = "embedding"
+	cacheAnswer    = "answer"
+)
+
+type embeddingCacheEntry struct {
+	Key    string
+	Vector []float32
+}
+
+// EmbeddingCache is an LRU cache of embeddings keyed by a hash of the model
+// and the text, persisted in the data directory so restarts do not pay for
+// re-embedding.
+type EmbeddingCache struct {
+	mu       sync.Mutex
+	path     string
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+	dirty    bool
+}
+
+func NewEmbeddingCache(dataDir string, capacity int) *EmbeddingCache {
+	cache := &EmbeddingCache{
+		path:     filepath.Join(dataDir, embeddingCacheFile),
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+	if capacity <= 0 {
+		return cache
+	}
+	if err := cache.load(); err != nil {
+		slog.Warn("Failed to load embedding cache", logging.Err(err))
+	}
+	return cache
+}
+
+// contentCacheKey keys what a model computed from text, such as its
+// embedding or token count.
+func contentCacheKey(model, text string) string {
+	sum := sha256.Sum256([]byte(model + "\x00" + text))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *EmbeddingCache) Get(model, text string) ([]float32, bool) {
+	if c.capacity <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[contentCacheKey(model, text)]
+	if !ok {
+		metrics.CacheRequests.WithLabelValues(cacheEmbedding, "miss").Inc()
+		return nil, false
+	}
+	metrics.CacheRequests.WithLabelValues(cacheEmbedding, "hit").Inc()
+	c.order.MoveToFront(elem)
+	return elem.Value.(*embeddingCacheEntry).Vector, true
+}
+
+func (c *EmbeddingCache) Put(model, text string, vector []float32) {
+	if c.capacity <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := contentCacheKey(model, text)
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*embeddingCacheEntry).Vector = vector
+		c.order.MoveToFront(elem)
+	} else {
+		c.entries[key] = c.order.PushFront(&embeddingCacheEntry{Key: key, Vector: vector})
+		for c.order.Len() > c.capacity {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*embeddingCacheEntry).Key)
+		}
+	}
+	c.dirty = true
+	metrics.EmbeddingCacheEntries.Set(float64(c.order.Len()))
+}
+
+// Save writes the cache to disk if it changed since the last save.
+func (c *EmbeddingCache) Save() error {
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	entries := make([]embeddingCacheEntry, 0, c.order.Len())
+	for elem := c.order.Front(); elem != nil; elem = elem.Next() {
+		entries = append(entries, *elem.Value.(*embeddingCacheEntry))
+	}
+	c.dirty = false
+	c.mu.Unlock()
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entries); err != nil {
+		return fmt.Errorf("failed to encode embedding cache: %w", err)
+	}
+	return writeFileAtomic(c.path, buf.Bytes())
+}
+
+// load reads a cache saved by Save, most recently used entry first.
+func (c *EmbeddingCache) load() error {
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", c.path, err)
+	}
+
+	var entries []embeddingCacheEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entries); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", c.path, err)
+	}
+	for i := range entries {
+		if c.order.Len() >= c.capacity {
+			break
+		}
+		entry := entries[i]
+		c.entries[entry.Key] = c.order.PushBack(&entry)
+	}
+	metrics.EmbeddingCacheEntries.Set(float64(c.order.Len()))
+	slog.Info("Embedding cache loaded", "entries", c.order.Len())
+	return nil
+}
+
+type cachedAnswer struct {
+	Embedding []float32
+	Language  string
+	Answer    string
+	Model     string
+	Time      time.Time
+}
+
+// AnswerCache remembers recent answers per user and serves them again for
+// questions that embed close enough to one already answered.
+type AnswerCache struct {
+	mu       sync.Mutex
+	distance float64
+	ttl      time.Duration
+	answers  map[string][]cachedAnswer
+}
+
+func NewAnswerCache(distance float64, ttl time.Duration) *AnswerCache {
+	return &AnswerCache{
+		distance: distance,
+		ttl:      ttl,
+		answers:  make(map[string][]cachedAnswer),
+	}
+}
+
+// Lookup returns the closest cached answer for a question embedding in the
+// given language, if one is close enough and not expired, along with the
+// model that produced it.
+func (c *AnswerCache) Lookup(userID, language string, embedding []float32) (string, string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	best, bestDistance := -1, c.distance
+	for i, cached := range c.answers[userID] {
+		if cached.Language != language || (c.ttl > 0 && time.Since(cached.Time) > c.ttl) {
+			continue
+		}
+		if d := cosineDistance(embedding, cached.Embedding); d <= bestDistance {
+			best, bestDistance = i, d
+		}
+	}
+	if best < 0 {
+		metrics.CacheRequests.WithLabelValues(cacheAnswer, "miss").Inc()
+		return "", "", false
+	}
+	metrics.CacheRequests.WithLabelValues(cacheAnswer, "hit").Inc()
+	return c.answers[userID][best].Answer, c.answers[userID][best].Model, true
+}
+
+func (c *AnswerCache) Store(userID, language string, embedding []float32, answer, model string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	answers := append(c.answers[userID], cachedAnswer{
+		Embedding: embedding,
+		Language:  language,
+		Answer:    answer,
+		Model:     model,
+		Time:      time.Now(),
+	})
+	if len(answers) > MaxCachedAnswersPerUser {
+		answers = answers[len(answers)-MaxCachedAnswersPerUser:]
+	}
+	c.answers[userID] = answers
+}
+
+// Invalidate drops every cached answer of a user, or of all users when
+// userID is empty.
+func (c *AnswerCache) Invalidate(userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if userID == "" {
+		c.answers = make(map[string][]cachedAnswer)
+		return
+	}
+	delete(c.answers, userID)
+}
+
+func cosineDistance(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 1
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 1
+	}
+	return 1 - dot/(math.Sqrt(normA)*math.Sqrt(normB))
+}
+
+// embed returns the embedding of text, from the cache when possible.
+func (s *RAGService) embed(ctx context.Context, text string) ([]float32, error) {
+	model := s.googleClient.EmbeddingModel()
+	if embedding, ok := s.embeddings.Get(model, text); ok {
+		return embedding, nil
+	}
+	ctx, cancel := withStageTimeout(ctx, s.retrieval.EmbeddingTimeout)
+	defer cancel()
+	s.usage.RecordEmbeddingCall(ctx)
+	embedding, err := s.googleClient.GenerateEmbedding(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	s.embeddings.Put(model, text, embedding)
+	return embedding, nil
+}
+
+// embedBatch returns the embeddings of texts in order, embedding the ones
+// that are not cached with as few batch requests as possible.
+func (s *RAGService) embedBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	model := s.googleClient.EmbeddingModel()
+	embeddings := make([][]float32, len(texts))
+	var missing []int
+	for i, text := range texts {
+		if embedding, ok := s.embeddings.Get(model, text); ok {
+			embeddings[i] = embedding
+		} else {
+			missing = append(missing, i)
+		}
+	}
+
+	for start := 0; start < len(missing); start += clients.MaxBatchEmbedSize {
+		end := min(start+clients.MaxBatchEmbedSize, len(missing))
+		batch := make([]string, 0, end-start)
+		for _, i := range missing[start:end] {
+			batch = append(batch, texts[i])
+		}
+
+		generated, err := s.generateEmbeddings(ctx, batch)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range missing[start:end] {
+			embeddings[i] = generated[j]
+			s.embeddings.Put(model, texts[i], generated[j])
+		}
+	}
+	return embeddings, nil
+}
+
+func (s *RAGService) generateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	ctx, cancel := withStageTimeout(ctx, s.retrieval.EmbeddingTimeout)
+	defer cancel()
+	s.usage.RecordEmbeddingCall(ctx)
+	return s.googleClient.GenerateEmbeddings(ctx, texts)
+}
+
+// saveEmbeddingCache periodically persists the embedding cache until ctx is
+// done.
+func (s *RAGService) saveEmbeddingCache(ctx context.Context) {
+	ticker := time.NewTicker(EmbeddingCacheSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.embeddings.Save(); err != nil {
+				slog.Warn("Failed to save embedding cache", logging.Err(err))
+			}
+		}
+	}
+}
+
+// FlushCaches writes the caches and counters that survive restarts to disk.
+func (s *RAGService) FlushCaches() {
+	if err := s.embeddings.Save(); err != nil {
+		slog.Warn("Failed to save embedding cache", logging.Err(err))
+	}
+	if err := s.usage.Save(); err != nil {
+		slog.Warn("Failed to save usage counters", logging.Err(err))
+	}
+}
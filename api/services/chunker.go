@@ -0,0 +1,148 @@
+package services
+
+import (
+	"strings"
+)
+
+const (
+	DefaultChunkSize    = 1200
+	DefaultChunkOverlap = 200
+)
+
+type Chunk struct {
+	Index   int
+	Heading string
+	Text    string
+}
+
+// chunkSections splits sections into chunks of at most size characters,
+// repeating the last overlap characters of a chunk at the start of the next
+// one. Chunks never span two sections, and each chunk is prefixed with its
+// heading path so it still makes sense on its own.
+func chunkSections(sections []documentSection, size, overlap int) []Chunk {
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+	if overlap < 0 || overlap >= size {
+		overlap = 0
+	}
+
+	var chunks []Chunk
+	for _, section := range sections {
+		heading := strings.Join(section.Headings, " > ")
+		for _, body := range splitText(section.Text, size, overlap) {
+			text := body
+			if heading != "" {
+				text = heading + "\n\n" + body
+			}
+			chunks = append(chunks, Chunk{
+				Index:   len(chunks),
+				Heading: heading,
+				Text:    text,
+			})
+		}
+	}
+	return chunks
+}
+
+// splitText packs paragraphs, then sentences, then words into pieces of at
+// most size characters.
+func splitText(text string, size, overlap int) []string {
+	var pieces []string
+	var current strings.Builder
+
+	emit := func() {
+		piece := strings.TrimSpace(current.String())
+		current.Reset()
+		if piece == "" {
+			return
+		}
+		pieces = append(pieces, piece)
+		if tail := overlapTail(piece, overlap); tail != "" {
+			current.WriteString(tail)
+		}
+	}
+
+	add := func(unit, sep string) {
+		if current.Len() > 0 && current.Len()+len(sep)+len(unit) > size {
+			emit()
+			// Drop the carried overlap when the unit does not fit after it.
+			if current.Len()+len(sep)+len(unit) > size {
+				current.Reset()
+			}
+		}
+		if current.Len() > 0 {
+			current.WriteString(sep)
+		}
+		current.WriteString(unit)
+	}
+
+	for _, paragraph := range splitParagraphs(text) {
+		if len(paragraph) <= size {
+			add(paragraph, "\n\n")
+			continue
+		}
+		for _, sentence := range splitSentences(paragraph) {
+			if len(sentence) <= size {
+				add(sentence, " ")
+				continue
+			}
+			for _, word := range strings.Fields(sentence) {
+				add(word, " ")
+			}
+		}
+	}
+
+	// Only flush what is left if it holds more than the carried overlap.
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		if len(pieces) == 0 || rest != overlapTail(pieces[len(pieces)-1], overlap) {
+			pieces = append(pieces, rest)
+		}
+	}
+
+	return pieces
+}
+
+func splitParagraphs(text string) []string {
+	var paragraphs []string
+	for _, p := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	return paragraphs
+}
+
+func splitSentences(text string) []string {
+	var sentences []string
+	start := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '.', '!', '?', ';', '\n':
+			if i+1 == len(text) || text[i+1] == ' ' || text[i+1] == '\n' {
+				if s := strings.TrimSpace(text[start : i+1]); s != "" {
+					sentences = append(sentences, s)
+				}
+				start = i + 1
+			}
+		}
+	}
+	if s := strings.TrimSpace(text[start:]); s != "" {
+		sentences = append(sentences, s)
+	}
+	return sentences
+}
+
+// overlapTail returns roughly the last n characters of text, starting at a
+// word boundary.
+func overlapTail(text string, n int) string {
+	if n <= 0 || len(text) <= n {
+		return ""
+	}
+	tail := text[len(text)-n:]
+	i := strings.IndexAny(tail, " \n")
+	if i == -1 {
+		return ""
+	}
+	return strings.TrimSpace(tail[i+1:])
+}
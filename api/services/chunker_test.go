@@ -0,0 +1,95 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+// paragraph returns a paragraph of n characters made of words.
+func paragraph(word string, n int) string {
+	return strings.TrimSpace(strings.Repeat(word+" ", n/(len(word)+1)+1)[:n])
+}
+
+func TestSplitText(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		size       int
+		overlap    int
+		wantPieces int
+		wantCarry  bool
+	}{
+		{
+			name:       "fits in one piece",
+			text:       "Primeiro parágrafo.\n\nSegundo parágrafo.",
+			size:       1200,
+			overlap:    200,
+			wantPieces: 1,
+		},
+		{
+			name:       "overlap too long to carry",
+			text:       paragraph("alfa", 1100) + "\n\n" + paragraph("beta", 1100),
+			size:       1200,
+			overlap:    200,
+			wantPieces: 2,
+		},
+		{
+			name:       "overlap carried",
+			text:       paragraph("alfa", 700) + "\n\n" + paragraph("beta", 700),
+			size:       1200,
+			overlap:    200,
+			wantPieces: 2,
+			wantCarry:  true,
+		},
+		{
+			name:       "long sentence split into words",
+			text:       paragraph("gama", 3000),
+			size:       1000,
+			overlap:    100,
+			wantPieces: 4,
+			wantCarry:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			pieces := splitText(tt.text, tt.size, tt.overlap)
+			if len(pieces) != tt.wantPieces {
+				t.Fatalf("got %d pieces, want %d", len(pieces), tt.wantPieces)
+			}
+			for i, piece := range pieces {
+				if len(piece) > tt.size {
+					t.Errorf("piece %d has %d characters, want at most %d", i, len(piece), tt.size)
+				}
+			}
+			for i := 1; i < len(pieces); i++ {
+				tail := overlapTail(pieces[i-1], tt.overlap)
+				if carried := strings.HasPrefix(pieces[i], tail); carried != tt.wantCarry {
+					t.Errorf("piece %d starts with the previous overlap = %v, want %v", i, carried, tt.wantCarry)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkSectionsHeadingPrefix(t *testing.T) {
+	sections := []documentSection{
+		{Headings: []string{"Manual", "Instalação"}, Text: "Rode o instalador."},
+		{Text: "Texto sem título."},
+	}
+
+	chunks := chunkSections(sections, 1200, 200)
+	want := []Chunk{
+		{Index: 0, Heading: "Manual > Instalação", Text: "Manual > Instalação\n\nRode o instalador."},
+		{Index: 1, Text: "Texto sem título."},
+	}
+	if len(chunks) != len(want) {
+		t.Fatalf("got %d chunks, want %d", len(chunks), len(want))
+	}
+	for i := range want {
+		if chunks[i] != want[i] {
+			t.Errorf("chunk %d = %+v, want %+v", i, chunks[i], want[i])
+		}
+	}
+}
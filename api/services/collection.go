@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"iara-assistant/clients"
+	"iara-assistant/logging"
+)
+
+const (
+	// CollectionAliasFile names the collection queries and writes go to. A
+	// re-index replaces it to switch to the re-embedded collection.
+	CollectionAliasFile = "collection.json"
+
+	MetadataEmbeddingModel     = "embedding_model"
+	MetadataEmbeddingDimension = "embedding_dimension"
+)
+
+// ErrEmbeddingMismatch is returned when embeddings from the configured model
+// would be compared with, or stored next to, vectors from another model.
+var ErrEmbeddingMismatch = errors.New("embedding model does not match the collection")
+
+type collectionAlias struct {
+	Collection string `json:"collection"`
+	UpdatedAt  string `json:"updated_at,omitempty"`
+}
+
+// vectorCollection is a ChromaDB collection together with the embedding
+// model and dimension its vectors were made with. A zero dimension is
+// learned from the first embedding checked against the collection.
+type vectorCollection struct {
+	mu        sync.Mutex
+	name      string
+	model     string
+	dimension int
+}
+
+func (c *vectorCollection) Name() string {
+	return c.name
+}
+
+// embedding returns the model and dimension of the collection's vectors.
+func (c *vectorCollection) embedding() (string, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.model, c.dimension
+}
+
+func (c *vectorCollection) metadata() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	metadata := map[string]interface{}{
+		"description":          "Iara assistant facts storage",
+		MetadataEmbeddingModel: c.model,
+	}
+	if c.dimension > 0 {
+		metadata[MetadataEmbeddingDimension] = c.dimension
+	}
+	return metadata
+}
+
+// check reports whether embeddings made with model belong in the collection.
+// learned is true when the check recorded the collection's dimension.
+func (c *vectorCollection) check(model string, embeddings [][]float32) (learned bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if model != c.model {
+		return false, fmt.Errorf("%w: collection %s was embedded with %s, not %s", ErrEmbeddingMismatch, c.name, c.model, model)
+	}
+	for _, embedding := range embeddings {
+		if c.dimension == 0 {
+			c.dimension = len(embedding)
+			learned = true
+		}
+		if len(embedding) != c.dimension {
+			return learned, fmt.Errorf("%w: collection %s has %d dimensions, got %d", ErrEmbeddingMismatch, c.name, c.dimension, len(embedding))
+		}
+	}
+	return learned, nil
+}
+
+func (s *RAGService) activeCollection() *vectorCollection {
+	s.collectionMu.RLock()
+	defer s.collectionMu.RUnlock()
+	return s.collection
+}
+
+// checkEmbeddings refuses embeddings that do not belong in the collection,
+// recording its dimension the first time it is known.
+func (s *RAGService) checkEmbeddings(ctx context.Context, c *vectorCollection, embeddings [][]float32) error {
+	learned, err := c.check(s.googleClient.EmbeddingModel(), embeddings)
+	if learned {
+		if err := s.chromaClient.UpdateCollectionMetadata(ctx, c.Name(), c.metadata()); err != nil {
+			slog.WarnContext(ctx, "Failed to record embedding dimension", "collection", c.Name(), logging.Err(err))
+		}
+	}
+	return err
+}
+
+// initializeCollection opens the collection named by the alias file, creating
+// it if needed, and reads the embedding model it was built with. Collections
+// created before the model was recorded were all embedded with
+// clients.DefaultEmbeddingModel.
+func (s *RAGService) initializeCollection(ctx context.Context) error {
+	var alias collectionAlias
+	aliasErr := readJSONFile(filepath.Join(s.dataDir, CollectionAliasFile), &alias)
+	name := alias.Collection
+	if name == "" {
+		name = CollectionName
+	}
+
+	model := s.googleClient.EmbeddingModel()
+	collection := &vectorCollection{name: name, model: model}
+	s.collectionMu.Lock()
+	s.collection = collection
+	s.collectionMu.Unlock()
+	if aliasErr != nil {
+		return aliasErr
+	}
+
+	if err := s.chromaClient.CreateCollection(ctx, name, collection.metadata()); err != nil {
+		return err
+	}
+	info, err := s.chromaClient.GetCollection(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	recordedModel, _ := info.Metadata[MetadataEmbeddingModel].(string)
+	collectionModel := recordedModel
+	if collectionModel == "" {
+		collectionModel = clients.DefaultEmbeddingModel
+	}
+	collection.mu.Lock()
+	collection.model = collectionModel
+	if dimension, ok := info.Metadata[MetadataEmbeddingDimension].(float64); ok {
+		collection.dimension = int(dimension)
+	}
+	collection.mu.Unlock()
+
+	if recordedModel == "" {
+		if err := s.chromaClient.UpdateCollectionMetadata(ctx, name, collection.metadata()); err != nil {
+			slog.WarnContext(ctx, "Failed to record embedding model", "collection", name, logging.Err(err))
+		}
+	}
+	if collectionModel != model {
+		slog.WarnContext(ctx, "Collection was embedded with another model; vector queries are refused until it is re-indexed",
+			"collection", name,
+			"collection_model", collectionModel,
+			"configured_model", model)
+	}
+	return nil
+}
+
+// newCollectionName names a collection created to replace the active one.
+func newCollectionName() string {
+	return fmt.Sprintf("%s_%s", CollectionName, time.Now().UTC().Format("20060102150405"))
+}
+
+// swapCollection makes target the active collection and records it in the
+// alias file, so a restart keeps using it.
+func (s *RAGService) swapCollection(target *vectorCollection) error {
+	alias := collectionAlias{
+		Collection: target.Name(),
+		UpdatedAt:  time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := writeJSONFile(filepath.Join(s.dataDir, CollectionAliasFile), alias); err != nil {
+		return err
+	}
+
+	s.collectionMu.Lock()
+	s.collection = target
+	s.collectionMu.Unlock()
+	s.invalidateAnswers("")
+	return nil
+}
+
+// replaceCollection makes target, which holds other documents than the active
+// collection, the active collection. Writes wait while the keyword index is
+// rebuilt from it.
+func (s *RAGService) replaceCollection(ctx context.Context, target *vectorCollection) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if err := s.swapCollection(target); err != nil {
+		return err
+	}
+	s.setReindexTarget(nil)
+	s.keywordIndex.Reset()
+	if err := s.loadKeywordIndex(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to reload keyword index", logging.Err(err))
+	}
+	return nil
+}
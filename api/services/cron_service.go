@@ -1,60 +1,198 @@
 package services
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/robfig/cron/v3"
+
+	"iara-assistant/logging"
+	"iara-assistant/metrics"
 )
 
+const crawlerJobName = "DOM crawler"
+
+// CronConfig holds the schedules of the background jobs, in standard cron
+// syntax, and the timezone they are read in. A Backup schedule of "off"
+// disables scheduled backups.
+type CronConfig struct {
+	Timezone   string
+	Crawler    string
+	URLRefresh string
+	Backup     string
+}
+
+// BackupScheduleOff disables scheduled backups.
+const BackupScheduleOff = "off"
+
+func DefaultCronConfig() CronConfig {
+	return CronConfig{
+		Timezone: "America/Sao_Paulo",
+		// Every 2 hours from 8 to 23 (8, 10, 12, 14, 16, 18, 20, 22).
+		Crawler:    "0 8-23/2 * * *",
+		URLRefresh: "0 7-23/6 * * *",
+		Backup:     "30 3 * * *",
+	}
+}
+
+// CronService runs scheduled jobs. Jobs get a context that is cancelled
+// when Stop gives up waiting for them.
 type CronService struct {
+	cfg     CronConfig
 	cron    *cron.Cron
 	crawler *DOMCrawler
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	mu          sync.Mutex
+	startedAt   time.Time
+	lastCrawl   time.Time
+	lastSuccess time.Time
+	lastErr     error
 }
 
-func NewCronService() *CronService {
+func NewCronService(cfg CronConfig, crawler CrawlerConfig, dataDir string) *CronService {
 	// Create cron with timezone support
-	location, err := time.LoadLocation("America/Sao_Paulo")
+	location, err := time.LoadLocation(cfg.Timezone)
 	if err != nil {
-		log.Printf("Warning: Could not load timezone, using UTC: %v", err)
+		slog.Warn("Could not load timezone, using UTC", "timezone", cfg.Timezone, logging.Err(err))
 		location = time.UTC
 	}
 
 	c := cron.New(cron.WithLocation(location))
-	crawler := NewDOMCrawler()
 
+	ctx, cancel := context.WithCancel(context.Background())
 	return &CronService{
-		cron:    c,
-		crawler: crawler,
+		cfg:       cfg,
+		cron:      c,
+		crawler:   NewDOMCrawler(crawler, dataDir),
+		ctx:       ctx,
+		cancel:    cancel,
+		startedAt: time.Now(),
 	}
 }
 
 func (cs *CronService) Start() error {
-	// Schedule the crawler to run every 2 hours from 08:00 to 23:59
-	// Cron expression: "0 8-23/2 * * *" means every 2 hours from 8 to 23 (8, 10, 12, 14, 16, 18, 20, 22)
-	_, err := cs.cron.AddFunc("0 8-23/2 * * *", func() {
-		log.Println("DOM crawler cron job triggered")
-		if err := cs.crawler.CrawlDOM(); err != nil {
-			log.Printf("DOM crawler error: %v", err)
+	if cs.crawler.Enabled() {
+		_, err := cs.cron.AddFunc(cs.cfg.Crawler, func() {
+			cs.run(crawlerJobName, cs.crawl)
+		})
+		if err != nil {
+			return err
 		}
+	} else {
+		slog.Warn("CRAWLER_WEBHOOK_URL is not set, the DOM crawler is disabled")
+	}
+
+	slog.Info("DOM crawler cron service started", "schedule", cs.cfg.Crawler, "timezone", cs.cfg.Timezone)
+	cs.cron.Start()
+	return nil
+}
+
+// AddJob schedules an additional job on the same cron instance as the
+// crawler. Errors returned by the job are logged.
+func (cs *CronService) AddJob(spec, name string, job func(ctx context.Context) error) error {
+	_, err := cs.cron.AddFunc(spec, func() {
+		cs.run(name, job)
 	})
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to schedule %s: %w", name, err)
 	}
 
-	log.Println("DOM crawler cron service started - running every 2 hours from 8AM to 11PM")
-	cs.cron.Start()
+	slog.Info("Cron job scheduled", "job", name, "schedule", spec)
 	return nil
 }
 
-func (cs *CronService) Stop() {
-	log.Println("Stopping DOM crawler cron service")
-	cs.cron.Stop()
+// run runs a job under an ID of its own, so its log lines can be grouped,
+// and records how long it took.
+func (cs *CronService) run(name string, job func(ctx context.Context) error) {
+	ctx := logging.WithRequestID(cs.ctx, logging.NewRequestID())
+	slog.InfoContext(ctx, "Cron job triggered", "job", name)
+
+	start := time.Now()
+	err := job(ctx)
+	metrics.CronJobDuration.WithLabelValues(name, metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		slog.ErrorContext(ctx, "Cron job failed", "job", name, logging.Err(err))
+	}
+}
+
+// crawl runs the DOM crawler and records its outcome. Finding no new
+// publication is not an error.
+func (cs *CronService) crawl(ctx context.Context) error {
+	err := cs.crawler.CrawlDOM(ctx)
+	outcome := metrics.Outcome(err)
+	if errors.Is(err, ErrNoNewPublication) {
+		slog.InfoContext(ctx, "No new DOM publication")
+		outcome, err = metrics.OutcomeUnchanged, nil
+	}
+	metrics.ObserveCrawl(metrics.SourceDOM, outcome)
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.lastCrawl, cs.lastErr = time.Now(), err
+	if err == nil {
+		cs.lastSuccess = cs.lastCrawl
+	}
+	return err
+}
+
+// CrawlerHealth reports the crawler as degraded when its last run failed or
+// it has not succeeded within its MaxAge. The crawler is not critical, and a
+// disabled one is reported as ok.
+func (cs *CronService) CrawlerHealth() ComponentHealth {
+	maxAge := cs.crawler.cfg.MaxAge
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	health := ComponentHealth{Name: "crawler", Status: ComponentOK, CheckedAt: time.Now().UTC()}
+	if !cs.crawler.Enabled() {
+		health.Message = "disabled"
+		return health
+	}
+	since := cs.lastSuccess
+	if since.IsZero() {
+		since = cs.startedAt
+	}
+	switch {
+	case cs.lastErr != nil:
+		health.Status = ComponentDegraded
+		health.Message = fmt.Sprintf("last run at %s failed: %s", cs.lastCrawl.UTC().Format(time.RFC3339), logging.Redact(cs.lastErr.Error()))
+	case time.Since(since) > maxAge:
+		health.Status = ComponentDegraded
+		health.Message = fmt.Sprintf("no successful run for %s", time.Since(since).Round(time.Minute))
+	case cs.lastSuccess.IsZero():
+		health.Message = "no run yet"
+	default:
+		health.Message = fmt.Sprintf("last successful run at %s", cs.lastSuccess.UTC().Format(time.RFC3339))
+	}
+	return health
+}
+
+// Stop stops scheduling jobs and waits for running ones to finish. If ctx
+// ends first, running jobs are cancelled and ctx's error is returned.
+func (cs *CronService) Stop(ctx context.Context) error {
+	slog.Info("Stopping DOM crawler cron service")
+	drained := cs.cron.Stop()
+	defer cs.cancel()
+
+	select {
+	case <-drained.Done():
+		slog.Info("Cron jobs finished")
+		return nil
+	case <-ctx.Done():
+		slog.Warn("Cron jobs still running at shutdown, cancelling them")
+		return ctx.Err()
+	}
 }
 
 // Manual trigger for testing purposes
-func (cs *CronService) TriggerCrawler() error {
-	log.Println("Manual DOM crawler trigger")
-	return cs.crawler.CrawlDOM()
+func (cs *CronService) TriggerCrawler(ctx context.Context) error {
+	slog.InfoContext(ctx, "Manual DOM crawler trigger")
+	return cs.crawl(ctx)
 }
 
@@ -0,0 +1,276 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"iara-assistant/clients"
+	"iara-assistant/logging"
+)
+
+const (
+	EmbeddingBatchSize = 16
+	SourceTypeUpload   = "upload"
+)
+
+type IngestRequest struct {
+	Title   string
+	Source  string
+	Format  string
+	UserID  string
+	Content []byte
+}
+
+type IngestResponse struct {
+	Success    bool   `json:"success"`
+	Message    string `json:"message"`
+	Error      string `json:"error,omitempty"`
+	DocumentID string `json:"document_id,omitempty"`
+	Title      string `json:"title,omitempty"`
+	Chunks     int    `json:"chunks,omitempty"`
+}
+
+type DocumentInfo struct {
+	ID         string `json:"id"`
+	Title      string `json:"title"`
+	Source     string `json:"source,omitempty"`
+	SourceType string `json:"source_type"`
+	Format     string `json:"format,omitempty"`
+	UserID     string `json:"user_id,omitempty"`
+	Chunks     int    `json:"chunks"`
+	IngestedAt string `json:"ingested_at"`
+}
+
+// storedDocument describes a document whose chunks are stored together. The
+// metadata in Extra is copied onto every chunk.
+type storedDocument struct {
+	ID         string
+	Title      string
+	Source     string
+	SourceType string
+	Format     string
+	UserID     string
+	Extra      map[string]interface{}
+}
+
+func (s *RAGService) IngestDocument(ctx context.Context, req IngestRequest) (*IngestResponse, error) {
+	if len(req.Content) == 0 {
+		return &IngestResponse{
+			Success: false,
+			Error:   "Document cannot be empty",
+		}, nil
+	}
+
+	sections, err := extractSections(req.Format, req.Content)
+	if err != nil {
+		return &IngestResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Could not read %s document: %v", req.Format, err),
+		}, nil
+	}
+
+	chunks := chunkSections(sections, DefaultChunkSize, DefaultChunkOverlap)
+	if len(chunks) == 0 {
+		return &IngestResponse{
+			Success: false,
+			Error:   "Document has no readable text",
+		}, nil
+	}
+
+	title := req.Title
+	if title == "" {
+		title = req.Source
+	}
+	if title == "" && len(sections[0].Headings) > 0 {
+		title = sections[0].Headings[0]
+	}
+	if title == "" {
+		title = documentTitle(sections[0].Text)
+	}
+
+	doc := storedDocument{
+		ID:         contentDocumentID(req.UserID, req.Content),
+		Title:      title,
+		Source:     req.Source,
+		SourceType: SourceTypeUpload,
+		Format:     req.Format,
+		UserID:     req.UserID,
+	}
+
+	slog.InfoContext(ctx, "Ingesting document", "document_id", doc.ID, "format", doc.Format, "chunks", len(chunks))
+	if err := s.storeDocument(ctx, doc, chunks); err != nil {
+		return &IngestResponse{
+			Success: false,
+			Error:   "Failed to store document",
+		}, err
+	}
+
+	return &IngestResponse{
+		Success:    true,
+		Message:    fmt.Sprintf("Document learned successfully in %d chunks!", len(chunks)),
+		DocumentID: doc.ID,
+		Title:      doc.Title,
+		Chunks:     len(chunks),
+	}, nil
+}
+
+// storeDocument replaces every stored chunk of the document with the given
+// chunks, embedding and upserting them in batches. Chunks left over from a
+// longer previous version are removed afterwards.
+func (s *RAGService) storeDocument(ctx context.Context, doc storedDocument, chunks []Chunk) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	for start := 0; start < len(chunks); start += EmbeddingBatchSize {
+		end := start + EmbeddingBatchSize
+		if end > len(chunks) {
+			end = len(chunks)
+		}
+
+		texts := make([]string, 0, end-start)
+		for _, chunk := range chunks[start:end] {
+			texts = append(texts, chunk.Text)
+		}
+		embeddings, err := s.embedBatch(ctx, texts)
+		if err != nil {
+			return fmt.Errorf("embedding generation failed for chunks %d-%d: %w", start, end-1, err)
+		}
+
+		batch := clients.AddRequest{}
+		for i, chunk := range chunks[start:end] {
+			embedding := embeddings[i]
+
+			metadata := map[string]interface{}{
+				"type":        "document_chunk",
+				"document_id": doc.ID,
+				"title":       doc.Title,
+				"source":      doc.Source,
+				"source_type": doc.SourceType,
+				"format":      doc.Format,
+				"user_id":     doc.UserID,
+				"heading":     chunk.Heading,
+				"chunk_index": chunk.Index,
+				"chunk_count": len(chunks),
+				"timestamp":   now,
+				"superseded":  false,
+			}
+			for k, v := range doc.Extra {
+				metadata[k] = v
+			}
+
+			batch.IDs = append(batch.IDs, fmt.Sprintf("%s-%d", doc.ID, chunk.Index))
+			batch.Embeddings = append(batch.Embeddings, embedding)
+			batch.Documents = append(batch.Documents, chunk.Text)
+			batch.Metadatas = append(batch.Metadatas, metadata)
+		}
+
+		if err := s.upsertDocuments(ctx, batch); err != nil {
+			return fmt.Errorf("document storage failed: %w", err)
+		}
+	}
+
+	stale := map[string]interface{}{
+		"$and": []map[string]interface{}{
+			documentFilter(doc.ID),
+			{"chunk_index": map[string]interface{}{"$gte": len(chunks)}},
+		},
+	}
+	if err := s.deleteDocuments(ctx, nil, stale); err != nil {
+		return fmt.Errorf("failed to remove stale chunks: %w", err)
+	}
+
+	return nil
+}
+
+// ListDocuments returns one entry per stored document, optionally restricted
+// to a single user.
+func (s *RAGService) ListDocuments(ctx context.Context, userID string) ([]DocumentInfo, error) {
+	conditions := []map[string]interface{}{
+		{"type": "document_chunk"},
+		{"chunk_index": 0},
+	}
+	if userID != "" {
+		conditions = append(conditions, map[string]interface{}{"user_id": userID})
+	}
+
+	result, err := s.chromaClient.GetDocumentsWhere(ctx, s.activeCollection().Name(), map[string]interface{}{"$and": conditions}, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	documents := make([]DocumentInfo, 0, len(result.IDs))
+	for i := range result.IDs {
+		if i >= len(result.Metadatas) {
+			break
+		}
+		documents = append(documents, documentInfoFromMetadata(result.Metadatas[i]))
+	}
+	return documents, nil
+}
+
+// DeleteDocument removes every chunk of a document. It reports false when no
+// such document exists.
+func (s *RAGService) DeleteDocument(ctx context.Context, documentID string) (bool, error) {
+	existing, err := s.chromaClient.GetDocumentsWhere(ctx, s.activeCollection().Name(), documentFilter(documentID), 1, 0)
+	if err != nil {
+		return false, err
+	}
+	if len(existing.IDs) == 0 {
+		return false, nil
+	}
+
+	if err := s.deleteDocuments(ctx, nil, documentFilter(documentID)); err != nil {
+		return false, err
+	}
+	if err := s.watchedURLs.Remove(documentID); err != nil {
+		slog.WarnContext(ctx, "Failed to stop watching document", "document_id", documentID, logging.Err(err))
+	}
+	slog.InfoContext(ctx, "Deleted document", "document_id", documentID)
+	return true, nil
+}
+
+func documentFilter(documentID string) map[string]interface{} {
+	return map[string]interface{}{"document_id": documentID}
+}
+
+func documentInfoFromMetadata(metadata map[string]interface{}) DocumentInfo {
+	str := func(key string) string {
+		v, _ := metadata[key].(string)
+		return v
+	}
+	count, _ := metadata["chunk_count"].(float64)
+
+	return DocumentInfo{
+		ID:         str("document_id"),
+		Title:      str("title"),
+		Source:     str("source"),
+		SourceType: str("source_type"),
+		Format:     str("format"),
+		UserID:     str("user_id"),
+		Chunks:     int(count),
+		IngestedAt: str("timestamp"),
+	}
+}
+
+// contentDocumentID derives a document ID from the user and the raw content,
+// so uploading the same file twice replaces it instead of duplicating it.
+func contentDocumentID(userID string, content []byte) string {
+	hasher := sha256.New()
+	hasher.Write([]byte(userID))
+	hasher.Write([]byte{0})
+	hasher.Write(content)
+	return "doc-" + hex.EncodeToString(hasher.Sum(nil))[:24]
+}
+
+// documentTitle falls back to the first line of the content when no better
+// title is known.
+func documentTitle(content string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+	if runes := []rune(line); len(runes) > 80 {
+		line = string(runes[:80])
+	}
+	return strings.TrimSpace(line)
+}
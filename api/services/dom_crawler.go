@@ -2,34 +2,65 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
+
+	"iara-assistant/logging"
 )
 
 const (
-	DOMBaseURL     = "https://dom.mossoro.rn.gov.br/dom"
-	WebhookURL     = "https://iara.digzom.dev/webhook/f97912c2-a20c-45c5-9642-4e51d33bd7d9/selection-process"
 	RequestTimeout = 30 * time.Second
+	// LastDOMFile holds the number of the last publication notified, in
+	// the data directory.
+	LastDOMFile = "last_dom"
 )
 
-var targetKeywords = []string{
-	"convocação",
-	"processo seletivo",
-	"processo seletivo simplificado",
-	"Edital nº 01/2025 da Secretaria Municipal de Educação",
-	"Secretaria Municipal de Educação",
+// CrawlerConfig sets the DOM page to crawl, the keywords that make a
+// publication relevant and the webhook relevant publications are sent to.
+// The webhook URL carries its credentials and has no default; the crawler is
+// disabled until it is set.
+// MaxAge is how long the crawler may go without a successful run before it
+// is reported as degraded.
+type CrawlerConfig struct {
+	URL        string
+	WebhookURL string
+	Keywords   []string
+	Timeout    time.Duration
+	MaxAge     time.Duration
+}
+
+func DefaultCrawlerConfig() CrawlerConfig {
+	return CrawlerConfig{
+		URL: "https://dom.mossoro.rn.gov.br/dom",
+		Keywords: []string{
+			"convocação",
+			"processo seletivo",
+			"processo seletivo simplificado",
+			"Edital nº 01/2025 da Secretaria Municipal de Educação",
+			"Secretaria Municipal de Educação",
+		},
+		Timeout: RequestTimeout,
+		// It runs every two hours, but not overnight.
+		MaxAge: 12 * time.Hour,
+	}
 }
 
 type DOMCrawler struct {
-	httpClient *http.Client
+	cfg         CrawlerConfig
+	httpClient  *http.Client
+	lastDOMPath string
 }
 
 type WebhookPayload struct {
@@ -37,19 +68,65 @@ type WebhookPayload struct {
 	RawDoc string `json:"rawDoc"`
 }
 
-func NewDOMCrawler() *DOMCrawler {
+func NewDOMCrawler(cfg CrawlerConfig, dataDir string) *DOMCrawler {
+	lastDOMPath := LastDOMPath(dataDir)
+	if err := migrateLastDOM(lastDOMPath); err != nil {
+		slog.Warn("Failed to move the crawler state into the data directory", logging.Err(err))
+	}
 	return &DOMCrawler{
+		cfg: cfg,
 		httpClient: &http.Client{
-			Timeout: RequestTimeout,
+			Timeout: cfg.Timeout,
 		},
+		lastDOMPath: lastDOMPath,
+	}
+}
+
+// LastDOMPath returns where the crawler keeps its state in dataDir.
+func LastDOMPath(dataDir string) string {
+	return filepath.Join(dataDir, LastDOMFile)
+}
+
+// migrateLastDOM moves the crawler state that older versions kept in the
+// working directory to path, unless path already exists.
+func migrateLastDOM(path string) error {
+	legacy, err := os.ReadFile(LastDOMFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
 	}
+	if err := writeFileAtomic(path, legacy); err != nil {
+		return err
+	}
+	return os.Remove(LastDOMFile)
+}
+
+// ErrCrawlerDisabled is returned when the crawler is run without a webhook
+// URL to notify.
+var ErrCrawlerDisabled = errors.New("crawler is disabled: CRAWLER_WEBHOOK_URL is not set")
+
+// Enabled reports whether the crawler has a webhook to notify.
+func (c *DOMCrawler) Enabled() bool {
+	return c.cfg.WebhookURL != ""
 }
 
-func (c *DOMCrawler) CrawlDOM() error {
-	log.Println("Starting DOM crawl...")
+// ErrNoNewPublication is returned by CrawlDOM when the latest publication was
+// already handled.
+var ErrNoNewPublication = errors.New("no new publication since the last crawl")
+
+func (c *DOMCrawler) CrawlDOM(ctx context.Context) error {
+	if !c.Enabled() {
+		return ErrCrawlerDisabled
+	}
+	slog.InfoContext(ctx, "Starting DOM crawl")
 
 	// Step 1: Get the main DOM page
-	resp, err := c.httpClient.Get(DOMBaseURL)
+	resp, err := c.get(ctx, c.cfg.URL)
 	if err != nil {
 		return fmt.Errorf("failed to fetch DOM main page: %w", err)
 	}
@@ -86,7 +163,7 @@ func (c *DOMCrawler) CrawlDOM() error {
 	}
 
 	if intNumber <= intLastSavedNumber {
-		return fmt.Errorf("it's not an error, but no need to send the webhook now :)")
+		return ErrNoNewPublication
 	}
 
 	publicationLink, err := c.extractPublicationLink(doc)
@@ -94,43 +171,67 @@ func (c *DOMCrawler) CrawlDOM() error {
 		return fmt.Errorf("failed to extract publication link: %w", err)
 	}
 
-	log.Printf("Found publication link: %s", publicationLink)
+	slog.InfoContext(ctx, "Found publication link", "link", publicationLink)
 
 	// Step 3: Visit the publication page and check for keywords
-	fullPublicationURL := "https://dom.mossoro.rn.gov.br" + publicationLink
+	fullPublicationURL, err := c.resolveLink(publicationLink)
+	if err != nil {
+		return fmt.Errorf("failed to resolve publication link: %w", err)
+	}
 
-	hasKeywords, err := c.checkForKeywords(fullPublicationURL)
+	hasKeywords, err := c.checkForKeywords(ctx, fullPublicationURL)
 	if err != nil {
 		return fmt.Errorf("failed to check keywords: %w", err)
 	}
 
 	// Step 4: Send webhook notification if keywords found
 	if hasKeywords {
-		log.Printf("Keywords found! Sending webhook notification for: %s", fullPublicationURL)
-		if err := c.sendWebhook(fullPublicationURL, doc.Text()); err != nil {
+		slog.InfoContext(ctx, "Keywords found, sending webhook notification", "url", fullPublicationURL)
+		if err := c.sendWebhook(ctx, fullPublicationURL, doc.Text()); err != nil {
 			return fmt.Errorf("failed to send webhook: %w", err)
 		}
 
-		err = os.WriteFile("last_dom", []byte(number), 0644)
+		err = os.WriteFile(c.lastDOMPath, []byte(number), 0644)
 		if err != nil {
 			return fmt.Errorf("cant write shit")
 		}
 
-		log.Println("Webhook sent successfully")
+		slog.InfoContext(ctx, "Webhook sent")
 	} else {
-		log.Println("No target keywords found in this publication")
+		slog.InfoContext(ctx, "No target keywords found in this publication")
 	}
 
 	return nil
 }
 
+// resolveLink resolves a link found on the crawled page against its URL.
+func (c *DOMCrawler) resolveLink(link string) (string, error) {
+	base, err := url.Parse(c.cfg.URL)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+func (c *DOMCrawler) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.httpClient.Do(req)
+}
+
 func (c *DOMCrawler) getLastSavedNumber() (string, error) {
-	_, err := os.Stat("last_dom")
+	_, err := os.Stat(c.lastDOMPath)
 	if err != nil {
-		os.WriteFile("last_dom", []byte("0"), 0644)
+		os.WriteFile(c.lastDOMPath, []byte("0"), 0644)
 	}
 
-	content, err := os.ReadFile("last_dom")
+	content, err := os.ReadFile(c.lastDOMPath)
 	if err != nil {
 		return "", fmt.Errorf("sorry bro, cant open this shit")
 	}
@@ -174,10 +275,10 @@ func (c *DOMCrawler) extractPublicationLink(doc *goquery.Document) (string, erro
 	return publicationLink, nil
 }
 
-func (c *DOMCrawler) checkForKeywords(url string) (bool, error) {
-	log.Printf("Checking for keywords in: %s", url)
+func (c *DOMCrawler) checkForKeywords(ctx context.Context, url string) (bool, error) {
+	slog.DebugContext(ctx, "Checking for keywords", "url", url)
 
-	resp, err := c.httpClient.Get(url)
+	resp, err := c.get(ctx, url)
 	if err != nil {
 		return false, fmt.Errorf("failed to fetch publication page: %w", err)
 	}
@@ -196,10 +297,10 @@ func (c *DOMCrawler) checkForKeywords(url string) (bool, error) {
 	pageText := strings.ToLower(doc.Text())
 
 	// Check for each target keyword
-	for _, keyword := range targetKeywords {
+	for _, keyword := range c.cfg.Keywords {
 		normalizedKeyword := strings.ToLower(keyword)
 		if strings.Contains(pageText, normalizedKeyword) {
-			log.Printf("Found keyword: %s", keyword)
+			slog.InfoContext(ctx, "Found keyword", "keyword", keyword)
 			return true, nil
 		}
 	}
@@ -207,7 +308,7 @@ func (c *DOMCrawler) checkForKeywords(url string) (bool, error) {
 	return false, nil
 }
 
-func (c *DOMCrawler) sendWebhook(url string, doc string) error {
+func (c *DOMCrawler) sendWebhook(ctx context.Context, url string, doc string) error {
 	payload := WebhookPayload{
 		URL:    url,
 		RawDoc: doc,
@@ -218,7 +319,13 @@ func (c *DOMCrawler) sendWebhook(url string, doc string) error {
 		return fmt.Errorf("failed to marshal webhook payload: %w", err)
 	}
 
-	resp, err := c.httpClient.Post(WebhookURL, "application/json", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.WebhookURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send webhook request: %w", err)
 	}
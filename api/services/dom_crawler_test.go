@@ -0,0 +1,37 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDOMCrawlerResolveLink(t *testing.T) {
+	c := NewDOMCrawler(CrawlerConfig{URL: "https://dom.example.gov.br/dom"}, t.TempDir())
+	tests := []struct {
+		link string
+		want string
+	}{
+		{link: "/dom/edicao/123", want: "https://dom.example.gov.br/dom/edicao/123"},
+		{link: "edicao/123", want: "https://dom.example.gov.br/edicao/123"},
+		{link: "https://cdn.example.com/123.pdf", want: "https://cdn.example.com/123.pdf"},
+	}
+
+	for _, tt := range tests {
+		got, err := c.resolveLink(tt.link)
+		if err != nil {
+			t.Errorf("resolveLink(%q) err = %v", tt.link, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("resolveLink(%q) = %q, want %q", tt.link, got, tt.want)
+		}
+	}
+}
+
+func TestDOMCrawlerDisabledWithoutWebhook(t *testing.T) {
+	c := NewDOMCrawler(CrawlerConfig{URL: "https://dom.example.gov.br/dom"}, t.TempDir())
+	if err := c.CrawlDOM(context.Background()); !errors.Is(err, ErrCrawlerDisabled) {
+		t.Errorf("err = %v, want ErrCrawlerDisabled", err)
+	}
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/ledongthuc/pdf"
+)
+
+const (
+	FormatText     = "text"
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+	FormatPDF      = "pdf"
+)
+
+// documentSection is a run of text together with the headings it was found
+// under, outermost first.
+type documentSection struct {
+	Headings []string
+	Text     string
+}
+
+// DetectFormat guesses the document format from an explicit format name, the
+// file name and the content type, in that order of preference.
+func DetectFormat(format, filename, contentType string) (string, error) {
+	switch strings.ToLower(format) {
+	case "txt", "text", "plain":
+		return FormatText, nil
+	case "md", "markdown":
+		return FormatMarkdown, nil
+	case "htm", "html":
+		return FormatHTML, nil
+	case "pdf":
+		return FormatPDF, nil
+	case "":
+	default:
+		return "", fmt.Errorf("unsupported format %q", format)
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".txt", ".text":
+		return FormatText, nil
+	case ".md", ".markdown":
+		return FormatMarkdown, nil
+	case ".htm", ".html":
+		return FormatHTML, nil
+	case ".pdf":
+		return FormatPDF, nil
+	}
+
+	switch {
+	case strings.HasPrefix(contentType, "application/pdf"):
+		return FormatPDF, nil
+	case strings.HasPrefix(contentType, "text/html"):
+		return FormatHTML, nil
+	case strings.HasPrefix(contentType, "text/markdown"):
+		return FormatMarkdown, nil
+	}
+
+	return FormatText, nil
+}
+
+func extractSections(format string, content []byte) ([]documentSection, error) {
+	switch format {
+	case FormatMarkdown:
+		return markdownSections(string(content)), nil
+	case FormatHTML:
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse HTML: %w", err)
+		}
+		return htmlSections(doc.Selection), nil
+	case FormatPDF:
+		return pdfSections(content)
+	default:
+		return []documentSection{{Text: string(content)}}, nil
+	}
+}
+
+// markdownSections splits a Markdown document on ATX headings ("# Title"),
+// keeping track of the heading hierarchy.
+func markdownSections(content string) []documentSection {
+	var sections []documentSection
+	var headings []string
+	var body strings.Builder
+	inFence := false
+
+	flush := func() {
+		if text := strings.TrimSpace(body.String()); text != "" {
+			sections = append(sections, documentSection{
+				Headings: append([]string(nil), headings...),
+				Text:     text,
+			})
+		}
+		body.Reset()
+	}
+
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+		}
+
+		if level, title := markdownHeading(trimmed); !inFence && level > 0 {
+			flush()
+			if level > len(headings)+1 {
+				level = len(headings) + 1
+			}
+			headings = append(headings[:level-1], title)
+			continue
+		}
+
+		body.WriteString(line)
+		body.WriteString("\n")
+	}
+	flush()
+
+	return sections
+}
+
+func markdownHeading(line string) (int, string) {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 || level == len(line) || line[level] != ' ' {
+		return 0, ""
+	}
+	return level, strings.TrimSpace(strings.TrimRight(line[level:], "# "))
+}
+
+// htmlSections walks the block elements of an HTML tree in document order,
+// starting a new section at every h1-h6.
+func htmlSections(root *goquery.Selection) []documentSection {
+	root.Find("script, style, noscript, template, svg").Remove()
+
+	var sections []documentSection
+	var headings []string
+	var body strings.Builder
+
+	flush := func() {
+		if text := strings.TrimSpace(body.String()); text != "" {
+			sections = append(sections, documentSection{
+				Headings: append([]string(nil), headings...),
+				Text:     text,
+			})
+		}
+		body.Reset()
+	}
+
+	blocks := "p, li, pre, blockquote, td, th, dd, dt, figcaption"
+	root.Find("h1, h2, h3, h4, h5, h6, " + blocks).Each(func(i int, s *goquery.Selection) {
+		name := goquery.NodeName(s)
+		if len(name) == 2 && name[0] == 'h' && name[1] >= '1' && name[1] <= '6' {
+			flush()
+			level := int(name[1] - '0')
+			if level > len(headings)+1 {
+				level = len(headings) + 1
+			}
+			headings = append(headings[:level-1], collapseSpaces(s.Text()))
+			return
+		}
+
+		// Nested blocks (a <p> inside an <li>) are covered by their parent.
+		if s.ParentsFiltered(blocks).Length() > 0 {
+			return
+		}
+		if text := collapseSpaces(s.Text()); text != "" {
+			body.WriteString(text)
+			body.WriteString("\n\n")
+		}
+	})
+	flush()
+
+	// Pages that keep their text in bare <div>s have no block elements.
+	if len(sections) == 0 {
+		if text := collapseSpaces(root.Text()); text != "" {
+			sections = append(sections, documentSection{Text: text})
+		}
+	}
+
+	return sections
+}
+
+// pdfSections extracts the plain text of each page. PDFs carry no reliable
+// heading structure, so the page number is used instead.
+func pdfSections(content []byte) ([]documentSection, error) {
+	reader, err := pdf.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PDF: %w", err)
+	}
+
+	var sections []documentSection
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		text, err := page.GetPlainText(nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read PDF page %d: %w", i, err)
+		}
+		if text = strings.TrimSpace(text); text != "" {
+			sections = append(sections, documentSection{
+				Headings: []string{fmt.Sprintf("Page %d", i)},
+				Text:     text,
+			})
+		}
+	}
+
+	return sections, nil
+}
+
+func collapseSpaces(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      string
+		filename    string
+		contentType string
+		want        string
+		wantErr     bool
+	}{
+		{name: "explicit format wins", format: "md", filename: "page.html", contentType: "application/pdf", want: FormatMarkdown},
+		{name: "file extension", filename: "Relatório.PDF", contentType: "text/plain", want: FormatPDF},
+		{name: "content type", filename: "download", contentType: "text/html; charset=utf-8", want: FormatHTML},
+		{name: "plain text by default", filename: "notes", want: FormatText},
+		{name: "unsupported format", format: "docx", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DetectFormat(tt.format, tt.filename, tt.contentType)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DetectFormat() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DetectFormat() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractSections(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		content string
+		want    []documentSection
+	}{
+		{
+			name:    "markdown headings",
+			format:  FormatMarkdown,
+			content: "Introdução.\n\n# Casa\n\nEndereço.\n\n## Contas\n\nLuz e água.\n\n# Carro\n\nPlaca ABC1D23.",
+			want: []documentSection{
+				{Text: "Introdução."},
+				{Headings: []string{"Casa"}, Text: "Endereço."},
+				{Headings: []string{"Casa", "Contas"}, Text: "Luz e água."},
+				{Headings: []string{"Carro"}, Text: "Placa ABC1D23."},
+			},
+		},
+		{
+			name:    "markdown heading inside a code fence",
+			format:  FormatMarkdown,
+			content: "# Script\n\n```\n# comentário\n```",
+			want: []documentSection{
+				{Headings: []string{"Script"}, Text: "```\n# comentário\n```"},
+			},
+		},
+		{
+			name:    "skipped markdown heading level",
+			format:  FormatMarkdown,
+			content: "# Casa\n\n### Cozinha\n\nFogão novo.",
+			want: []documentSection{
+				{Headings: []string{"Casa", "Cozinha"}, Text: "Fogão novo."},
+			},
+		},
+		{
+			name:    "html blocks",
+			format:  FormatHTML,
+			content: "<html><body><script>ignored()</script><h1>Edital</h1><p>Inscrições  abertas.</p><ul><li><p>Até sexta.</p></li></ul></body></html>",
+			want: []documentSection{
+				{Headings: []string{"Edital"}, Text: "Inscrições abertas.\n\nAté sexta."},
+			},
+		},
+		{
+			name:    "html without blocks",
+			format:  FormatHTML,
+			content: "<div>Só um   texto.</div>",
+			want:    []documentSection{{Text: "Só um texto."}},
+		},
+		{
+			name:    "plain text",
+			format:  FormatText,
+			content: "Uma nota.",
+			want:    []documentSection{{Text: "Uma nota."}},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := extractSections(tt.format, []byte(tt.content))
+			if err != nil {
+				t.Fatalf("extractSections() err = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extractSections() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+	"time"
+
+	"iara-assistant/logging"
+)
+
+// DuplicateDistance is the maximum embedding distance for two facts to be
+// treated as the same fact worded differently.
+const DuplicateDistance = 0.05
+
+const (
+	LearnStatusNew       = "new"
+	LearnStatusDuplicate = "duplicate"
+	LearnStatusMerged    = "merged"
+)
+
+type similarFact struct {
+	ID           string
+	Text         string
+	LearnedAt    string
+	FirstLearned string
+	Distance     float32
+}
+
+// generateDocID derives a fact ID from the user and the normalized fact text,
+// so the same fact always maps to the same document.
+func generateDocID(userID, text string) string {
+	hasher := sha256.New()
+	hasher.Write([]byte(userID))
+	hasher.Write([]byte{0})
+	hasher.Write([]byte(normalizeFactText(text)))
+	return "fact-" + hex.EncodeToString(hasher.Sum(nil))[:32]
+}
+
+// normalizeFactText folds case, whitespace and trailing punctuation so that
+// trivially different spellings of a fact share an ID.
+func normalizeFactText(text string) string {
+	text = strings.ToLower(strings.Join(strings.Fields(text), " "))
+	return strings.TrimRight(text, ".!;, ")
+}
+
+// getStoredFact returns the metadata of the fact with the given ID, and
+// whether it is stored at all. Superseded facts are returned too.
+func (s *RAGService) getStoredFact(ctx context.Context, id string) (map[string]interface{}, bool, error) {
+	result, err := s.chromaClient.GetDocuments(ctx, s.activeCollection().Name(), []string{id})
+	if err != nil {
+		return nil, false, err
+	}
+	for i, foundID := range result.IDs {
+		if foundID != id {
+			continue
+		}
+		var metadata map[string]interface{}
+		if i < len(result.Metadatas) {
+			metadata = result.Metadatas[i]
+		}
+		return metadata, true, nil
+	}
+	return nil, false, nil
+}
+
+// touchFact records that an already known fact was confirmed again.
+func (s *RAGService) touchFact(ctx context.Context, id string) {
+	metadata := map[string]interface{}{
+		"last_confirmed": time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := s.updateMetadata(ctx, []string{id}, []map[string]interface{}{metadata}); err != nil {
+		slog.WarnContext(ctx, "Failed to update fact", "id", id, logging.Err(err))
+	}
+}
+
+// findSimilarFacts returns the active facts of the user that are close enough
+// to the given embedding to be duplicates of, or in conflict with, a new fact.
+// Results are ordered by increasing distance.
+func (s *RAGService) findSimilarFacts(ctx context.Context, newID, userID string, embedding []float32) ([]similarFact, error) {
+	where := map[string]interface{}{
+		"$and": []map[string]interface{}{
+			{"user_id": userID},
+			{"type": "fact"},
+			activeFactsFilter,
+		},
+	}
+
+	result, err := s.querySimilar(ctx, embedding, MaxConflictCandidates, where)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.IDs) == 0 {
+		return nil, nil
+	}
+
+	var similar []similarFact
+	for i, id := range result.IDs[0] {
+		if id == newID || i >= len(result.Documents[0]) {
+			continue
+		}
+		var distance float32
+		if len(result.Distances) > 0 && i < len(result.Distances[0]) {
+			distance = result.Distances[0][i]
+		}
+		if distance > ConflictCandidateDistance {
+			continue
+		}
+		metadata := resultMetadata(result, i)
+		if isSuperseded(metadata) {
+			continue
+		}
+		learnedAt, _ := metadata["timestamp"].(string)
+		firstLearned, _ := metadata["first_learned"].(string)
+		similar = append(similar, similarFact{
+			ID:           id,
+			Text:         result.Documents[0][i],
+			LearnedAt:    learnedAt,
+			FirstLearned: firstLearned,
+			Distance:     distance,
+		})
+	}
+	return similar, nil
+}
+
+// splitNearDuplicates separates facts that are just rewordings of the new
+// fact from facts that are merely related to it.
+func splitNearDuplicates(similar []similarFact) (nearDuplicates, related []similarFact) {
+	for _, f := range similar {
+		if f.Distance <= DuplicateDistance {
+			nearDuplicates = append(nearDuplicates, f)
+		} else {
+			related = append(related, f)
+		}
+	}
+	return nearDuplicates, related
+}
+
+// earliestLearnedAt keeps the original learning date when a fact is merged
+// into a new wording.
+func earliestLearnedAt(facts []similarFact, fallback string) string {
+	earliest := fallback
+	for _, f := range facts {
+		learned := f.FirstLearned
+		if learned == "" {
+			learned = f.LearnedAt
+		}
+		if learned != "" && learned < earliest {
+			earliest = learned
+		}
+	}
+	return earliest
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"iara-assistant/clients"
+	"iara-assistant/logging"
+)
+
+// Component states reported by readiness checks. A degraded component works
+// but needs attention; a down one makes the service unusable if it is
+// critical.
+const (
+	ComponentOK       = "ok"
+	ComponentDegraded = "degraded"
+	ComponentDown     = "down"
+)
+
+const (
+	// GeminiCheckInterval is how long a successful Gemini check is reused,
+	// since every check is an API call. Failures are re-checked sooner.
+	GeminiCheckInterval       = 5 * time.Minute
+	GeminiFailedCheckInterval = 30 * time.Second
+
+	healthProbeFile = ".health_probe"
+)
+
+// ComponentHealth is the result of checking one dependency.
+type ComponentHealth struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Critical  bool      `json:"critical"`
+	Message   string    `json:"message,omitempty"`
+	Breaker   string    `json:"breaker,omitempty"`
+	LatencyMS int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// checkComponent times check and turns its error into a down status. Errors
+// are redacted, since readiness is served without authentication and client
+// errors can carry request URLs with API keys.
+func checkComponent(name string, critical bool, check func() error) ComponentHealth {
+	start := time.Now()
+	err := check()
+	health := ComponentHealth{
+		Name:      name,
+		Status:    ComponentOK,
+		Critical:  critical,
+		LatencyMS: time.Since(start).Milliseconds(),
+		CheckedAt: start.UTC(),
+	}
+	if err != nil {
+		health.Status = ComponentDown
+		health.Message = logging.Redact(err.Error())
+	}
+	return health
+}
+
+// cachedCheck reuses the result of an expensive check for a while.
+type cachedCheck struct {
+	mu     sync.Mutex
+	result ComponentHealth
+	expiry time.Time
+	// checking is closed when the check in flight, if any, finishes.
+	checking chan struct{}
+}
+
+// get returns the cached result, running check once it has expired. The
+// check runs without holding mu: while it is in flight, other callers get
+// the previous result, or wait for the check if there is none yet.
+func (c *cachedCheck) get(ctx context.Context, check func() ComponentHealth) ComponentHealth {
+	c.mu.Lock()
+	if time.Now().Before(c.expiry) {
+		defer c.mu.Unlock()
+		return c.result
+	}
+	if checking := c.checking; checking != nil {
+		result := c.result
+		c.mu.Unlock()
+		if !result.CheckedAt.IsZero() {
+			return result
+		}
+		select {
+		case <-checking:
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.result
+		case <-ctx.Done():
+			// check runs with ctx, so it fails right away with the reason.
+			return check()
+		}
+	}
+	checking := make(chan struct{})
+	c.checking = checking
+	c.mu.Unlock()
+
+	result := check()
+	ttl := GeminiCheckInterval
+	if result.Status != ComponentOK {
+		ttl = GeminiFailedCheckInterval
+	}
+	c.mu.Lock()
+	c.result = result
+	c.expiry = time.Now().Add(ttl)
+	c.checking = nil
+	c.mu.Unlock()
+	close(checking)
+	return result
+}
+
+// CheckHealth checks ChromaDB, the active collection, Gemini and the data
+// directory concurrently.
+func (s *RAGService) CheckHealth(ctx context.Context) []ComponentHealth {
+	checks := []func() ComponentHealth{
+		func() ComponentHealth {
+			health := checkComponent("chromadb", true, func() error {
+				return s.chromaClient.Heartbeat(ctx)
+			})
+			health.Breaker = s.chromaClient.BreakerState()
+			return checkBreaker(health)
+		},
+		func() ComponentHealth { return s.checkCollection(ctx) },
+		func() ComponentHealth {
+			health := s.geminiCheck.get(ctx, func() ComponentHealth {
+				return checkComponent("gemini", false, func() error {
+					return s.googleClient.CheckModel(ctx, s.models.models.Models(TaskAnswer)[0])
+				})
+			})
+			// Without Gemini answers fail, but documents, sessions and the
+			// admin endpoints keep working, so it does not take readiness
+			// down.
+			if health.Status == ComponentDown {
+				health.Status = ComponentDegraded
+			}
+			health.Breaker = s.googleClient.BreakerState()
+			return checkBreaker(health)
+		},
+		func() ComponentHealth {
+			return checkComponent("storage", true, func() error {
+				path := filepath.Join(s.dataDir, healthProbeFile)
+				if err := os.WriteFile(path, []byte(time.Now().UTC().Format(time.RFC3339)), 0644); err != nil {
+					return fmt.Errorf("data directory is not writable: %w", err)
+				}
+				return os.Remove(path)
+			})
+		},
+	}
+
+	results := make([]ComponentHealth, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check func() ComponentHealth) {
+			defer wg.Done()
+			results[i] = check()
+		}(i, check)
+	}
+	wg.Wait()
+	return results
+}
+
+// checkCollection checks that the active collection exists. A collection
+// embedded with another model than the configured one is degraded, since
+// vector queries are refused until it is re-indexed.
+func (s *RAGService) checkCollection(ctx context.Context) ComponentHealth {
+	collection := s.activeCollection()
+	if collection == nil {
+		return ComponentHealth{
+			Name:      "collection",
+			Status:    ComponentDown,
+			Critical:  true,
+			Message:   "collection was not initialized",
+			CheckedAt: time.Now().UTC(),
+		}
+	}
+
+	health := checkComponent("collection", true, func() error {
+		_, err := s.chromaClient.GetCollection(ctx, collection.Name())
+		return err
+	})
+	if health.Status != ComponentOK {
+		return health
+	}
+	if model, _ := collection.embedding(); model != s.googleClient.EmbeddingModel() {
+		health.Status = ComponentDegraded
+		health.Message = fmt.Sprintf("collection %s was embedded with %s but the configured model is %s; re-index it", collection.Name(), model, s.googleClient.EmbeddingModel())
+	} else {
+		health.Message = collection.Name()
+	}
+	return health
+}
+
+// checkBreaker reports a client whose circuit breaker is open as degraded.
+func checkBreaker(health ComponentHealth) ComponentHealth {
+	if health.Status == ComponentOK && health.Breaker == clients.BreakerOpen {
+		health.Status = ComponentDegraded
+		health.Message = "circuit breaker is open"
+	}
+	return health
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCachedCheckDoesNotBlockDuringCheck(t *testing.T) {
+	var c cachedCheck
+	ok := func() ComponentHealth {
+		return ComponentHealth{Name: "gemini", Status: ComponentOK, CheckedAt: time.Now()}
+	}
+	c.get(context.Background(), ok)
+	c.expiry = time.Time{}
+
+	release := make(chan struct{})
+	done := make(chan ComponentHealth)
+	go func() {
+		done <- c.get(context.Background(), func() ComponentHealth {
+			<-release
+			return ComponentHealth{Name: "gemini", Status: ComponentDegraded, CheckedAt: time.Now()}
+		})
+	}()
+
+	// Wait for the slow check to start.
+	for {
+		c.mu.Lock()
+		started := c.checking != nil
+		c.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	got := c.get(context.Background(), func() ComponentHealth {
+		t.Error("a second check ran while one was in flight")
+		return ComponentHealth{}
+	})
+	if got.Status != ComponentOK {
+		t.Errorf("status during the check = %q, want the previous %q", got.Status, ComponentOK)
+	}
+
+	close(release)
+	if got := <-done; got.Status != ComponentDegraded {
+		t.Errorf("status = %q, want %q", got.Status, ComponentDegraded)
+	}
+	if got := c.get(context.Background(), ok); got.Status != ComponentDegraded {
+		t.Errorf("cached status = %q, want %q", got.Status, ComponentDegraded)
+	}
+}
+
+func TestCachedCheckWaiterGivesUp(t *testing.T) {
+	var c cachedCheck
+	release := make(chan struct{})
+	defer close(release)
+	go c.get(context.Background(), func() ComponentHealth {
+		<-release
+		return ComponentHealth{Status: ComponentOK, CheckedAt: time.Now()}
+	})
+	for {
+		c.mu.Lock()
+		started := c.checking != nil
+		c.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	got := c.get(ctx, func() ComponentHealth {
+		return checkComponent("gemini", false, ctx.Err)
+	})
+	if got.Status != ComponentDown || got.Name != "gemini" {
+		t.Errorf("got = %+v, want gemini down", got)
+	}
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	LanguagePortuguese = "pt-BR"
+	LanguageEnglish    = "en"
+	LanguageSpanish    = "es"
+
+	// languageProfileSize is how many of the most frequent trigrams make up
+	// a language profile.
+	languageProfileSize = 300
+	// minDetectionLetters is the shortest text, in letters, worth running
+	// the detector on. Greetings like "oi" or "ok" say nothing reliable.
+	minDetectionLetters = 12
+	// minDetectionMargin is how much closer, relative to the runner-up, the
+	// best profile has to be before its language is trusted.
+	minDetectionMargin = 0.03
+)
+
+// Language profiles are built from these samples, written in the register
+// users chat with the assistant: questions about their own notes, plans and
+// errands.
+var languageSamples = map[string]string{
+	LanguagePortuguese: `Você lembra qual é a placa do meu carro? Preciso renovar o licenciamento
+antes do fim do mês e não encontro o documento. Ontem eu fui ao mercado com a minha mãe e depois
+passamos na farmácia para comprar os remédios dela. Quando é a próxima consulta no dentista?
+A reunião do trabalho foi remarcada para quinta-feira às três horas da tarde, então não vou
+conseguir buscar as crianças na escola. Minha irmã mora em Natal e vem nos visitar no feriado.
+O edital do concurso saiu e as inscrições vão até o dia vinte. Quanto eu paguei na conta de luz
+no mês passado? Anota aí que a senha do wifi da casa nova é diferente. Não esqueça de me lembrar
+de pagar o aluguel. Estou pensando em viajar nas férias, mas ainda não decidi para onde. Também
+quero saber se já enviei o relatório para o chefe e se ele respondeu. Obrigado pela ajuda, você é
+muito útil. Como está o tempo hoje em Mossoró? Ela disse que não podia vir porque estava doente.`,
+	LanguageEnglish: `Do you remember what my car plate number is? I need to renew the registration
+before the end of the month and I cannot find the document. Yesterday I went to the grocery store
+with my mother and then we stopped at the pharmacy to buy her medicine. When is my next dentist
+appointment? The work meeting was moved to Thursday at three in the afternoon, so I will not be able
+to pick up the kids from school. My sister lives in another city and is visiting us for the holiday.
+The job posting came out and applications are open until the twentieth. How much did I pay for the
+electricity bill last month? Write down that the wifi password at the new house is different. Do not
+forget to remind me to pay the rent. I am thinking about traveling on vacation, but I have not decided
+where yet. I also want to know whether I already sent the report to my boss and if he answered. Thanks
+for the help, you are very useful. What is the weather like today? She said she could not come because
+she was sick.`,
+	LanguageSpanish: `¿Recuerdas cuál es la matrícula de mi coche? Necesito renovar el permiso antes
+del fin de mes y no encuentro el documento. Ayer fui al supermercado con mi madre y después pasamos
+por la farmacia para comprar sus medicinas. ¿Cuándo es la próxima cita con el dentista? La reunión
+del trabajo se cambió para el jueves a las tres de la tarde, así que no voy a poder recoger a los niños
+en la escuela. Mi hermana vive en otra ciudad y viene a visitarnos en el feriado. Salió la convocatoria
+del concurso y las inscripciones están abiertas hasta el día veinte. ¿Cuánto pagué en la factura de la
+luz el mes pasado? Apunta que la contraseña del wifi de la casa nueva es diferente. No olvides
+recordarme que pague el alquiler. Estoy pensando en viajar en las vacaciones, pero todavía no he
+decidido adónde. También quiero saber si ya envié el informe al jefe y si él respondió. Gracias por la
+ayuda, eres muy útil. ¿Qué tiempo hace hoy? Ella dijo que no podía venir porque estaba enferma.`,
+}
+
+type languageProfile struct {
+	language string
+	ranks    map[string]int
+}
+
+var languageProfiles = buildLanguageProfiles()
+
+func buildLanguageProfiles() []languageProfile {
+	profiles := make([]languageProfile, 0, len(languageSamples))
+	for language, sample := range languageSamples {
+		profiles = append(profiles, languageProfile{
+			language: language,
+			ranks:    trigramRanks(sample, languageProfileSize),
+		})
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].language < profiles[j].language
+	})
+	return profiles
+}
+
+// DetectLanguage guesses the language of a text by comparing its character
+// trigrams with those of each language profile (Cavnar & Trenkle's
+// out-of-place measure). It returns "" when the text is too short or too
+// ambiguous to tell.
+func DetectLanguage(text string) string {
+	letters := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+		}
+	}
+	if letters < minDetectionLetters {
+		return ""
+	}
+
+	ranks := trigramRanks(text, languageProfileSize)
+	best, second := "", -1
+	bestDistance := -1
+	for _, profile := range languageProfiles {
+		distance := 0
+		for trigram, rank := range ranks {
+			profileRank, ok := profile.ranks[trigram]
+			if !ok {
+				distance += languageProfileSize
+				continue
+			}
+			if rank > profileRank {
+				distance += rank - profileRank
+			} else {
+				distance += profileRank - rank
+			}
+		}
+		switch {
+		case bestDistance < 0 || distance < bestDistance:
+			second = bestDistance
+			best, bestDistance = profile.language, distance
+		case second < 0 || distance < second:
+			second = distance
+		}
+	}
+
+	if second <= 0 || float64(second-bestDistance)/float64(second) < minDetectionMargin {
+		return ""
+	}
+	return best
+}
+
+// trigramRanks returns the rank of the most frequent character trigrams of a
+// text. Words are padded with spaces so that prefixes and suffixes, which
+// carry most of the signal, get their own trigrams.
+func trigramRanks(text string, limit int) map[string]int {
+	counts := make(map[string]int)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		runes := []rune(" " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			counts[string(runes[i:i+3])]++
+		}
+	}
+
+	trigrams := make([]string, 0, len(counts))
+	for trigram := range counts {
+		trigrams = append(trigrams, trigram)
+	}
+	sort.Slice(trigrams, func(i, j int) bool {
+		if counts[trigrams[i]] != counts[trigrams[j]] {
+			return counts[trigrams[i]] > counts[trigrams[j]]
+		}
+		return trigrams[i] < trigrams[j]
+	})
+	if len(trigrams) > limit {
+		trigrams = trigrams[:limit]
+	}
+
+	ranks := make(map[string]int, len(trigrams))
+	for i, trigram := range trigrams {
+		ranks[trigram] = i
+	}
+	return ranks
+}
@@ -0,0 +1,51 @@
+package services
+
+import "testing"
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "Qual é a placa do meu carro?", want: LanguagePortuguese},
+		{text: "Quando vence o aluguel do apartamento?", want: LanguagePortuguese},
+		{text: "Você sabe onde deixei as chaves?", want: LanguagePortuguese},
+		{text: "What is my car's plate number?", want: LanguageEnglish},
+		{text: "When is the dentist appointment?", want: LanguageEnglish},
+		{text: "Where did I leave the keys?", want: LanguageEnglish},
+		{text: "¿Cuál es la matrícula de mi coche?", want: LanguageSpanish},
+		{text: "¿Cuándo es la cita con el dentista?", want: LanguageSpanish},
+		{text: "¿Dónde dejé las llaves ayer?", want: LanguageSpanish},
+		{text: "oi", want: ""},
+		{text: "ok, thanks", want: ""},
+		{text: "ABC-1D23 01/2025", want: ""},
+		{text: "hotel taxi radio sofa", want: ""},
+		{text: "Brasil Argentina Canada", want: ""},
+		{text: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := DetectLanguage(tt.text); got != tt.want {
+			t.Errorf("DetectLanguage(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestResponseLanguageFallsBackToPortuguese(t *testing.T) {
+	s := &RAGService{personas: NewPersonaStore(t.TempDir())}
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "oi", want: DefaultPersonaLanguage},
+		{text: "ok", want: DefaultPersonaLanguage},
+		{text: "hotel taxi radio sofa", want: DefaultPersonaLanguage},
+		{text: "Where did I leave the keys?", want: LanguageEnglish},
+	}
+
+	for _, tt := range tests {
+		if got := s.ResponseLanguage("alice", tt.text); got != tt.want {
+			t.Errorf("ResponseLanguage(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
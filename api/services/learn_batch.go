@@ -0,0 +1,332 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"iara-assistant/clients"
+	"iara-assistant/logging"
+)
+
+const (
+	// LearnBatchConcurrency is how many embedding requests a bulk learn
+	// keeps in flight at once.
+	LearnBatchConcurrency = 4
+	// MaxLearnBatchItems bounds the number of facts in one bulk learn.
+	MaxLearnBatchItems = 1000
+
+	LearnStatusFailed = "failed"
+)
+
+type LearnBatchItemResult struct {
+	Index      int              `json:"index"`
+	ID         string           `json:"id,omitempty"`
+	Status     string           `json:"status"`
+	Error      string           `json:"error,omitempty"`
+	Superseded []SupersededFact `json:"superseded,omitempty"`
+}
+
+type LearnBatchResponse struct {
+	Success    bool                   `json:"success"`
+	Message    string                 `json:"message"`
+	Learned    int                    `json:"learned"`
+	Merged     int                    `json:"merged"`
+	Duplicates int                    `json:"duplicates"`
+	Failed     int                    `json:"failed"`
+	Results    []LearnBatchItemResult `json:"results"`
+}
+
+type pendingFact struct {
+	index     int
+	id        string
+	request   LearnRequest
+	embedding []float32
+	// revived is set for a superseded fact learned again, and successor to
+	// the fact that replaced it.
+	revived   bool
+	successor *similarFact
+	similar   []similarFact
+}
+
+// LearnFacts learns many facts at once. Facts already known are confirmed,
+// new ones are embedded in concurrent batches and stored with a single
+// upsert, and rewordings of known facts are merged as in LearnFact. Rewordings
+// of a fact earlier in the same batch count as duplicates of it. Conflict
+// detection needs a model call per fact and is left to single learns.
+// Every item gets its own result so partial failures are visible.
+func (s *RAGService) LearnFacts(ctx context.Context, requests []LearnRequest) (*LearnBatchResponse, error) {
+	if len(requests) > MaxLearnBatchItems {
+		return nil, fmt.Errorf("too many facts: %d, the limit is %d", len(requests), MaxLearnBatchItems)
+	}
+
+	results := make([]LearnBatchItemResult, len(requests))
+	var pending []*pendingFact
+	seen := make(map[string]bool)
+	for i, req := range requests {
+		results[i].Index = i
+		if strings.TrimSpace(req.Text) == "" {
+			results[i].Status = LearnStatusFailed
+			results[i].Error = localize(s.ResponseLanguage(req.UserID, req.Text), msgEmptyFact)
+			continue
+		}
+		id := generateDocID(req.UserID, req.Text)
+		results[i].ID = id
+		if seen[id] {
+			results[i].Status = LearnStatusDuplicate
+			continue
+		}
+		seen[id] = true
+		pending = append(pending, &pendingFact{index: i, id: id, request: req})
+	}
+
+	pending = s.confirmKnownFacts(ctx, pending, results)
+	pending = s.embedPendingFacts(ctx, pending, results)
+	pending = dropBatchDuplicates(pending, results)
+	s.findSimilarPendingFacts(ctx, pending)
+
+	if len(pending) > 0 {
+		merges := make(map[string][]similarFact)
+		now := time.Now().UTC().Format(time.RFC3339)
+		batch := clients.AddRequest{}
+		for _, fact := range pending {
+			similar := fact.similar
+			if fact.successor != nil {
+				similar = withoutFact(similar, fact.successor.ID)
+			}
+			nearDuplicates, _ := splitNearDuplicates(similar)
+			merges[fact.id] = nearDuplicates
+
+			metadata := map[string]interface{}{
+				"timestamp":     now,
+				"first_learned": earliestLearnedAt(nearDuplicates, now),
+				"user_id":       fact.request.UserID,
+				"type":          "fact",
+				"superseded":    false,
+			}
+			if fact.revived {
+				reviveMetadata(metadata)
+			}
+			batch.IDs = append(batch.IDs, fact.id)
+			batch.Embeddings = append(batch.Embeddings, fact.embedding)
+			batch.Documents = append(batch.Documents, fact.request.Text)
+			batch.Metadatas = append(batch.Metadatas, metadata)
+		}
+
+		slog.InfoContext(ctx, "Storing facts", "count", len(batch.IDs))
+		if err := s.upsertDocuments(ctx, batch); err != nil {
+			slog.ErrorContext(ctx, "Failed to store facts", logging.Err(err))
+			for _, fact := range pending {
+				results[fact.index].Status = LearnStatusFailed
+				results[fact.index].Error = localize(s.ResponseLanguage(fact.request.UserID, fact.request.Text), msgStoreFactFailed)
+			}
+		} else {
+			for _, fact := range pending {
+				result := &results[fact.index]
+				result.Status = LearnStatusNew
+				if nearDuplicates := merges[fact.id]; len(nearDuplicates) > 0 {
+					result.Status = LearnStatusMerged
+					result.Superseded = s.markSuperseded(ctx, fact.id, nearDuplicates)
+				}
+				if fact.successor != nil {
+					result.Superseded = append(result.Superseded, s.markSuperseded(ctx, fact.id, []similarFact{*fact.successor})...)
+				}
+			}
+		}
+	}
+
+	response := &LearnBatchResponse{Results: results}
+	for _, result := range results {
+		switch result.Status {
+		case LearnStatusNew:
+			response.Learned++
+		case LearnStatusMerged:
+			response.Merged++
+		case LearnStatusDuplicate:
+			response.Duplicates++
+		default:
+			response.Failed++
+		}
+	}
+	response.Success = response.Failed < len(results)
+	response.Message = fmt.Sprintf("Learned %d new fact(s), merged %d, %d already known, %d failed.",
+		response.Learned, response.Merged, response.Duplicates, response.Failed)
+	return response, nil
+}
+
+// confirmKnownFacts marks the facts that are already stored as duplicates,
+// touching them in one update, and returns the ones still to be learned.
+func (s *RAGService) confirmKnownFacts(ctx context.Context, pending []*pendingFact, results []LearnBatchItemResult) []*pendingFact {
+	if len(pending) == 0 {
+		return pending
+	}
+
+	ids := make([]string, len(pending))
+	for i, fact := range pending {
+		ids[i] = fact.id
+	}
+	existing, err := s.chromaClient.GetDocuments(ctx, s.activeCollection().Name(), ids)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to look up existing facts", logging.Err(err))
+		return pending
+	}
+
+	known := make(map[string]bool)
+	superseded := make(map[string]map[string]interface{})
+	for i, id := range existing.IDs {
+		if i < len(existing.Metadatas) && isSuperseded(existing.Metadatas[i]) {
+			superseded[id] = existing.Metadatas[i]
+			continue
+		}
+		known[id] = true
+	}
+
+	// A superseded fact learned again supersedes the fact that replaced it,
+	// as in LearnFact.
+	for _, fact := range pending {
+		metadata, ok := superseded[fact.id]
+		if !ok {
+			continue
+		}
+		fact.revived = true
+		successor, err := s.currentSuccessor(ctx, fact.id, metadata)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to look up the replacing fact", "id", fact.id, logging.Err(err))
+		}
+		fact.successor = successor
+	}
+	if len(known) == 0 {
+		return pending
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	var touched []string
+	var metadatas []map[string]interface{}
+	remaining := pending[:0]
+	for _, fact := range pending {
+		if !known[fact.id] {
+			remaining = append(remaining, fact)
+			continue
+		}
+		results[fact.index].Status = LearnStatusDuplicate
+		touched = append(touched, fact.id)
+		metadatas = append(metadatas, map[string]interface{}{"last_confirmed": now})
+	}
+	if err := s.updateMetadata(ctx, touched, metadatas); err != nil {
+		slog.WarnContext(ctx, "Failed to update known facts", logging.Err(err))
+	}
+	return remaining
+}
+
+// embedPendingFacts embeds the facts in batches, at most
+// LearnBatchConcurrency at a time. Facts whose batch fails are marked failed
+// and left out of the returned list.
+func (s *RAGService) embedPendingFacts(ctx context.Context, pending []*pendingFact, results []LearnBatchItemResult) []*pendingFact {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sem := make(chan struct{}, LearnBatchConcurrency)
+	failed := make(map[int]bool)
+
+	for start := 0; start < len(pending); start += clients.MaxBatchEmbedSize {
+		batch := pending[start:min(start+clients.MaxBatchEmbedSize, len(pending))]
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(batch []*pendingFact) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			texts := make([]string, len(batch))
+			for i, fact := range batch {
+				texts[i] = fact.request.Text
+			}
+			embeddings, err := s.embedBatch(ctx, texts)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				slog.ErrorContext(ctx, "Embedding generation failed", "count", len(batch), logging.Err(err))
+				for _, fact := range batch {
+					failed[fact.index] = true
+					results[fact.index].Status = LearnStatusFailed
+					results[fact.index].Error = localize(s.ResponseLanguage(fact.request.UserID, fact.request.Text), msgEmbeddingFailed)
+				}
+				return
+			}
+			for i, fact := range batch {
+				fact.embedding = embeddings[i]
+			}
+		}(batch)
+	}
+	wg.Wait()
+
+	remaining := pending[:0]
+	for _, fact := range pending {
+		if !failed[fact.index] {
+			remaining = append(remaining, fact)
+		}
+	}
+	return remaining
+}
+
+// dropBatchDuplicates marks the facts that are rewordings of a fact of the
+// same user earlier in the batch as duplicates, and returns the rest. Facts
+// are only compared with the ones kept so far.
+func dropBatchDuplicates(pending []*pendingFact, results []LearnBatchItemResult) []*pendingFact {
+	var kept []*pendingFact
+	for _, fact := range pending {
+		duplicate := false
+		for _, other := range kept {
+			if other.request.UserID == fact.request.UserID && embeddingDistance(other.embedding, fact.embedding) <= DuplicateDistance {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			results[fact.index].Status = LearnStatusDuplicate
+			continue
+		}
+		kept = append(kept, fact)
+	}
+	return kept
+}
+
+// findSimilarPendingFacts looks up the stored facts similar to each pending
+// fact, at most LearnBatchConcurrency at a time. A failed lookup is logged
+// and leaves the fact without similar facts.
+func (s *RAGService) findSimilarPendingFacts(ctx context.Context, pending []*pendingFact) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, LearnBatchConcurrency)
+	for _, fact := range pending {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(fact *pendingFact) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			similar, err := s.findSimilarFacts(ctx, fact.id, fact.request.UserID, fact.embedding)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to look up similar facts", logging.Err(err))
+			}
+			fact.similar = similar
+		}(fact)
+	}
+	wg.Wait()
+}
+
+// embeddingDistance measures two embeddings the way the collection does,
+// with ChromaDB's default squared L2 distance, so DuplicateDistance means the
+// same within a batch as against stored facts.
+func embeddingDistance(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return float32(math.Inf(1))
+	}
+	var sum float32
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return sum
+}
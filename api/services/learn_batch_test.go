@@ -0,0 +1,26 @@
+package services
+
+import "testing"
+
+func TestDropBatchDuplicates(t *testing.T) {
+	pending := []*pendingFact{
+		{index: 0, request: LearnRequest{UserID: "alice"}, embedding: []float32{1, 0}},
+		{index: 1, request: LearnRequest{UserID: "alice"}, embedding: []float32{0.99, 0.01}},
+		{index: 2, request: LearnRequest{UserID: "bob"}, embedding: []float32{1, 0}},
+		{index: 3, request: LearnRequest{UserID: "alice"}, embedding: []float32{0, 1}},
+	}
+	results := make([]LearnBatchItemResult, len(pending))
+
+	kept := dropBatchDuplicates(pending, results)
+
+	var keptIndexes []int
+	for _, fact := range kept {
+		keptIndexes = append(keptIndexes, fact.index)
+	}
+	if len(keptIndexes) != 3 || keptIndexes[0] != 0 || keptIndexes[1] != 2 || keptIndexes[2] != 3 {
+		t.Errorf("kept = %v, want [0 2 3]", keptIndexes)
+	}
+	if results[1].Status != LearnStatusDuplicate {
+		t.Errorf("status of the rewording = %q, want %q", results[1].Status, LearnStatusDuplicate)
+	}
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"iara-assistant/clients"
+)
+
+// Keys of the canned messages returned to users.
+const (
+	msgEmptyMessage    = "empty_message"
+	msgProcessFailed   = "process_failed"
+	msgGenerateFailed  = "generate_failed"
+	msgEmptyFact       = "empty_fact"
+	msgEmbeddingFailed = "embedding_failed"
+	msgStoreFactFailed = "store_fact_failed"
+	msgFactLearned     = "fact_learned"
+	msgFactDuplicate   = "fact_duplicate"
+	msgFactMerged      = "fact_merged"
+	msgFactsSuperseded = "facts_superseded"
+	msgRateLimited     = "rate_limited"
+	msgQuotaExceeded   = "quota_exceeded"
+	msgTimedOut        = "timed_out"
+)
+
+// messageCatalog holds the canned messages by base language. Portuguese is
+// the fallback, since that is what most users speak.
+var messageCatalog = map[string]map[string]string{
+	"pt": {
+		msgEmptyMessage:    "A mensagem não pode estar vazia.",
+		msgProcessFailed:   "Não consegui processar sua mensagem. Tente de novo em instantes.",
+		msgGenerateFailed:  "Não consegui gerar uma resposta agora. Tente de novo em instantes.",
+		msgEmptyFact:       "O texto não pode estar vazio.",
+		msgEmbeddingFailed: "Não consegui processar esse fato. Tente de novo em instantes.",
+		msgStoreFactFailed: "Não consegui guardar esse fato. Tente de novo em instantes.",
+		msgFactLearned:     "Fato aprendido!",
+		msgFactDuplicate:   "Eu já sabia disso!",
+		msgFactMerged:      "Fato combinado com o que eu já sabia.",
+		msgFactsSuperseded: "Ele substitui %d fato(s) mais antigo(s).",
+		msgRateLimited:     "Muitas mensagens. Tente de novo em %d segundo(s).",
+		msgQuotaExceeded:   "Você atingiu o limite diário de mensagens. Tente de novo amanhã.",
+		msgTimedOut:        "Demorei demais para responder. Tente de novo em instantes.",
+	},
+	"en": {
+		msgEmptyMessage:    "Message cannot be empty.",
+		msgProcessFailed:   "I could not process your message. Please try again in a moment.",
+		msgGenerateFailed:  "I could not generate an answer right now. Please try again in a moment.",
+		msgEmptyFact:       "Text cannot be empty.",
+		msgEmbeddingFailed: "I could not process that fact. Please try again in a moment.",
+		msgStoreFactFailed: "I could not store that fact. Please try again in a moment.",
+		msgFactLearned:     "Fact learned successfully!",
+		msgFactDuplicate:   "I already knew that!",
+		msgFactMerged:      "Fact merged with what I already knew.",
+		msgFactsSuperseded: "It replaces %d older fact(s).",
+		msgRateLimited:     "Too many messages. Please try again in %d second(s).",
+		msgQuotaExceeded:   "You reached your daily message limit. Please try again tomorrow.",
+		msgTimedOut:        "I took too long to answer. Please try again in a moment.",
+	},
+	"es": {
+		msgEmptyMessage:    "El mensaje no puede estar vacío.",
+		msgProcessFailed:   "No pude procesar tu mensaje. Inténtalo de nuevo en un momento.",
+		msgGenerateFailed:  "No pude generar una respuesta ahora. Inténtalo de nuevo en un momento.",
+		msgEmptyFact:       "El texto no puede estar vacío.",
+		msgEmbeddingFailed: "No pude procesar ese dato. Inténtalo de nuevo en un momento.",
+		msgStoreFactFailed: "No pude guardar ese dato. Inténtalo de nuevo en un momento.",
+		msgFactLearned:     "¡Dato aprendido!",
+		msgFactDuplicate:   "¡Eso ya lo sabía!",
+		msgFactMerged:      "Dato combinado con lo que ya sabía.",
+		msgFactsSuperseded: "Reemplaza %d dato(s) más antiguo(s).",
+		msgRateLimited:     "Demasiados mensajes. Inténtalo de nuevo en %d segundo(s).",
+		msgQuotaExceeded:   "Alcanzaste tu límite diario de mensajes. Inténtalo de nuevo mañana.",
+		msgTimedOut:        "Tardé demasiado en responder. Inténtalo de nuevo en un momento.",
+	},
+}
+
+// localize returns the canned message for key in the given language, falling
+// back to Portuguese for languages without a translation.
+func localize(language, key string, args ...interface{}) string {
+	base := strings.ToLower(language)
+	if i := strings.IndexByte(base, '-'); i >= 0 {
+		base = base[:i]
+	}
+	messages, ok := messageCatalog[base]
+	if !ok {
+		messages = messageCatalog["pt"]
+	}
+	message, ok := messages[key]
+	if !ok {
+		message = messageCatalog["pt"][key]
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(message, args...)
+	}
+	return message
+}
+
+// RateLimitedMessage asks a user to wait before sending another message.
+func RateLimitedMessage(language string, wait time.Duration) string {
+	return localize(language, msgRateLimited, int(math.Max(1, math.Ceil(wait.Seconds()))))
+}
+
+// FailureMessage explains to a user why their message was not answered.
+func FailureMessage(language string, err error) string {
+	switch {
+	case errors.Is(err, ErrQuotaExceeded):
+		return localize(language, msgQuotaExceeded)
+	case errors.Is(err, context.DeadlineExceeded):
+		return localize(language, msgTimedOut)
+	case errors.Is(err, clients.ErrRateLimited), errors.Is(err, clients.ErrUnavailable), errors.Is(err, clients.ErrBlocked):
+		return localize(language, msgGenerateFailed)
+	default:
+		return localize(language, msgProcessFailed)
+	}
+}
+
+// ResponseLanguage decides which language to answer a user in: their stored
+// preference, else the detected language of the text, else the default.
+func (s *RAGService) ResponseLanguage(userID, text string) string {
+	if preferred := s.personas.Language(userID); preferred != "" {
+		return preferred
+	}
+	if detected := DetectLanguage(text); detected != "" {
+		return detected
+	}
+	return DefaultPersonaLanguage
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"iara-assistant/clients"
+	"iara-assistant/logging"
+	"iara-assistant/metrics"
+)
+
+// Model tasks. Each task has its own ordered list of models so cheap models
+// can handle the bookkeeping calls while answers use a stronger one.
+const (
+	TaskAnswer    = "answer"
+	TaskRerank    = "rerank"
+	TaskClassify  = "classify"
+	TaskSummarize = "summarize"
+)
+
+// ModelConfig names the models used for each task. Generation tasks list
+// models in order of preference; the next one is tried when a model fails or
+// blocks the response. Changing Embedding makes existing vectors
+// incomparable with new ones, so it stays on the model the collection was
+// built with by default.
+type ModelConfig struct {
+	Embedding string
+	Answer    []string
+	Rerank    []string
+	Classify  []string
+	Summarize []string
+}
+
+func DefaultModelConfig() ModelConfig {
+	cheap := []string{"gemini-2.5-flash-lite", "gemini-2.0-flash-lite"}
+	return ModelConfig{
+		Embedding: clients.DefaultEmbeddingModel,
+		Answer:    []string{"gemini-2.5-flash", "gemini-2.0-flash"},
+		Rerank:    cheap,
+		Classify:  cheap,
+		Summarize: cheap,
+	}
+}
+
+// Models returns the fallback list for a task. Unknown tasks use the answer
+// models.
+func (c ModelConfig) Models(task string) []string {
+	var models []string
+	switch task {
+	case TaskRerank:
+		models = c.Rerank
+	case TaskClassify:
+		models = c.Classify
+	case TaskSummarize:
+		models = c.Summarize
+	}
+	if len(models) == 0 {
+		models = c.Answer
+	}
+	return models
+}
+
+type generationClient interface {
+	Generate(ctx context.Context, opts clients.GenerationOptions) (*clients.Generation, error)
+}
+
+// ModelRouter sends each generation to the models configured for its task,
+// falling back down the list when a model returns an error or a blocked
+// response. Every attempt is charged to the usage subjects of its context.
+type ModelRouter struct {
+	client generationClient
+	models ModelConfig
+	usage  *UsageTracker
+}
+
+func NewModelRouter(client generationClient, models ModelConfig, usage *UsageTracker) *ModelRouter {
+	return &ModelRouter{client: client, models: models, usage: usage}
+}
+
+// Generate returns the generated text and the model that produced it. When
+// every model fails, the errors of all attempts are joined. A used up quota
+// fails before any model is called.
+func (r *ModelRouter) Generate(ctx context.Context, task, system, prompt string, maxOutputTokens int) (string, string, error) {
+	models := r.models.Models(task)
+	if len(models) == 0 {
+		return "", "", fmt.Errorf("no models configured for %s", task)
+	}
+	if err := r.usage.CheckQuota(ctx); err != nil {
+		return "", "", err
+	}
+
+	var errs []error
+	for i, model := range models {
+		generation, err := r.client.Generate(ctx, clients.GenerationOptions{
+			Model:           model,
+			System:          system,
+			Prompt:          prompt,
+			MaxOutputTokens: maxOutputTokens,
+		})
+		tokens := 0
+		if generation != nil {
+			tokens = generation.TotalTokens
+		}
+		r.usage.RecordLLMCall(ctx, tokens)
+		metrics.LLMTokens.WithLabelValues(task, model).Add(float64(tokens))
+		metrics.LLMCalls.WithLabelValues(task, model, llmOutcome(err)).Inc()
+		if err == nil {
+			return generation.Text, model, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", model, err))
+		if ctx.Err() != nil {
+			break
+		}
+		if i < len(models)-1 {
+			slog.WarnContext(ctx, "Model failed, falling back",
+				"task", task,
+				"model", model,
+				"fallback", models[i+1],
+				logging.Err(err))
+		}
+	}
+	return "", "", errors.Join(errs...)
+}
+
+// GenerateText runs a task with no system instruction and the default
+// output limit.
+func (r *ModelRouter) GenerateText(ctx context.Context, task, prompt string) (string, error) {
+	text, _, err := r.Generate(ctx, task, "", prompt, 0)
+	return text, err
+}
+
+func llmOutcome(err error) string {
+	if errors.Is(err, clients.ErrBlocked) {
+		return metrics.OutcomeBlocked
+	}
+	return metrics.Outcome(err)
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"iara-assistant/logging"
+)
+
+const (
+	personasFile = "personas.json"
+
+	DefaultPersonaName     = "Iara"
+	DefaultPersonaTone     = "amigável, direto e prestativo"
+	DefaultPersonaLanguage = "pt-BR"
+)
+
+// Persona is how the assistant presents itself to a user. Empty fields fall
+// back to the defaults, except Language: when a user did not choose one, the
+// language of each message is detected.
+type Persona struct {
+	Name     string `json:"name,omitempty"`
+	Tone     string `json:"tone,omitempty"`
+	Language string `json:"language,omitempty"`
+}
+
+func DefaultPersona() Persona {
+	return Persona{
+		Name:     DefaultPersonaName,
+		Tone:     DefaultPersonaTone,
+		Language: DefaultPersonaLanguage,
+	}
+}
+
+// withDefaults fills the fields a user did not override.
+func (p Persona) withDefaults() Persona {
+	def := DefaultPersona()
+	if strings.TrimSpace(p.Name) == "" {
+		p.Name = def.Name
+	}
+	if strings.TrimSpace(p.Tone) == "" {
+		p.Tone = def.Tone
+	}
+	if strings.TrimSpace(p.Language) == "" {
+		p.Language = def.Language
+	}
+	return p
+}
+
+// PersonaStore keeps per-user persona overrides, persisted as JSON in the
+// data directory.
+type PersonaStore struct {
+	mu       sync.Mutex
+	path     string
+	personas map[string]Persona
+}
+
+func NewPersonaStore(dataDir string) *PersonaStore {
+	store := &PersonaStore{
+		path:     filepath.Join(dataDir, personasFile),
+		personas: make(map[string]Persona),
+	}
+	if err := readJSONFile(store.path, &store.personas); err != nil {
+		slog.Warn("Failed to load personas", logging.Err(err))
+	}
+	return store
+}
+
+// Get returns the effective persona of a user.
+func (s *PersonaStore) Get(userID string) Persona {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.personas[userID].withDefaults()
+}
+
+// Language returns the language a user explicitly chose, or "" when they
+// did not and it should be detected from their messages.
+func (s *PersonaStore) Language(userID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.personas[userID].Language
+}
+
+// Put stores the overrides of a user. An empty persona removes them.
+func (s *PersonaStore) Put(userID string, persona Persona) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if persona == (Persona{}) {
+		delete(s.personas, userID)
+	} else {
+		s.personas[userID] = persona
+	}
+	return writeJSONFile(s.path, s.personas)
+}
+
+// snapshot returns a copy of every stored persona override.
+func (s *PersonaStore) snapshot() map[string]Persona {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	personas := make(map[string]Persona, len(s.personas))
+	for userID, persona := range s.personas {
+		personas[userID] = persona
+	}
+	return personas
+}
+
+// restore stores the given overrides over the users' current ones, or
+// instead of all current overrides when replace is set.
+func (s *PersonaStore) restore(personas map[string]Persona, replace bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if replace {
+		s.personas = make(map[string]Persona, len(personas))
+	}
+	for userID, persona := range personas {
+		s.personas[userID] = persona
+	}
+	return writeJSONFile(s.path, s.personas)
+}
+
+// languageName names a language code in Portuguese, the language the prompt
+// templates are written in.
+func languageName(code string) string {
+	switch strings.ToLower(code) {
+	case "pt", "pt-br":
+		return "português do Brasil"
+	case "pt-pt":
+		return "português de Portugal"
+	case "en", "en-us", "en-gb":
+		return "inglês"
+	case "es":
+		return "espanhol"
+	case "fr":
+		return "francês"
+	case "it":
+		return "italiano"
+	case "de":
+		return "alemão"
+	default:
+		return code
+	}
+}
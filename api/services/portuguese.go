@@ -0,0 +1,278 @@
+package services
+
+import (
+	"strings"
+	"unicode"
+)
+
+// suffixRule replaces Suffix with Replacement when at least MinStem
+// characters are left in front of it.
+type suffixRule struct {
+	Suffix      string
+	MinStem     int
+	Replacement string
+	Exceptions  []string
+}
+
+// The rules below are a trimmed down version of the RSLP stemmer (Orengo &
+// Huyck, 2001), applied in the same step order. Within a step the first
+// matching rule wins, so longer suffixes come first.
+var (
+	pluralRules = []suffixRule{
+		{"ns", 1, "m", nil},
+		{"ões", 3, "ão", nil},
+		{"ães", 1, "ão", []string{"mães"}},
+		{"ais", 1, "al", []string{"cais", "mais"}},
+		{"éis", 2, "el", nil},
+		{"eis", 2, "el", nil},
+		{"óis", 2, "ol", nil},
+		{"is", 2, "il", []string{"lápis", "cais", "mais", "crúcis", "biquínis", "pois", "depois", "dois", "leis"}},
+		{"les", 3, "l", nil},
+		{"res", 3, "r", nil},
+		{"s", 2, "", []string{"aliás", "pires", "lápis", "cais", "mais", "mas", "menos", "férias", "fezes", "pêsames", "crúcis", "gás", "atrás", "moisés", "através", "convés", "ês", "país", "após", "ambas", "ambos", "messias"}},
+	}
+	feminineRules = []suffixRule{
+		{"ona", 3, "ão", nil},
+		{"ã", 2, "ão", nil},
+		{"ora", 3, "or", nil},
+		{"inha", 3, "inho", nil},
+		{"esa", 3, "ês", nil},
+		{"osa", 3, "oso", nil},
+		{"íaca", 3, "íaco", nil},
+		{"ica", 3, "ico", nil},
+		{"ada", 2, "ado", nil},
+		{"ida", 3, "ido", nil},
+		{"ída", 3, "ido", nil},
+		{"ima", 3, "imo", nil},
+		{"iva", 3, "ivo", nil},
+		{"eira", 3, "eiro", nil},
+		{"na", 4, "no", nil},
+	}
+	adverbRules = []suffixRule{
+		{"mente", 4, "", nil},
+	}
+	augmentativeRules = []suffixRule{
+		{"abilíssimo", 5, "", nil},
+		{"díssimo", 5, "", nil},
+		{"íssimo", 3, "", nil},
+		{"ésimo", 3, "", nil},
+		{"érrimo", 4, "", nil},
+		{"zinho", 2, "", nil},
+		{"quinho", 4, "c", nil},
+		{"uinho", 4, "", nil},
+		{"adinho", 3, "", nil},
+		{"inho", 3, "", nil},
+		{"alhão", 4, "", nil},
+		{"zarrão", 3, "", nil},
+		{"arrão", 4, "", nil},
+		{"adão", 4, "", nil},
+		{"idão", 4, "", nil},
+		{"zão", 2, "", nil},
+		{"ão", 3, "", nil},
+		{"zito", 2, "", nil},
+		{"ito", 3, "", nil},
+	}
+	nounRules = []suffixRule{
+		{"encialista", 4, "", nil},
+		{"alista", 5, "", nil},
+		{"iamento", 4, "", nil},
+		{"amento", 3, "", nil},
+		{"imento", 3, "", nil},
+		{"alizado", 4, "", nil},
+		{"atizado", 4, "", nil},
+		{"izado", 5, "", nil},
+		{"ativo", 4, "", nil},
+		{"tivo", 4, "", nil},
+		{"ivo", 4, "", nil},
+		{"ação", 3, "", nil},
+		{"ição", 3, "", nil},
+		{"ção", 3, "", nil},
+		{"ência", 3, "", nil},
+		{"ância", 3, "", nil},
+		{"idade", 4, "", nil},
+		{"ável", 2, "", nil},
+		{"ível", 2, "", nil},
+		{"agem", 3, "", nil},
+		{"ador", 3, "", nil},
+		{"edor", 3, "", nil},
+		{"idor", 4, "", nil},
+		{"ário", 3, "", nil},
+		{"ária", 3, "", nil},
+		{"eria", 4, "", nil},
+		{"ismo", 3, "", nil},
+		{"ista", 4, "", nil},
+		{"ante", 2, "", nil},
+		{"eza", 3, "", nil},
+		{"oso", 3, "", nil},
+		{"ado", 2, "", nil},
+		{"ido", 3, "", nil},
+		{"ico", 4, "", nil},
+		{"dor", 4, "", nil},
+		{"tor", 3, "", nil},
+		{"al", 4, "", nil},
+		{"ez", 4, "", nil},
+	}
+	verbRules = []suffixRule{
+		{"aríamos", 2, "", nil},
+		{"eríamos", 3, "", nil},
+		{"iríamos", 3, "", nil},
+		{"ássemos", 2, "", nil},
+		{"aremos", 2, "", nil},
+		{"eremos", 2, "", nil},
+		{"iremos", 3, "", nil},
+		{"ávamos", 2, "", nil},
+		{"ariam", 2, "", nil},
+		{"eriam", 3, "", nil},
+		{"iriam", 3, "", nil},
+		{"assem", 2, "", nil},
+		{"essem", 3, "", nil},
+		{"issem", 3, "", nil},
+		{"aram", 2, "", nil},
+		{"eram", 3, "", nil},
+		{"iram", 3, "", nil},
+		{"avam", 2, "", nil},
+		{"arem", 2, "", nil},
+		{"erem", 3, "", nil},
+		{"irem", 3, "", nil},
+		{"amos", 2, "", nil},
+		{"emos", 2, "", nil},
+		{"imos", 3, "", nil},
+		{"ando", 2, "", nil},
+		{"endo", 3, "", nil},
+		{"indo", 3, "", nil},
+		{"ava", 2, "", nil},
+		{"ar", 2, "", nil},
+		{"er", 2, "", nil},
+		{"ir", 3, "", nil},
+		{"ou", 2, "", nil},
+		{"iu", 3, "", nil},
+		{"eu", 3, "", nil},
+		{"am", 2, "", nil},
+		{"em", 2, "", nil},
+	}
+	vowelRules = []suffixRule{
+		{"a", 3, "", nil},
+		{"e", 3, "", nil},
+		{"o", 3, "", nil},
+	}
+)
+
+var stopwords = toSet(
+	// Portuguese
+	"a", "à", "ao", "aos", "aquela", "aquelas", "aquele", "aqueles", "aquilo", "as", "às", "até", "com", "como",
+	"da", "das", "de", "dela", "delas", "dele", "deles", "depois", "do", "dos", "e", "é", "ela", "elas", "ele",
+	"eles", "em", "entre", "era", "eram", "essa", "essas", "esse", "esses", "esta", "está", "estas", "este",
+	"estes", "eu", "foi", "foram", "há", "isso", "isto", "já", "lhe", "lhes", "mais", "mas", "me", "mesmo",
+	"meu", "meus", "minha", "minhas", "muito", "na", "nas", "não", "nem", "no", "nos", "nós", "nossa",
+	"nossas", "nosso", "nossos", "num", "numa", "o", "os", "ou", "para", "pela", "pelas", "pelo", "pelos",
+	"por", "qual", "quando", "que", "quem", "se", "sem", "ser", "seu", "seus", "só", "sua", "suas", "também",
+	"te", "tem", "têm", "tu", "tua", "tuas", "teu", "teus", "um", "uma", "umas", "uns", "você", "vocês", "vos",
+	"sido", "sobre", "quais", "onde", "quanto", "quantos", "quanta", "quantas",
+	// English
+	"an", "and", "are", "at", "be", "by", "for", "from", "how", "i", "in", "is", "it", "my", "of", "on",
+	"or", "the", "to", "was", "what", "when", "where", "which", "who", "with",
+)
+
+func toSet(words ...string) map[string]bool {
+	set := make(map[string]bool, len(words))
+	for _, w := range words {
+		set[w] = true
+	}
+	return set
+}
+
+// tokenize splits text into lowercase search terms. Words are stemmed and
+// folded to ASCII; tokens containing digits (plates, PSI values, edital
+// numbers) are kept verbatim, and compound codes such as "01/2025" or
+// "ABC-1D23" are indexed both whole and in parts.
+func tokenize(text string) []string {
+	var tokens []string
+	for _, field := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '/' && r != '-' && r != '.' && r != ','
+	}) {
+		field = strings.Trim(field, "/-.,")
+		if field == "" {
+			continue
+		}
+
+		parts := strings.FieldsFunc(field, func(r rune) bool {
+			return r == '/' || r == '-' || r == '.' || r == ','
+		})
+		if len(parts) > 1 && strings.IndexFunc(field, unicode.IsDigit) >= 0 {
+			tokens = append(tokens, field)
+		}
+
+		for _, part := range parts {
+			if stopwords[part] {
+				continue
+			}
+			if strings.IndexFunc(part, unicode.IsDigit) >= 0 {
+				tokens = append(tokens, part)
+				continue
+			}
+			if stem := stemPortuguese(part); stem != "" {
+				tokens = append(tokens, stem)
+			}
+		}
+	}
+	return tokens
+}
+
+// stemPortuguese reduces a lowercase word to its stem without accents.
+func stemPortuguese(word string) string {
+	if len([]rune(word)) < 3 {
+		return foldAccents(word)
+	}
+
+	if strings.HasSuffix(word, "s") {
+		word, _ = applyRules(word, pluralRules)
+	}
+	if strings.HasSuffix(word, "a") || strings.HasSuffix(word, "ã") {
+		word, _ = applyRules(word, feminineRules)
+	}
+	word, _ = applyRules(word, adverbRules)
+	word, _ = applyRules(word, augmentativeRules)
+
+	var changed bool
+	word, changed = applyRules(word, nounRules)
+	if !changed {
+		word, changed = applyRules(word, verbRules)
+	}
+	if !changed {
+		word, _ = applyRules(word, vowelRules)
+	}
+
+	return foldAccents(word)
+}
+
+func applyRules(word string, rules []suffixRule) (string, bool) {
+	for _, rule := range rules {
+		if !strings.HasSuffix(word, rule.Suffix) {
+			continue
+		}
+		stem := strings.TrimSuffix(word, rule.Suffix)
+		if len([]rune(stem)) < rule.MinStem {
+			continue
+		}
+		for _, exception := range rule.Exceptions {
+			if word == exception {
+				return word, false
+			}
+		}
+		return stem + rule.Replacement, true
+	}
+	return word, false
+}
+
+var accentReplacer = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ã", "a", "ä", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "õ", "o", "ö", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u",
+	"ç", "c", "ñ", "n",
+)
+
+func foldAccents(word string) string {
+	return accentReplacer.Replace(word)
+}
@@ -0,0 +1,56 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStemPortugueseConflatesForms(t *testing.T) {
+	tests := []struct {
+		name  string
+		words []string
+	}{
+		{name: "plural", words: []string{"carro", "carros"}},
+		{name: "plural in -ais", words: []string{"nacional", "nacionais"}},
+		{name: "plural in -éis", words: []string{"papel", "papéis"}},
+		{name: "gender and plural", words: []string{"médico", "médica", "médicos", "médicas"}},
+		{name: "feminine in -ora", words: []string{"professor", "professora", "professores", "professoras"}},
+		{name: "accented gender and plural", words: []string{"funcionária", "funcionários"}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			want := stemPortuguese(tt.words[0])
+			for _, word := range tt.words[1:] {
+				if got := stemPortuguese(word); got != want {
+					t.Errorf("stem of %q = %q, want %q like %q", word, got, want, tt.words[0])
+				}
+			}
+		})
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{name: "stopwords dropped", text: "O carro da Maria", want: []string{"carr", "mari"}},
+		{name: "english stopwords dropped", text: "the plate of the car", want: []string{"plat", "car"}},
+		{name: "plate kept whole and in parts", text: "Placa ABC-1D23", want: []string{"plac", "abc-1d23", "abc", "1d23"}},
+		{name: "edital number", text: "edital 01/2025.", want: []string{"edit", "01/2025", "01", "2025"}},
+		{name: "hyphenated words are not kept whole", text: "guarda-chuva", want: []string{"guard", "chuv"}},
+		{name: "only stopwords", text: "e o que é isso?", want: nil},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
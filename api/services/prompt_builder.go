@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"iara-assistant/logging"
+)
+
+// MinTruncatedDocTokens is the smallest slice of a context document worth
+// including when the whole document does not fit.
+const MinTruncatedDocTokens = 100
+
+// PromptConfig sets the token budget of generation requests. MaxInputTokens
+// is shared by instructions, the question, retrieved context and
+// conversation history; HistoryShare is the part of what is left after the
+// instructions and question that history may claim before context.
+type PromptConfig struct {
+	MaxInputTokens   int
+	MaxOutputTokens  int
+	HistoryShare     float64
+	HistoryTurns     int
+	SummarizeHistory bool
+	TokenCounter     string
+	// TemplateDir holds prompt templates overriding the built-in ones.
+	TemplateDir string
+	// GenerationTimeout bounds each call to the generation model.
+	GenerationTimeout time.Duration
+}
+
+func DefaultPromptConfig() PromptConfig {
+	return PromptConfig{
+		MaxInputTokens:    8000,
+		MaxOutputTokens:   1024,
+		HistoryShare:      0.3,
+		HistoryTurns:      10,
+		SummarizeHistory:  true,
+		TokenCounter:      TokenCounterEstimate,
+		GenerationTimeout: 25 * time.Second,
+	}
+}
+
+// promptParts are the pieces a prompt is assembled from. Template names the
+// prompt template they are rendered with and UserID the session History
+// comes from.
+type promptParts struct {
+	Template string
+	UserID   string
+	Persona  Persona
+	Question string
+	Context  []string
+	History  []ChatTurn
+}
+
+// assembledPrompt is a prompt ready to be sent: the system instruction, the
+// user prompt and how the token budget was spent on them.
+type assembledPrompt struct {
+	Persona   Persona
+	System    string
+	Prompt    string
+	Breakdown PromptBreakdown
+}
+
+// PromptBreakdown reports how the token budget of a prompt was spent.
+type PromptBreakdown struct {
+	Budget            int  `json:"budget"`
+	MaxOutputTokens   int  `json:"max_output_tokens"`
+	Instructions      int  `json:"instructions"`
+	Question          int  `json:"question"`
+	Context           int  `json:"context"`
+	History           int  `json:"history"`
+	Total             int  `json:"total"`
+	ContextDocs       int  `json:"context_docs"`
+	ContextTruncated  int  `json:"context_truncated,omitempty"`
+	ContextDropped    int  `json:"context_dropped,omitempty"`
+	HistoryTurns      int  `json:"history_turns"`
+	HistoryDropped    int  `json:"history_dropped,omitempty"`
+	HistorySummarized bool `json:"history_summarized,omitempty"`
+	QuestionTruncated bool `json:"question_truncated,omitempty"`
+}
+
+// assemblePrompt packs the parts into the configured input budget. Context
+// documents are taken in ranking order and the last one that does not fit is
+// truncated; history is packed newest first and older turns that overflow
+// are summarized (or dropped when summarizing is disabled or fails).
+func (s *RAGService) assemblePrompt(ctx context.Context, parts promptParts) (*assembledPrompt, error) {
+	cfg := s.prompts
+	count := func(text string) int { return s.tokenCounter.CountTokens(ctx, text) }
+	b := PromptBreakdown{
+		Budget:          cfg.MaxInputTokens,
+		MaxOutputTokens: cfg.MaxOutputTokens,
+	}
+
+	system, err := s.templates.Render(TemplateSystem, PromptData{Persona: parts.Persona})
+	if err != nil {
+		return nil, err
+	}
+	// The instructions are whatever the template renders around an empty
+	// question, context and history.
+	instructions, err := s.templates.Render(parts.Template, PromptData{Persona: parts.Persona})
+	if err != nil {
+		return nil, err
+	}
+
+	b.Instructions = count(system) + count(instructions)
+	question := parts.Question
+	b.Question = count(question)
+	if cfg.MaxInputTokens > 0 && b.Instructions+b.Question > cfg.MaxInputTokens {
+		question, b.Question = fitToTokens(ctx, s.tokenCounter, question, cfg.MaxInputTokens-b.Instructions)
+		b.QuestionTruncated = true
+	}
+
+	available := cfg.MaxInputTokens - b.Instructions - b.Question
+	if cfg.MaxInputTokens <= 0 {
+		available = int(^uint(0) >> 1)
+	}
+	if available < 0 {
+		available = 0
+	}
+
+	turnTexts := make([]string, len(parts.History))
+	for i, turn := range parts.History {
+		turnTexts[i] = turn.Text
+	}
+	turnTokens := s.tokenCounter.CountTokensBatch(ctx, turnTexts)
+	historyTokens := 0
+	for i := range turnTokens {
+		turnTokens[i] += 2
+		historyTokens += turnTokens[i]
+	}
+	historyBudget := min(historyTokens, int(float64(available)*cfg.HistoryShare))
+
+	// Context first, then hand whatever it leaves unused back to history.
+	contextBudget := available - historyBudget
+	var contextDocs []string
+	docTokens := s.tokenCounter.CountTokensBatch(ctx, parts.Context)
+	for i, doc := range parts.Context {
+		tokens := docTokens[i]
+		remaining := contextBudget - b.Context
+		if tokens > remaining && remaining >= MinTruncatedDocTokens {
+			if doc, tokens = fitToTokens(ctx, s.tokenCounter, doc, remaining); doc != "" {
+				b.ContextTruncated++
+			}
+		}
+		if doc == "" || tokens > remaining {
+			b.ContextDropped++
+			continue
+		}
+		contextDocs = append(contextDocs, doc)
+		b.Context += tokens
+	}
+	b.ContextDocs = len(contextDocs)
+	historyBudget = min(historyTokens, available-b.Context)
+
+	// Newest turns first, so the conversation right before the question is
+	// always kept.
+	kept := len(parts.History)
+	for kept > 0 && b.History+turnTokens[kept-1] <= historyBudget {
+		kept--
+		b.History += turnTokens[kept]
+	}
+	history := parts.History[kept:]
+	overflow := parts.History[:kept]
+	b.HistoryTurns = len(history)
+
+	var summary string
+	if len(overflow) > 0 {
+		var summaryTokens int
+		if cfg.SummarizeHistory && historyBudget-b.History >= MinTruncatedDocTokens {
+			summary, summaryTokens = s.summarizeHistory(ctx, parts.UserID, overflow, parts.Persona, historyBudget-b.History)
+		}
+		if summary != "" {
+			b.History += summaryTokens
+			b.HistorySummarized = true
+		} else {
+			b.HistoryDropped = len(overflow)
+		}
+	}
+
+	prompt, err := s.templates.Render(parts.Template, PromptData{
+		Persona:  parts.Persona,
+		Question: question,
+		Context:  contextDocs,
+		History:  history,
+		Summary:  summary,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b.Total = b.Instructions + b.Question + b.Context + b.History
+	slog.DebugContext(ctx, "Prompt assembled",
+		"tokens", b.Total,
+		"budget", b.Budget,
+		"instructions", b.Instructions,
+		"question_tokens", b.Question,
+		"context_tokens", b.Context,
+		"context_docs", b.ContextDocs,
+		"history_tokens", b.History,
+		"history_turns", b.HistoryTurns)
+
+	return &assembledPrompt{Persona: parts.Persona, System: system, Prompt: prompt, Breakdown: b}, nil
+}
+
+// summarizeHistory condenses conversation turns that no longer fit in the
+// prompt into a short summary of at most maxTokens, and returns it with its
+// token count. The summary is kept per session: turns it already covers are
+// not summarized again, and it is reused as is until more turns overflow.
+func (s *RAGService) summarizeHistory(ctx context.Context, userID string, turns []ChatTurn, persona Persona, maxTokens int) (string, int) {
+	var previous string
+	if cached, ok := s.sessions.summary(userID); ok {
+		if covered := cached.covers(turns); covered >= 0 {
+			if covered == len(turns)-1 {
+				if cached.tokens <= maxTokens {
+					return cached.text, cached.tokens
+				}
+				return fitToTokens(ctx, s.tokenCounter, cached.text, maxTokens)
+			}
+			previous = cached.text
+			turns = turns[covered+1:]
+		}
+	}
+
+	prompt, err := s.templates.Render(TemplateSummary, PromptData{
+		Persona:  persona,
+		History:  turns,
+		Summary:  previous,
+		MaxWords: maxTokens * 3 / 4,
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to summarize history", logging.Err(err))
+		return "", 0
+	}
+
+	ctx, cancel := withStageTimeout(ctx, s.prompts.GenerationTimeout)
+	defer cancel()
+	summary, _, err := s.models.Generate(ctx, TaskSummarize, "", prompt, maxTokens)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to summarize history", logging.Err(err))
+		return "", 0
+	}
+	text, tokens := fitToTokens(ctx, s.tokenCounter, strings.TrimSpace(summary), maxTokens)
+	if text != "" {
+		s.sessions.setSummary(userID, historySummary{through: turns[len(turns)-1], text: text, tokens: tokens})
+	}
+	return text, tokens
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"iara-assistant/clients"
+)
+
+// summarizingClient answers every generation with a numbered summary and
+// records the prompts it was sent.
+type summarizingClient struct {
+	prompts []string
+}
+
+func (c *summarizingClient) Generate(ctx context.Context, opts clients.GenerationOptions) (*clients.Generation, error) {
+	c.prompts = append(c.prompts, opts.Prompt)
+	return &clients.Generation{Text: fmt.Sprintf("summary %d", len(c.prompts))}, nil
+}
+
+func TestSummarizeHistoryReusesSummary(t *testing.T) {
+	client := &summarizingClient{}
+	s := &RAGService{
+		models:       NewModelRouter(client, DefaultModelConfig(), nil),
+		tokenCounter: estimatingCounter{},
+		templates:    NewPromptTemplates(""),
+		sessions:     NewSessionStore(t.TempDir(), 10),
+		prompts:      DefaultPromptConfig(),
+	}
+	persona := Persona{Name: "Iara"}
+	start := time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+	var turns []ChatTurn
+	for i := 0; i < 6; i++ {
+		role := RoleUser
+		if i%2 == 1 {
+			role = RoleAssistant
+		}
+		turns = append(turns, ChatTurn{Role: role, Text: fmt.Sprintf("turn %d", i), Time: start.Add(time.Duration(i) * time.Minute)})
+	}
+
+	summarize := func(overflow []ChatTurn) string {
+		summary, _ := s.summarizeHistory(context.Background(), "alice", overflow, persona, 200)
+		return summary
+	}
+
+	if got := summarize(turns[:2]); got != "summary 1" {
+		t.Fatalf("first summary = %q, want %q", got, "summary 1")
+	}
+	if got := summarize(turns[:2]); got != "summary 1" || len(client.prompts) != 1 {
+		t.Errorf("same overflow: summary = %q after %d calls, want %q after 1", got, len(client.prompts), "summary 1")
+	}
+
+	// The window moved: turn 0 left the session and turns 2 and 3 overflowed.
+	if got := summarize(turns[1:4]); got != "summary 2" {
+		t.Fatalf("grown overflow: summary = %q, want %q", got, "summary 2")
+	}
+	prompt := client.prompts[1]
+	if !strings.Contains(prompt, "summary 1") || strings.Contains(prompt, "turn 1") {
+		t.Errorf("prompt = %q, want the previous summary and only the new turns", prompt)
+	}
+	for _, want := range []string{"User: turn 2", "Iara: turn 3"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt = %q, want it to contain %q", prompt, want)
+		}
+	}
+
+	// A summary of other turns, as after a restore, is not reused.
+	if got := summarize(turns[4:]); got != "summary 3" {
+		t.Errorf("unrelated overflow: summary = %q, want %q", got, "summary 3")
+	}
+}
@@ -1,14 +1,16 @@
 package services
 
 import (
-	"crypto/md5"
-	"encoding/hex"
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"iara-assistant/clients"
+	"iara-assistant/logging"
 )
 
 const (
@@ -18,7 +20,33 @@ const (
 
 type RAGService struct {
 	googleClient *clients.GoogleAIClient
+	models       *ModelRouter
 	chromaClient *clients.ChromaDBClient
+	httpClient   *http.Client
+	watchedURLs  *URLWatchList
+	keywordIndex *BM25Index
+	reranker     Reranker
+	retrieval    RetrievalConfig
+	tokenCounter TokenCounter
+	prompts      PromptConfig
+	templates    *PromptTemplates
+	personas     *PersonaStore
+	sessions     *SessionStore
+	embeddings   *EmbeddingCache
+	answers      *AnswerCache
+	usage        *UsageTracker
+	dataDir      string
+	geminiCheck  cachedCheck
+
+	// writeMu is held for reading by every write to the collection, and for
+	// writing while the keyword index is loaded.
+	writeMu sync.RWMutex
+
+	collectionMu  sync.RWMutex
+	collection    *vectorCollection
+	reindexTarget *vectorCollection
+	reindexMu     sync.Mutex
+	reindexJobs   sync.WaitGroup
 }
 
 type LearnRequest struct {
@@ -29,164 +57,378 @@ type LearnRequest struct {
 type MessageRequest struct {
 	Text   string `json:"text"`
 	UserID string `json:"user_id,omitempty"`
+	Debug  bool   `json:"debug,omitempty"`
 }
 
 type Response struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
-	Error   string `json:"error,omitempty"`
+	Success    bool             `json:"success"`
+	Message    string           `json:"message"`
+	Error      string           `json:"error,omitempty"`
+	Status     string           `json:"status,omitempty"`
+	Language   string           `json:"language,omitempty"`
+	Model      string           `json:"model,omitempty"`
+	Superseded []SupersededFact `json:"superseded,omitempty"`
+	Debug      *DebugInfo       `json:"debug,omitempty"`
 }
 
-func NewRAGService(googleAPIKey, chromaDBURL string) *RAGService {
+// RAGConfig gathers what NewRAGService needs. DataDir holds the state files.
+type RAGConfig struct {
+	DataDir   string
+	Gemini    clients.GoogleAIConfig
+	ChromaDB  clients.ChromaDBConfig
+	Retrieval RetrievalConfig
+	Prompts   PromptConfig
+	Caches    CacheConfig
+	Models    ModelConfig
+	Quotas    QuotaConfig
+}
+
+// NewRAGService connects to ChromaDB, waiting for it to come up, and loads
+// the service state from DataDir.
+func NewRAGService(ctx context.Context, cfg RAGConfig) (*RAGService, error) {
+	dataDir, retrieval, prompts, caches, models := cfg.DataDir, cfg.Retrieval, cfg.Prompts, cfg.Caches, cfg.Models
+
 	// Use retry client to wait for ChromaDB to be ready
-	chromaClient, err := clients.NewChromaDBClientWithRetry(chromaDBURL, 10, 5*time.Second)
+	chromaClient, err := clients.NewChromaDBClientWithRetry(ctx, cfg.ChromaDB)
 	if err != nil {
-		log.Fatalf("Failed to connect to ChromaDB: %v", err)
+		return nil, fmt.Errorf("failed to connect to ChromaDB: %w", err)
 	}
 
+	if len(models.Answer) == 0 {
+		models.Answer = DefaultModelConfig().Answer
+	}
+	googleClient := clients.NewGoogleAIClient(cfg.Gemini, models.Embedding)
+	usage := NewUsageTracker(dataDir, cfg.Quotas)
+	router := NewModelRouter(googleClient, models, usage)
 	service := &RAGService{
-		googleClient: clients.NewGoogleAIClient(googleAPIKey),
+		googleClient: googleClient,
+		models:       router,
 		chromaClient: chromaClient,
+		httpClient:   newPageClient(),
+		watchedURLs:  NewURLWatchList(dataDir),
+		keywordIndex: NewBM25Index(),
+		reranker:     newReranker(retrieval.Reranker, router),
+		retrieval:    retrieval,
+		tokenCounter: newTokenCounter(prompts.TokenCounter, googleClient, models.Models(TaskAnswer)[0], usage),
+		prompts:      prompts,
+		templates:    NewPromptTemplates(prompts.TemplateDir),
+		personas:     NewPersonaStore(dataDir),
+		sessions:     NewSessionStore(dataDir, prompts.HistoryTurns),
+		embeddings:   NewEmbeddingCache(dataDir, caches.EmbeddingCacheSize),
+		dataDir:      dataDir,
+		usage:        usage,
+	}
+	if caches.AnswerCache {
+		service.answers = NewAnswerCache(caches.AnswerCacheDistance, caches.AnswerCacheTTL)
 	}
 
-	if err := service.initializeCollection(); err != nil {
-		log.Printf("Warning: Failed to initialize collection: %v", err)
+	if err := service.initializeCollection(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to initialize collection", logging.Err(err))
 	} else {
-		log.Printf("Yeey: habemus collections!")
+		slog.InfoContext(ctx, "Collection ready", "collection", service.activeCollection().Name())
 	}
 
-	return service
-}
+	// Writes wait for the keyword index; the lock is taken here so that none
+	// gets in before the load starts.
+	service.writeMu.Lock()
+	go func() {
+		defer service.writeMu.Unlock()
+		if err := service.loadKeywordIndex(ctx); err != nil {
+			slog.WarnContext(ctx, "Failed to load keyword index", logging.Err(err))
+		}
+	}()
+	if caches.EmbeddingCacheSize > 0 {
+		go service.saveEmbeddingCache(ctx)
+	}
+	go service.saveUsage(ctx)
 
-func (s *RAGService) initializeCollection() error {
-	return s.chromaClient.CreateCollection(CollectionName)
+	return service, nil
 }
 
-func (s *RAGService) LearnFact(req LearnRequest) (*Response, error) {
-	log.Printf("LearnFact called with text: %.50s...", req.Text)
-	
-	if req.Text == "" {
+func (s *RAGService) LearnFact(ctx context.Context, req LearnRequest) (*Response, error) {
+	slog.InfoContext(ctx, "Learning fact", "user_id", req.UserID, "text", req.Text)
+	ctx = withUsageUser(ctx, req.UserID)
+
+	language := s.ResponseLanguage(req.UserID, req.Text)
+	if strings.TrimSpace(req.Text) == "" {
+		return &Response{
+			Success:  false,
+			Error:    localize(language, msgEmptyFact),
+			Language: language,
+		}, nil
+	}
+
+	docID := generateDocID(req.UserID, req.Text)
+
+	// Learning the exact same fact again is a no-op, so check before paying
+	// for an embedding. A fact that was superseded is learned again and in
+	// turn supersedes the fact that replaced it.
+	var revived bool
+	var successor *similarFact
+	if stored, found, err := s.getStoredFact(ctx, docID); err != nil {
+		slog.WarnContext(ctx, "Failed to look up existing fact", "id", docID, logging.Err(err))
+	} else if found && !isSuperseded(stored) {
+		s.touchFact(ctx, docID)
 		return &Response{
-			Success: false,
-			Error:   "Text cannot be empty",
+			Success:  true,
+			Message:  localize(language, msgFactDuplicate),
+			Status:   LearnStatusDuplicate,
+			Language: language,
 		}, nil
+	} else if found {
+		revived = true
+		if successor, err = s.currentSuccessor(ctx, docID, stored); err != nil {
+			slog.WarnContext(ctx, "Failed to look up the replacing fact", "id", docID, logging.Err(err))
+		}
 	}
 
-	log.Printf("Generating embedding for text...")
-	embedding, err := s.googleClient.GenerateEmbedding(req.Text)
+	embedding, err := s.embed(ctx, req.Text)
 	if err != nil {
-		log.Printf("Embedding generation failed: %v", err)
+		slog.ErrorContext(ctx, "Embedding generation failed", logging.Err(err))
 		return &Response{
-			Success: false,
-			Error:   "Failed to generate embedding",
+			Success:  false,
+			Error:    localize(language, msgEmbeddingFailed),
+			Language: language,
 		}, fmt.Errorf("embedding generation failed: %w", err)
 	}
-	log.Printf("Embedding generated successfully, length: %d", len(embedding))
+	slog.DebugContext(ctx, "Embedding generated", "dimension", len(embedding))
 
-	docID := s.generateDocID(req.Text)
+	similar, err := s.findSimilarFacts(ctx, docID, req.UserID, embedding)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to look up similar facts", logging.Err(err))
+	}
+	if successor != nil {
+		similar = withoutFact(similar, successor.ID)
+	}
+	nearDuplicates, related := splitNearDuplicates(similar)
+
+	now := time.Now().UTC().Format(time.RFC3339)
 	metadata := map[string]interface{}{
-		"timestamp": time.Now().UTC().Format(time.RFC3339),
-		"user_id":   req.UserID,
-		"type":      "fact",
+		"timestamp":     now,
+		"first_learned": earliestLearnedAt(nearDuplicates, now),
+		"user_id":       req.UserID,
+		"type":          "fact",
+		"superseded":    false,
+	}
+	if revived {
+		reviveMetadata(metadata)
 	}
 
-	log.Printf("Storing document in ChromaDB with ID: %s", docID)
-	err = s.chromaClient.AddDocument(CollectionName, docID, req.Text, embedding, metadata)
+	err = s.upsertDocuments(ctx, clients.AddRequest{
+		IDs:        []string{docID},
+		Embeddings: [][]float32{embedding},
+		Documents:  []string{req.Text},
+		Metadatas:  []map[string]interface{}{metadata},
+	})
 	if err != nil {
-		log.Printf("ChromaDB storage failed: %v", err)
+		slog.ErrorContext(ctx, "Failed to store fact", "id", docID, logging.Err(err))
 		return &Response{
-			Success: false,
-			Error:   "Failed to store fact",
+			Success:  false,
+			Error:    localize(language, msgStoreFactFailed),
+			Language: language,
 		}, fmt.Errorf("document storage failed: %w", err)
 	}
-	log.Printf("Document stored successfully in ChromaDB")
+	slog.InfoContext(ctx, "Fact stored", "id", docID)
+
+	status := LearnStatusNew
+	message := localize(language, msgFactLearned)
+	var superseded []SupersededFact
+
+	if len(nearDuplicates) > 0 {
+		status = LearnStatusMerged
+		message = localize(language, msgFactMerged)
+		superseded = append(superseded, s.markSuperseded(ctx, docID, nearDuplicates)...)
+	}
+
+	var replaced []SupersededFact
+	if successor != nil {
+		replaced = s.markSuperseded(ctx, docID, []similarFact{*successor})
+	}
+	if conflicting := s.detectConflictingFacts(ctx, req.Text, related); len(conflicting) > 0 {
+		replaced = append(replaced, s.markSuperseded(ctx, docID, conflicting)...)
+	}
+	if len(replaced) > 0 {
+		message = message + " " + localize(language, msgFactsSuperseded, len(replaced))
+		superseded = append(superseded, replaced...)
+	}
 
 	return &Response{
-		Success: true,
-		Message: "Fact learned successfully!",
+		Success:    true,
+		Message:    message,
+		Status:     status,
+		Superseded: superseded,
+		Language:   language,
 	}, nil
 }
 
-func (s *RAGService) ProcessMessage(req MessageRequest) (*Response, error) {
+func (s *RAGService) ProcessMessage(ctx context.Context, req MessageRequest) (*Response, error) {
+	ctx = withUsageUser(ctx, req.UserID)
+	language := s.ResponseLanguage(req.UserID, req.Text)
 	if req.Text == "" {
 		return &Response{
-			Success: false,
-			Error:   "Message cannot be empty",
+			Success:  false,
+			Error:    localize(language, msgEmptyMessage),
+			Language: language,
 		}, nil
 	}
 
-	queryEmbedding, err := s.googleClient.GenerateEmbedding(req.Text)
+	queryEmbedding, err := s.embed(ctx, req.Text)
 	if err != nil {
 		return &Response{
-			Success: false,
-			Error:   "Failed to process message",
+			Success:  false,
+			Error:    localize(language, msgProcessFailed),
+			Language: language,
 		}, fmt.Errorf("query embedding generation failed: %w", err)
 	}
 
-	similarDocs, err := s.chromaClient.QuerySimilar(CollectionName, queryEmbedding, MaxContextDocs)
-	if err != nil {
-		log.Printf("Warning: Failed to query similar documents: %v", err)
-		return s.generateResponseWithoutContext(req.Text)
+	useAnswerCache := s.answers != nil && !req.Debug
+	if useAnswerCache {
+		if answer, model, ok := s.answers.Lookup(req.UserID, language, queryEmbedding); ok {
+			s.recordTurn(req.UserID, req.Text, answer)
+			return &Response{
+				Success:  true,
+				Message:  answer,
+				Language: language,
+				Model:    model,
+			}, nil
+		}
 	}
 
-	if len(similarDocs.Documents) == 0 || len(similarDocs.Documents[0]) == 0 {
-		return s.generateResponseWithoutContext(req.Text)
+	prepared, debug, err := s.preparePrompt(ctx, req, queryEmbedding, language)
+	if err != nil {
+		return &Response{
+			Success:  false,
+			Error:    localize(language, msgProcessFailed),
+			Language: language,
+		}, err
 	}
 
-	contextDocs := similarDocs.Documents[0]
-	augmentedPrompt := s.buildAugmentedPrompt(req.Text, contextDocs)
-
-	response, err := s.googleClient.GenerateText(augmentedPrompt)
+	generateCtx, cancel := withStageTimeout(ctx, s.prompts.GenerationTimeout)
+	response, model, err := s.models.Generate(generateCtx, TaskAnswer, prepared.System, prepared.Prompt, s.prompts.MaxOutputTokens)
+	cancel()
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("text generation abandoned: %w", ctx.Err())
+		}
 		return &Response{
-			Success: false,
-			Error:   "Failed to generate response",
+			Success:  false,
+			Error:    localize(language, msgGenerateFailed),
+			Language: language,
 		}, fmt.Errorf("text generation failed: %w", err)
 	}
+	if useAnswerCache {
+		s.answers.Store(req.UserID, language, queryEmbedding, response, model)
+	}
+
+	s.recordTurn(req.UserID, req.Text, response)
 
 	return &Response{
-		Success: true,
-		Message: response,
+		Success:  true,
+		Message:  response,
+		Language: language,
+		Model:    model,
+		Debug:    debug,
 	}, nil
 }
 
-func (s *RAGService) generateResponseWithoutContext(userQuery string) (*Response, error) {
-	prompt := fmt.Sprintf(`You are Iara, a helpful personal AI assistant. The user is asking: "%s"
+// PromptPreview is the prompt that would be sent to the model for a message.
+type PromptPreview struct {
+	System    string          `json:"system"`
+	Prompt    string          `json:"prompt"`
+	Language  string          `json:"language"`
+	Persona   Persona         `json:"persona"`
+	Breakdown PromptBreakdown `json:"breakdown"`
+	Retrieval []DebugDocument `json:"retrieval,omitempty"`
+}
 
-Please respond helpfully, but let them know that you don't have specific information about this topic in your personal knowledge base yet. You can suggest they teach you facts using the /learn command.`, userQuery)
+// PreviewPrompt retrieves context and renders the prompt for a message
+// without generating an answer or recording it in the session.
+func (s *RAGService) PreviewPrompt(ctx context.Context, req MessageRequest) (*PromptPreview, error) {
+	if strings.TrimSpace(req.Text) == "" {
+		return nil, fmt.Errorf("message cannot be empty")
+	}
+	ctx = withUsageUser(ctx, req.UserID)
 
-	response, err := s.googleClient.GenerateText(prompt)
+	req.Debug = true
+	language := s.ResponseLanguage(req.UserID, req.Text)
+	queryEmbedding, err := s.embed(ctx, req.Text)
 	if err != nil {
-		return &Response{
-			Success: false,
-			Error:   "Failed to generate response",
-		}, fmt.Errorf("text generation failed: %w", err)
+		return nil, fmt.Errorf("query embedding generation failed: %w", err)
 	}
-
-	return &Response{
-		Success: true,
-		Message: response,
+	prepared, debug, err := s.preparePrompt(ctx, req, queryEmbedding, language)
+	if err != nil {
+		return nil, err
+	}
+	return &PromptPreview{
+		System:    prepared.System,
+		Prompt:    prepared.Prompt,
+		Language:  language,
+		Persona:   prepared.Persona,
+		Breakdown: prepared.Breakdown,
+		Retrieval: debug.Retrieval,
 	}, nil
 }
 
-func (s *RAGService) buildAugmentedPrompt(userQuery string, contextDocs []string) string {
-	context := strings.Join(contextDocs, "\n\n")
+// preparePrompt retrieves and reranks context for the message and assembles
+// the prompt with the user's persona and conversation history, asking for an
+// answer in the given language.
+func (s *RAGService) preparePrompt(ctx context.Context, req MessageRequest, queryEmbedding []float32, language string) (*assembledPrompt, *DebugInfo, error) {
+	candidates, err := s.retrieve(ctx, req.UserID, req.Text, queryEmbedding, s.retrieval.RerankCandidates)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to retrieve context", logging.Err(err))
+	}
 
-	prompt := fmt.Sprintf(`You are Iara, a helpful personal AI assistant. You have access to the user's personal knowledge base.
+	ranked := s.rerank(ctx, req.Text, candidates)
+	selected := selectContext(ranked, s.retrieval)
 
-CONTEXT FROM KNOWLEDGE BASE:
-%s
+	var debug *DebugInfo
+	if req.Debug {
+		debug = &DebugInfo{Retrieval: retrievalDebug(ranked, selected)}
+	}
 
-USER QUESTION: %s
+	persona := s.personas.Get(req.UserID)
+	persona.Language = language
+	parts := promptParts{
+		Template: TemplateAnswer,
+		UserID:   req.UserID,
+		Persona:  persona,
+		Question: req.Text,
+		Context:  documentTexts(selected),
+		History:  s.sessions.History(req.UserID),
+	}
+	if len(selected) == 0 {
+		parts.Template = TemplateNoContext
+	}
 
-Please answer the user's question using the information from the knowledge base above. If the information is not sufficient to answer the question completely, be honest about what you know and don't know. Be conversational and helpful.`, context, userQuery)
+	prepared, err := s.assemblePrompt(ctx, parts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("prompt assembly failed: %w", err)
+	}
+	if debug != nil {
+		debug.Prompt = &prepared.Breakdown
+	}
+	return prepared, debug, nil
+}
 
-	return prompt
+func (s *RAGService) recordTurn(userID, question, answer string) {
+	now := time.Now().UTC()
+	s.sessions.Append(userID,
+		ChatTurn{Role: RoleUser, Text: question, Time: now},
+		ChatTurn{Role: RoleAssistant, Text: answer, Time: now},
+	)
 }
 
-func (s *RAGService) generateDocID(text string) string {
-	hasher := md5.New()
-	hasher.Write([]byte(text + time.Now().Format("2006-01-02")))
-	return hex.EncodeToString(hasher.Sum(nil))
+// Persona returns the effective persona of a user.
+func (s *RAGService) Persona(userID string) Persona {
+	return s.personas.Get(userID)
 }
 
+// SetPersona stores a user's persona overrides and returns the effective
+// persona.
+func (s *RAGService) SetPersona(userID string, persona Persona) (Persona, error) {
+	if err := s.personas.Put(userID, persona); err != nil {
+		return Persona{}, err
+	}
+	s.invalidateAnswers(userID)
+	return s.personas.Get(userID), nil
+}
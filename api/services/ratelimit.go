@@ -0,0 +1,98 @@
+package services
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig sets the token buckets requests are drawn from: every API
+// key and every user gets its own bucket, refilled at the given rate per
+// minute. A rate of zero disables the limit.
+type RateLimitConfig struct {
+	KeyPerMinute  float64
+	KeyBurst      int
+	UserPerMinute float64
+	UserBurst     int
+}
+
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		KeyPerMinute:  120,
+		KeyBurst:      30,
+		UserPerMinute: 20,
+		UserBurst:     10,
+	}
+}
+
+// MaxRateLimitBuckets bounds the buckets a limiter keeps; full buckets are
+// dropped when it is reached, since a new bucket starts full anyway.
+const MaxRateLimitBuckets = 10000
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter is a set of token buckets keyed by caller.
+type RateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+// NewRateLimiter returns nil, which allows everything, when perMinute is not
+// positive.
+func NewRateLimiter(perMinute float64, burst int) *RateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:    perMinute / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Allow takes a token from key's bucket. When the bucket is empty it reports
+// false and how long until a token is available.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l == nil || strings.TrimSpace(key) == "" {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= MaxRateLimitBuckets {
+			l.prune(now)
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune drops the buckets that have refilled completely. l.mu must be held.
+func (l *RateLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// MinReadableText is the amount of text a candidate container must hold to be
+// picked as the main content of a page.
+const MinReadableText = 250
+
+var (
+	boilerplateElements = "script, style, noscript, template, svg, iframe, form, nav, header, footer, aside, button, input, select"
+	boilerplatePattern  = regexp.MustCompile(`(?i)comment|sidebar|footer|header|menu|navbar|breadcrumb|cookie|banner|share|social|related|advert|promo|popup|modal|newsletter|skip`)
+	contentPattern      = regexp.MustCompile(`(?i)article|content|main|post|entry|text|body|materia|noticia|conteudo`)
+)
+
+// extractReadable strips navigation and other boilerplate from a web page and
+// returns its title and the sections of its main content, in the spirit of
+// Mozilla's Readability.
+func extractReadable(doc *goquery.Document) (string, []documentSection) {
+	title := pageTitle(doc)
+
+	doc.Find(boilerplateElements).Remove()
+	doc.Find("[class], [id], [role]").Each(func(i int, s *goquery.Selection) {
+		if goquery.NodeName(s) == "body" || goquery.NodeName(s) == "html" {
+			return
+		}
+		if role, _ := s.Attr("role"); role == "navigation" || role == "banner" || role == "contentinfo" || role == "complementary" {
+			s.Remove()
+			return
+		}
+		hint := attrHint(s)
+		if boilerplatePattern.MatchString(hint) && !contentPattern.MatchString(hint) {
+			s.Remove()
+		}
+	})
+
+	return title, htmlSections(mainContent(doc))
+}
+
+// mainContent prefers explicit <article>/<main> markup and otherwise picks the
+// container whose paragraphs hold the most text, penalizing link-heavy blocks.
+func mainContent(doc *goquery.Document) *goquery.Selection {
+	for _, selector := range []string{"article", "main", "[role=main]"} {
+		candidate := doc.Find(selector).First()
+		if candidate.Length() > 0 && len(collapseSpaces(candidate.Text())) >= MinReadableText {
+			return candidate
+		}
+	}
+
+	type candidate struct {
+		sel   *goquery.Selection
+		score float64
+	}
+	candidates := make(map[*html.Node]*candidate)
+	var order []*candidate
+
+	doc.Find("p, pre, td, blockquote").Each(func(i int, p *goquery.Selection) {
+		text := collapseSpaces(p.Text())
+		if len(text) < 25 {
+			return
+		}
+		score := 1 + float64(strings.Count(text, ",")) + float64(min(len(text)/100, 3))
+
+		// Credit the parent fully and the grandparent half, as Readability does.
+		for depth, ancestor := range []*goquery.Selection{p.Parent(), p.Parent().Parent()} {
+			if ancestor.Length() == 0 {
+				continue
+			}
+			c, ok := candidates[ancestor.Get(0)]
+			if !ok {
+				c = &candidate{sel: ancestor, score: contentBonus(ancestor)}
+				candidates[ancestor.Get(0)] = c
+				order = append(order, c)
+			}
+			c.score += score / float64(depth+1)
+		}
+	})
+
+	var best *goquery.Selection
+	bestScore := 0.0
+	for _, c := range order {
+		score := c.score * (1 - linkDensity(c.sel))
+		if score > bestScore {
+			best, bestScore = c.sel, score
+		}
+	}
+
+	if best == nil || len(collapseSpaces(best.Text())) < MinReadableText {
+		return doc.Find("body")
+	}
+	return best
+}
+
+func contentBonus(s *goquery.Selection) float64 {
+	hint := attrHint(s)
+	switch {
+	case contentPattern.MatchString(hint):
+		return 25
+	case boilerplatePattern.MatchString(hint):
+		return -25
+	}
+	return 0
+}
+
+// linkDensity is the share of a container's text that sits inside links.
+func linkDensity(s *goquery.Selection) float64 {
+	total := len(collapseSpaces(s.Text()))
+	if total == 0 {
+		return 1
+	}
+	linked := 0
+	s.Find("a").Each(func(i int, a *goquery.Selection) {
+		linked += len(collapseSpaces(a.Text()))
+	})
+	return float64(linked) / float64(total)
+}
+
+func attrHint(s *goquery.Selection) string {
+	class, _ := s.Attr("class")
+	id, _ := s.Attr("id")
+	return class + " " + id
+}
+
+func pageTitle(doc *goquery.Document) string {
+	if title, ok := doc.Find(`meta[property="og:title"]`).Attr("content"); ok && strings.TrimSpace(title) != "" {
+		return collapseSpaces(title)
+	}
+	if title := collapseSpaces(doc.Find("title").First().Text()); title != "" {
+		return title
+	}
+	return collapseSpaces(doc.Find("h1").First().Text())
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"iara-assistant/clients"
+	"iara-assistant/logging"
+)
+
+const (
+	// ReindexProgressFile records how far a re-index got, so an interrupted
+	// one resumes where it stopped.
+	ReindexProgressFile = "reindex.json"
+	ReindexPageSize     = 200
+
+	ReindexRunning     = "running"
+	ReindexInterrupted = "interrupted"
+	ReindexCompleted   = "completed"
+)
+
+var ErrReindexRunning = errors.New("a re-index is already running")
+
+type ReindexProgress struct {
+	Status    string `json:"status"`
+	Source    string `json:"source"`
+	Target    string `json:"target"`
+	Model     string `json:"model"`
+	Total     int    `json:"total"`
+	Processed int    `json:"processed"`
+	Embedded  int    `json:"embedded"`
+	StartedAt string `json:"started_at"`
+	UpdatedAt string `json:"updated_at"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Reindex re-embeds every document of the active collection with the
+// configured embedding model into a new collection, then switches the alias
+// to it. The old collection is kept so the switch can be undone by editing
+// the alias file. Writes made while it runs go to both collections.
+func (s *RAGService) Reindex(ctx context.Context) (*ReindexProgress, error) {
+	if !s.reindexMu.TryLock() {
+		return nil, ErrReindexRunning
+	}
+	defer s.reindexMu.Unlock()
+	return s.reindex(ctx)
+}
+
+// StartReindex runs Reindex in the background. WaitReindex waits for it.
+func (s *RAGService) StartReindex(ctx context.Context) error {
+	if !s.reindexMu.TryLock() {
+		return ErrReindexRunning
+	}
+	s.reindexJobs.Add(1)
+	go func() {
+		defer s.reindexJobs.Done()
+		defer s.reindexMu.Unlock()
+		if _, err := s.reindex(ctx); err != nil {
+			slog.ErrorContext(ctx, "Re-index failed", logging.Err(err))
+		}
+	}()
+	return nil
+}
+
+func (s *RAGService) WaitReindex() {
+	s.reindexJobs.Wait()
+}
+
+// ReindexStatus returns the progress of the last re-index, or nil if none
+// was ever started.
+func (s *RAGService) ReindexStatus() (*ReindexProgress, error) {
+	var progress ReindexProgress
+	if err := readJSONFile(filepath.Join(s.dataDir, ReindexProgressFile), &progress); err != nil {
+		return nil, err
+	}
+	if progress.Status == "" {
+		return nil, nil
+	}
+	return &progress, nil
+}
+
+func (s *RAGService) reindex(ctx context.Context) (*ReindexProgress, error) {
+	model := s.googleClient.EmbeddingModel()
+	source := s.activeCollection()
+
+	progress, err := s.ReindexStatus()
+	if err != nil {
+		return nil, err
+	}
+	if progress == nil || progress.Status == ReindexCompleted || progress.Source != source.Name() || progress.Model != model {
+		progress = &ReindexProgress{
+			Source:    source.Name(),
+			Target:    newCollectionName(),
+			Model:     model,
+			StartedAt: time.Now().UTC().Format(time.RFC3339),
+		}
+		slog.InfoContext(ctx, "Re-indexing", "source", progress.Source, "target", progress.Target, "model", model)
+	} else {
+		slog.InfoContext(ctx, "Resuming re-index", "source", progress.Source, "target", progress.Target, "processed", progress.Processed)
+	}
+	progress.Status = ReindexRunning
+	progress.Error = ""
+
+	target := &vectorCollection{name: progress.Target, model: model}
+	if err := s.chromaClient.CreateCollection(ctx, target.Name(), target.metadata()); err != nil {
+		return s.interruptReindex(progress, err)
+	}
+	if info, err := s.chromaClient.GetCollection(ctx, target.Name()); err == nil {
+		if dimension, ok := info.Metadata[MetadataEmbeddingDimension].(float64); ok {
+			target.dimension = int(dimension)
+		}
+	}
+	s.setReindexTarget(target)
+	defer s.setReindexTarget(nil)
+
+	// Writes are mirrored to the target from here on, so a snapshot of the
+	// source IDs taken now covers everything the target would otherwise
+	// miss. Documents already in the target are skipped, which also makes a
+	// resumed job walk the whole snapshot again cheaply.
+	ids, err := s.chromaClient.ListIDs(ctx, source.Name())
+	if err != nil {
+		return s.interruptReindex(progress, err)
+	}
+	progress.Total = len(ids)
+	progress.Processed = 0
+	s.saveReindexProgress(progress)
+
+	for start := 0; start < len(ids); start += ReindexPageSize {
+		if err := ctx.Err(); err != nil {
+			return s.interruptReindex(progress, err)
+		}
+
+		pageIDs := ids[start:min(start+ReindexPageSize, len(ids))]
+		embedded, err := s.copyIDsToCollection(ctx, source, target, pageIDs)
+		if err != nil {
+			return s.interruptReindex(progress, err)
+		}
+
+		progress.Processed += len(pageIDs)
+		progress.Embedded += embedded
+		s.saveReindexProgress(progress)
+	}
+
+	if err := s.reconcileReindex(ctx, source, target, progress); err != nil {
+		return s.interruptReindex(progress, err)
+	}
+
+	if err := s.swapCollection(target); err != nil {
+		return s.interruptReindex(progress, err)
+	}
+	progress.Status = ReindexCompleted
+	s.saveReindexProgress(progress)
+	slog.InfoContext(ctx, "Re-index complete", "active", target.Name(), "kept", source.Name())
+	return progress, nil
+}
+
+// reconcileReindex makes sure the target holds exactly the documents of the
+// source before the swap: documents the copy missed are copied, and ones
+// deleted from the source but not from the target are dropped. A write racing
+// with the check can still leave the counts apart; the job is then
+// interrupted, and running it again resumes into the same target.
+func (s *RAGService) reconcileReindex(ctx context.Context, source, target *vectorCollection, progress *ReindexProgress) error {
+	sourceIDs, err := s.chromaClient.ListIDs(ctx, source.Name())
+	if err != nil {
+		return err
+	}
+	targetIDs, err := s.chromaClient.ListIDs(ctx, target.Name())
+	if err != nil {
+		return err
+	}
+
+	inSource := make(map[string]bool, len(sourceIDs))
+	for _, id := range sourceIDs {
+		inSource[id] = true
+	}
+	var extra []string
+	for _, id := range targetIDs {
+		if !inSource[id] {
+			extra = append(extra, id)
+		}
+		delete(inSource, id)
+	}
+	var missing []string
+	for _, id := range sourceIDs {
+		if inSource[id] {
+			missing = append(missing, id)
+		}
+	}
+
+	if len(missing) > 0 || len(extra) > 0 {
+		slog.InfoContext(ctx, "Reconciling re-index target", "missing", len(missing), "extra", len(extra))
+	}
+	for start := 0; start < len(missing); start += ReindexPageSize {
+		embedded, err := s.copyIDsToCollection(ctx, source, target, missing[start:min(start+ReindexPageSize, len(missing))])
+		if err != nil {
+			return err
+		}
+		progress.Embedded += embedded
+	}
+	if len(extra) > 0 {
+		if err := s.chromaClient.DeleteDocuments(ctx, target.Name(), extra, nil); err != nil {
+			return err
+		}
+	}
+
+	sourceCount, err := s.chromaClient.CountDocuments(ctx, source.Name())
+	if err != nil {
+		return err
+	}
+	targetCount, err := s.chromaClient.CountDocuments(ctx, target.Name())
+	if err != nil {
+		return err
+	}
+	if sourceCount != targetCount {
+		return fmt.Errorf("target has %d documents but the source has %d; run the re-index again", targetCount, sourceCount)
+	}
+	return nil
+}
+
+// copyIDsToCollection re-embeds the given source documents that the target
+// does not have yet. Documents already there were copied before an
+// interruption or written while the re-index ran, and are left alone, and
+// ones deleted from the source since the snapshot are no longer found.
+func (s *RAGService) copyIDsToCollection(ctx context.Context, source, target *vectorCollection, ids []string) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	existing, err := s.chromaClient.GetDocuments(ctx, target.Name(), ids)
+	if err != nil {
+		return 0, err
+	}
+	copied := make(map[string]bool, len(existing.IDs))
+	for _, id := range existing.IDs {
+		copied[id] = true
+	}
+	var pending []string
+	for _, id := range ids {
+		if !copied[id] {
+			pending = append(pending, id)
+		}
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	page, err := s.chromaClient.GetDocuments(ctx, source.Name(), pending)
+	if err != nil {
+		return 0, err
+	}
+
+	batch := clients.AddRequest{}
+	for i, id := range page.IDs {
+		if i >= len(page.Documents) {
+			continue
+		}
+		var metadata map[string]interface{}
+		if i < len(page.Metadatas) {
+			metadata = page.Metadatas[i]
+		}
+		batch.IDs = append(batch.IDs, id)
+		batch.Documents = append(batch.Documents, page.Documents[i])
+		batch.Metadatas = append(batch.Metadatas, metadata)
+	}
+	if len(batch.IDs) == 0 {
+		return 0, nil
+	}
+
+	batch.Embeddings, err = s.embedBatch(ctx, batch.Documents)
+	if err != nil {
+		return 0, fmt.Errorf("embedding generation failed: %w", err)
+	}
+	if err := s.checkEmbeddings(ctx, target, batch.Embeddings); err != nil {
+		return 0, err
+	}
+	if err := s.chromaClient.UpsertDocuments(ctx, target.Name(), batch); err != nil {
+		return 0, err
+	}
+	return len(batch.IDs), nil
+}
+
+func (s *RAGService) setReindexTarget(target *vectorCollection) {
+	s.collectionMu.Lock()
+	s.reindexTarget = target
+	s.collectionMu.Unlock()
+}
+
+func (s *RAGService) interruptReindex(progress *ReindexProgress, err error) (*ReindexProgress, error) {
+	progress.Status = ReindexInterrupted
+	progress.Error = err.Error()
+	s.saveReindexProgress(progress)
+	return progress, fmt.Errorf("re-index interrupted after %d documents: %w", progress.Processed, err)
+}
+
+func (s *RAGService) saveReindexProgress(progress *ReindexProgress) {
+	progress.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := writeJSONFile(filepath.Join(s.dataDir, ReindexProgressFile), progress); err != nil {
+		slog.Warn("Failed to save re-index progress", logging.Err(err))
+	}
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"iara-assistant/logging"
+)
+
+const (
+	RerankerLLM   = "llm"
+	RerankerLocal = "local"
+	RerankerNone  = "none"
+
+	// MaxRerankPassageChars limits how much of each passage is shown to the
+	// reranking model.
+	MaxRerankPassageChars = 600
+)
+
+// Reranker scores retrieved documents for a query on a 0-10 scale, returning
+// one score per document in the same order.
+type Reranker interface {
+	Rerank(ctx context.Context, query string, docs []retrievedDoc) ([]float64, error)
+}
+
+// llmReranker asks Gemini to grade every passage at once, cross-encoder style:
+// the model sees the query and each passage together.
+type llmReranker struct {
+	googleClient generativeClient
+}
+
+type generativeClient interface {
+	GenerateText(ctx context.Context, task, prompt string) (string, error)
+}
+
+func (r *llmReranker) Rerank(ctx context.Context, query string, docs []retrievedDoc) ([]float64, error) {
+	var passages strings.Builder
+	for i, doc := range docs {
+		text := doc.Text
+		if runes := []rune(text); len(runes) > MaxRerankPassageChars {
+			text = string(runes[:MaxRerankPassageChars]) + "..."
+		}
+		fmt.Fprintf(&passages, "[%d] %s\n\n", i+1, strings.ReplaceAll(text, "\n", " "))
+	}
+
+	prompt := fmt.Sprintf(`You are ranking passages from a personal knowledge base by how useful they are to answer a question.
+
+QUESTION: %s
+
+PASSAGES:
+%s
+Rate every passage from 0 (irrelevant) to 10 (directly answers the question). Reply with only a JSON array of %d numbers, one per passage, in the order given.`, query, passages.String(), len(docs))
+
+	answer, err := r.googleClient.GenerateText(ctx, TaskRerank, prompt)
+	if err != nil {
+		return nil, fmt.Errorf("rerank request failed: %w", err)
+	}
+
+	scores, err := parseScoreList(answer)
+	if err != nil {
+		return nil, err
+	}
+	if len(scores) != len(docs) {
+		return nil, fmt.Errorf("reranker returned %d scores for %d passages", len(scores), len(docs))
+	}
+	return scores, nil
+}
+
+// localReranker scores documents without calling a model, blending how many
+// of the query terms a document contains with its fused retrieval score.
+type localReranker struct{}
+
+func (localReranker) Rerank(ctx context.Context, query string, docs []retrievedDoc) ([]float64, error) {
+	queryTerms := make(map[string]bool)
+	for _, term := range tokenize(query) {
+		queryTerms[term] = true
+	}
+
+	maxFused := 0.0
+	for _, doc := range docs {
+		if doc.Score > maxFused {
+			maxFused = doc.Score
+		}
+	}
+
+	scores := make([]float64, len(docs))
+	for i, doc := range docs {
+		coverage := 0.0
+		if len(queryTerms) > 0 {
+			found := make(map[string]bool)
+			for _, term := range tokenize(doc.Text) {
+				if queryTerms[term] {
+					found[term] = true
+				}
+			}
+			coverage = float64(len(found)) / float64(len(queryTerms))
+		}
+		fused := 0.0
+		if maxFused > 0 {
+			fused = doc.Score / maxFused
+		}
+		scores[i] = 10 * (0.5*coverage + 0.5*fused)
+	}
+	return scores, nil
+}
+
+func newReranker(name string, googleClient generativeClient) Reranker {
+	switch name {
+	case RerankerNone:
+		return nil
+	case RerankerLocal:
+		return localReranker{}
+	case RerankerLLM, "":
+		return &llmReranker{googleClient: googleClient}
+	default:
+		slog.Warn("Unknown reranker, using the local one", "reranker", name, "fallback", RerankerLocal)
+		return localReranker{}
+	}
+}
+
+// rerank orders the candidates by reranker score. If the reranker fails the
+// fused retrieval order is kept.
+func (s *RAGService) rerank(ctx context.Context, query string, candidates []retrievedDoc) []retrievedDoc {
+	if s.reranker == nil || len(candidates) == 0 {
+		return candidates
+	}
+
+	ctx, cancel := withStageTimeout(ctx, s.retrieval.RerankTimeout)
+	defer cancel()
+	scores, err := s.reranker.Rerank(ctx, query, candidates)
+	if err != nil {
+		slog.WarnContext(ctx, "Reranking failed, keeping retrieval order", logging.Err(err))
+		return candidates
+	}
+
+	ranked := make([]retrievedDoc, len(candidates))
+	copy(ranked, candidates)
+	for i := range ranked {
+		score := scores[i]
+		ranked[i].RerankScore = &score
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return *ranked[i].RerankScore > *ranked[j].RerankScore
+	})
+	return ranked
+}
+
+// selectContext keeps the best documents, up to cfg.TopK of them, whose
+// combined size fits in the context token budget. Documents the reranker
+// scored below the minimum are dropped.
+func selectContext(ranked []retrievedDoc, cfg RetrievalConfig) []retrievedDoc {
+	var selected []retrievedDoc
+	used := 0
+	for _, doc := range ranked {
+		if cfg.TopK > 0 && len(selected) >= cfg.TopK {
+			break
+		}
+		if doc.RerankScore != nil && *doc.RerankScore < cfg.MinRerankScore {
+			continue
+		}
+		tokens := estimateTokens(doc.Text)
+		if cfg.ContextTokenBudget > 0 && used+tokens > cfg.ContextTokenBudget {
+			continue
+		}
+		used += tokens
+		selected = append(selected, doc)
+	}
+	return selected
+}
+
+// parseScoreList extracts a JSON array of numbers from a model answer.
+func parseScoreList(answer string) ([]float64, error) {
+	var scores []float64
+	if err := unmarshalJSONArray(answer, &scores); err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
@@ -0,0 +1,222 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"iara-assistant/logging"
+	"iara-assistant/metrics"
+)
+
+// RetrievalConfig controls the retrieval pipeline. Vector and keyword results
+// are fetched separately and fused with reciprocal rank fusion: a document
+// scores weight / (RRFK + rank) for every list it appears in. The best
+// RerankCandidates fused documents are then reranked, and the top TopK that
+// fit in ContextTokenBudget are used as context. The timeouts bound each
+// stage of the pipeline; zero means no limit beyond the request's own.
+type RetrievalConfig struct {
+	VectorTopK         int
+	KeywordTopK        int
+	TopK               int
+	VectorWeight       float64
+	KeywordWeight      float64
+	RRFK               float64
+	RerankCandidates   int
+	Reranker           string
+	MinRerankScore     float64
+	ContextTokenBudget int
+	EmbeddingTimeout   time.Duration
+	RetrievalTimeout   time.Duration
+	RerankTimeout      time.Duration
+}
+
+func DefaultRetrievalConfig() RetrievalConfig {
+	return RetrievalConfig{
+		VectorTopK:         20,
+		KeywordTopK:        20,
+		TopK:               MaxContextDocs,
+		VectorWeight:       1.0,
+		KeywordWeight:      1.0,
+		RRFK:               60,
+		RerankCandidates:   20,
+		Reranker:           RerankerLLM,
+		MinRerankScore:     2,
+		ContextTokenBudget: 2000,
+		EmbeddingTimeout:   10 * time.Second,
+		RetrievalTimeout:   5 * time.Second,
+		RerankTimeout:      10 * time.Second,
+	}
+}
+
+type retrievedDoc struct {
+	ID          string
+	Text        string
+	Metadata    map[string]interface{}
+	Distance    float32
+	VectorRank  int
+	KeywordRank int
+	Score       float64
+	RerankScore *float64
+}
+
+// retrievalFilter limits retrieval to the active documents of the user and
+// to the shared ones, stored without a user.
+func retrievalFilter(userID string) map[string]interface{} {
+	return map[string]interface{}{
+		"$and": []map[string]interface{}{
+			activeFactsFilter,
+			{"user_id": map[string]interface{}{"$in": []interface{}{userID, ""}}},
+		},
+	}
+}
+
+// retrieve returns up to limit documents of the user relevant to the query,
+// fusing Chroma's similarity search with the local keyword index. It only
+// fails when both retrievers fail.
+func (s *RAGService) retrieve(ctx context.Context, userID, query string, queryEmbedding []float32, limit int) ([]retrievedDoc, error) {
+	cfg := s.retrieval
+	ctx, cancel := withStageTimeout(ctx, cfg.RetrievalTimeout)
+	defer cancel()
+
+	docs := make(map[string]*retrievedDoc)
+	var order []string
+
+	get := func(id, text string, metadata map[string]interface{}) *retrievedDoc {
+		doc, ok := docs[id]
+		if !ok {
+			doc = &retrievedDoc{ID: id, Text: text, Metadata: metadata}
+			docs[id] = doc
+			order = append(order, id)
+		}
+		return doc
+	}
+
+	where := retrievalFilter(userID)
+	vectorResult, vectorErr := s.querySimilar(ctx, queryEmbedding, cfg.VectorTopK, where)
+	if errors.Is(vectorErr, ErrEmbeddingMismatch) {
+		return nil, vectorErr
+	} else if vectorErr != nil {
+		slog.WarnContext(ctx, "Failed to query similar documents", logging.Err(vectorErr))
+	} else if len(vectorResult.IDs) > 0 {
+		rank := 0
+		for i, id := range vectorResult.IDs[0] {
+			metadata := resultMetadata(vectorResult, i)
+			if i >= len(vectorResult.Documents[0]) || isSuperseded(metadata) {
+				continue
+			}
+			rank++
+			doc := get(id, vectorResult.Documents[0][i], metadata)
+			doc.VectorRank = rank
+			if len(vectorResult.Distances) > 0 && i < len(vectorResult.Distances[0]) {
+				doc.Distance = vectorResult.Distances[0][i]
+				metrics.RetrievalDistance.Observe(float64(doc.Distance))
+			}
+		}
+		metrics.RetrievalHits.WithLabelValues("vector").Add(float64(rank))
+	}
+
+	keywordHits := s.keywordIndex.Search(query, cfg.KeywordTopK, where)
+	metrics.RetrievalHits.WithLabelValues("keyword").Add(float64(len(keywordHits)))
+	for i, hit := range keywordHits {
+		doc := get(hit.ID, hit.Text, hit.Metadata)
+		doc.KeywordRank = i + 1
+	}
+
+	if vectorErr != nil && len(docs) == 0 {
+		return nil, fmt.Errorf("vector retrieval failed: %w", vectorErr)
+	}
+	if len(docs) == 0 {
+		metrics.RetrievalQueries.WithLabelValues("miss").Inc()
+	} else {
+		metrics.RetrievalQueries.WithLabelValues("hit").Inc()
+	}
+
+	fused := make([]retrievedDoc, 0, len(order))
+	for _, id := range order {
+		fused = append(fused, *docs[id])
+	}
+	fuseRanks(fused, cfg)
+	if limit > 0 && len(fused) > limit {
+		fused = fused[:limit]
+	}
+	return fused, nil
+}
+
+// fuseRanks scores documents by weighted reciprocal rank fusion of their
+// vector and keyword ranks, where a zero rank means the retriever missed the
+// document, and sorts them best first. Ties keep their order.
+func fuseRanks(docs []retrievedDoc, cfg RetrievalConfig) {
+	for i := range docs {
+		docs[i].Score = 0
+		if rank := docs[i].VectorRank; rank > 0 {
+			docs[i].Score += cfg.VectorWeight / (cfg.RRFK + float64(rank))
+		}
+		if rank := docs[i].KeywordRank; rank > 0 {
+			docs[i].Score += cfg.KeywordWeight / (cfg.RRFK + float64(rank))
+		}
+	}
+	sort.SliceStable(docs, func(i, j int) bool { return docs[i].Score > docs[j].Score })
+}
+
+// withStageTimeout bounds one stage of a request. A zero timeout leaves ctx
+// as it is.
+func withStageTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func documentTexts(docs []retrievedDoc) []string {
+	texts := make([]string, len(docs))
+	for i, doc := range docs {
+		texts[i] = doc.Text
+	}
+	return texts
+}
+
+type DebugInfo struct {
+	Retrieval []DebugDocument  `json:"retrieval"`
+	Prompt    *PromptBreakdown `json:"prompt,omitempty"`
+}
+
+type DebugDocument struct {
+	ID          string   `json:"id"`
+	Snippet     string   `json:"snippet"`
+	VectorRank  int      `json:"vector_rank,omitempty"`
+	KeywordRank int      `json:"keyword_rank,omitempty"`
+	Distance    float32  `json:"distance,omitempty"`
+	FusedScore  float64  `json:"fused_score"`
+	RerankScore *float64 `json:"rerank_score,omitempty"`
+	Selected    bool     `json:"selected"`
+}
+
+func retrievalDebug(ranked, selected []retrievedDoc) []DebugDocument {
+	chosen := make(map[string]bool, len(selected))
+	for _, doc := range selected {
+		chosen[doc.ID] = true
+	}
+
+	debug := make([]DebugDocument, len(ranked))
+	for i, doc := range ranked {
+		snippet := doc.Text
+		if runes := []rune(snippet); len(runes) > 120 {
+			snippet = string(runes[:120]) + "..."
+		}
+		debug[i] = DebugDocument{
+			ID:          doc.ID,
+			Snippet:     snippet,
+			VectorRank:  doc.VectorRank,
+			KeywordRank: doc.KeywordRank,
+			Distance:    doc.Distance,
+			FusedScore:  doc.Score,
+			RerankScore: doc.RerankScore,
+			Selected:    chosen[doc.ID],
+		}
+	}
+	return debug
+}
@@ -0,0 +1,82 @@
+package services
+
+import "testing"
+
+func TestRetrievalFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata map[string]interface{}
+		want     bool
+	}{
+		{name: "own fact", metadata: map[string]interface{}{"user_id": "alice"}, want: true},
+		{name: "shared document", metadata: map[string]interface{}{"user_id": ""}, want: true},
+		{name: "other user's fact", metadata: map[string]interface{}{"user_id": "bob"}, want: false},
+		{name: "superseded fact", metadata: map[string]interface{}{"user_id": "alice", "superseded": true}, want: false},
+	}
+
+	where := retrievalFilter("alice")
+	for _, tt := range tests {
+		if got := matchesWhere(tt.metadata, where); got != tt.want {
+			t.Errorf("%s: got = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFuseRanks(t *testing.T) {
+	tests := []struct {
+		name    string
+		docs    []retrievedDoc
+		weights [2]float64
+		want    []string
+	}{
+		{
+			name: "found by both beats found by one",
+			docs: []retrievedDoc{
+				{ID: "vector only", VectorRank: 1},
+				{ID: "keyword only", KeywordRank: 1},
+				{ID: "both", VectorRank: 2, KeywordRank: 2},
+			},
+			weights: [2]float64{1, 1},
+			want:    []string{"both", "vector only", "keyword only"},
+		},
+		{
+			name: "keyword weight",
+			docs: []retrievedDoc{
+				{ID: "vector only", VectorRank: 1},
+				{ID: "keyword only", KeywordRank: 1},
+			},
+			weights: [2]float64{1, 2},
+			want:    []string{"keyword only", "vector only"},
+		},
+		{
+			name: "better ranks first",
+			docs: []retrievedDoc{
+				{ID: "third", VectorRank: 3},
+				{ID: "first", VectorRank: 1},
+				{ID: "second", VectorRank: 2},
+			},
+			weights: [2]float64{1, 1},
+			want:    []string{"first", "second", "third"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultRetrievalConfig()
+			cfg.VectorWeight, cfg.KeywordWeight = tt.weights[0], tt.weights[1]
+			docs := append([]retrievedDoc(nil), tt.docs...)
+			fuseRanks(docs, cfg)
+			for i, want := range tt.want {
+				if docs[i].ID != want {
+					t.Errorf("rank %d = %q, want %q", i+1, docs[i].ID, want)
+				}
+			}
+			for i := 1; i < len(docs); i++ {
+				if docs[i].Score > docs[i-1].Score {
+					t.Errorf("score of %q = %v, above %q with %v", docs[i].ID, docs[i].Score, docs[i-1].ID, docs[i-1].Score)
+				}
+			}
+		})
+	}
+}
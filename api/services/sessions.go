@@ -0,0 +1,140 @@
+package services
+
+import (
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"iara-assistant/logging"
+)
+
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+
+	sessionsFile = "sessions.json"
+)
+
+type ChatTurn struct {
+	Role string    `json:"role"`
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+}
+
+// historySummary is the summary of a user's turns up to and including
+// through, the newest turn it covers.
+type historySummary struct {
+	through ChatTurn
+	text    string
+	tokens  int
+}
+
+// covers returns the index of the last turn the summary covers, or -1 when
+// it covers none of turns.
+func (h historySummary) covers(turns []ChatTurn) int {
+	for i := len(turns) - 1; i >= 0; i-- {
+		if turns[i].Role == h.through.Role && turns[i].Text == h.through.Text && turns[i].Time.Equal(h.through.Time) {
+			return i
+		}
+	}
+	return -1
+}
+
+// SessionStore keeps the most recent conversation turns of every user, so
+// follow-up questions can be answered in context. It is persisted as JSON in
+// the data directory; the summaries of older turns are only kept in memory.
+type SessionStore struct {
+	mu        sync.Mutex
+	path      string
+	maxTurns  int
+	sessions  map[string][]ChatTurn
+	summaries map[string]historySummary
+}
+
+func NewSessionStore(dataDir string, maxTurns int) *SessionStore {
+	store := &SessionStore{
+		path:      filepath.Join(dataDir, sessionsFile),
+		maxTurns:  maxTurns,
+		sessions:  make(map[string][]ChatTurn),
+		summaries: make(map[string]historySummary),
+	}
+	if err := readJSONFile(store.path, &store.sessions); err != nil {
+		slog.Warn("Failed to load sessions", logging.Err(err))
+	}
+	return store
+}
+
+// History returns the stored turns of a user, oldest first.
+func (s *SessionStore) History(userID string) []ChatTurn {
+	if userID == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ChatTurn(nil), s.sessions[userID]...)
+}
+
+// Append records new turns, keeping only the most recent maxTurns.
+func (s *SessionStore) Append(userID string, turns ...ChatTurn) {
+	if userID == "" || s.maxTurns <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history := append(s.sessions[userID], turns...)
+	if len(history) > s.maxTurns {
+		history = history[len(history)-s.maxTurns:]
+	}
+	s.sessions[userID] = history
+
+	if err := writeJSONFile(s.path, s.sessions); err != nil {
+		slog.Warn("Failed to save sessions", logging.Err(err))
+	}
+}
+
+// summary returns the last summary stored for a user.
+func (s *SessionStore) summary(userID string) (historySummary, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summary, ok := s.summaries[userID]
+	return summary, ok
+}
+
+func (s *SessionStore) setSummary(userID string, summary historySummary) {
+	if userID == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.summaries[userID] = summary
+}
+
+// snapshot returns a copy of every stored session.
+func (s *SessionStore) snapshot() map[string][]ChatTurn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := make(map[string][]ChatTurn, len(s.sessions))
+	for userID, turns := range s.sessions {
+		sessions[userID] = append([]ChatTurn(nil), turns...)
+	}
+	return sessions
+}
+
+// restore stores the given sessions over the users' current ones, or instead
+// of all current sessions when replace is set.
+func (s *SessionStore) restore(sessions map[string][]ChatTurn, replace bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if replace {
+		s.sessions = make(map[string][]ChatTurn, len(sessions))
+		s.summaries = make(map[string]historySummary)
+	}
+	for userID, turns := range sessions {
+		s.sessions[userID] = turns
+		delete(s.summaries, userID)
+	}
+	return writeJSONFile(s.path, s.sessions)
+}
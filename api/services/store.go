@@ -0,0 +1,153 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"iara-assistant/clients"
+	"iara-assistant/logging"
+)
+
+// KeywordIndexPageSize is how many documents are read from ChromaDB at a time
+// when the keyword index is rebuilt at startup.
+const KeywordIndexPageSize = 500
+
+// The helpers below write to ChromaDB and keep the in-memory keyword index in
+// step with it. Every write to the collection should go through them.
+//
+// They also drop cached answers. Shared documents, stored without a user, are
+// retrieved for every user, so any change to the collection may change
+// anyone's answer.
+//
+// While a re-index runs, writes go to its target collection as well, so the
+// swap does not lose them.
+//
+// While the keyword index is loaded, writes wait for the load to finish, so a
+// page read before a write cannot bring back what the write changed.
+
+func (s *RAGService) upsertDocuments(ctx context.Context, batch clients.AddRequest) error {
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+
+	active, target := s.writeCollections()
+	if target != nil {
+		if err := s.checkEmbeddings(ctx, target, batch.Embeddings); err != nil {
+			return err
+		}
+		if err := s.chromaClient.UpsertDocuments(ctx, target.Name(), batch); err != nil {
+			return err
+		}
+	}
+	if err := s.checkEmbeddings(ctx, active, batch.Embeddings); err != nil {
+		// During a re-index to a new model the old collection cannot take
+		// the new embeddings, and only needs to last until the swap.
+		if target == nil || !errors.Is(err, ErrEmbeddingMismatch) {
+			return err
+		}
+	} else if err := s.chromaClient.UpsertDocuments(ctx, active.Name(), batch); err != nil {
+		return err
+	}
+	for i, id := range batch.IDs {
+		var metadata map[string]interface{}
+		if i < len(batch.Metadatas) {
+			metadata = batch.Metadatas[i]
+		}
+		s.keywordIndex.Add(id, batch.Documents[i], metadata)
+	}
+	s.invalidateAnswers("")
+	return nil
+}
+
+func (s *RAGService) updateMetadata(ctx context.Context, ids []string, metadatas []map[string]interface{}) error {
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+
+	active, target := s.writeCollections()
+	if err := s.chromaClient.UpdateMetadata(ctx, active.Name(), ids, metadatas); err != nil {
+		return err
+	}
+	if target != nil {
+		if err := s.chromaClient.UpdateMetadata(ctx, target.Name(), ids, metadatas); err != nil {
+			slog.WarnContext(ctx, "Failed to update metadata in re-index target", "collection", target.Name(), logging.Err(err))
+		}
+	}
+	for i, id := range ids {
+		s.keywordIndex.UpdateMetadata(id, metadatas[i])
+	}
+	s.invalidateAnswers("")
+	return nil
+}
+
+func (s *RAGService) deleteDocuments(ctx context.Context, ids []string, where map[string]interface{}) error {
+	s.writeMu.RLock()
+	defer s.writeMu.RUnlock()
+
+	active, target := s.writeCollections()
+	if err := s.chromaClient.DeleteDocuments(ctx, active.Name(), ids, where); err != nil {
+		return err
+	}
+	if target != nil {
+		if err := s.chromaClient.DeleteDocuments(ctx, target.Name(), ids, where); err != nil {
+			slog.WarnContext(ctx, "Failed to delete documents from re-index target", "collection", target.Name(), logging.Err(err))
+		}
+	}
+	if len(ids) > 0 {
+		s.keywordIndex.Remove(ids...)
+	}
+	if where != nil {
+		s.keywordIndex.RemoveWhere(where)
+	}
+	s.invalidateAnswers("")
+	return nil
+}
+
+// querySimilar runs a similarity search on the active collection, refusing
+// query embeddings from a model other than the one it was built with.
+func (s *RAGService) querySimilar(ctx context.Context, embedding []float32, nResults int, where map[string]interface{}) (*clients.QueryResponse, error) {
+	collection := s.activeCollection()
+	if err := s.checkEmbeddings(ctx, collection, [][]float32{embedding}); err != nil {
+		return nil, err
+	}
+	return s.chromaClient.QuerySimilarWhere(ctx, collection.Name(), embedding, nResults, where)
+}
+
+func (s *RAGService) writeCollections() (active, reindexTarget *vectorCollection) {
+	s.collectionMu.RLock()
+	defer s.collectionMu.RUnlock()
+	return s.collection, s.reindexTarget
+}
+
+func (s *RAGService) invalidateAnswers(userID string) {
+	if s.answers != nil {
+		s.answers.Invalidate(userID)
+	}
+}
+
+// loadKeywordIndex rebuilds the keyword index from every document stored in
+// ChromaDB. The caller holds writeMu.
+func (s *RAGService) loadKeywordIndex(ctx context.Context) error {
+	for offset := 0; ; offset += KeywordIndexPageSize {
+		page, err := s.chromaClient.GetDocumentsWhere(ctx, s.activeCollection().Name(), nil, KeywordIndexPageSize, offset)
+		if err != nil {
+			return fmt.Errorf("failed to load documents for keyword index: %w", err)
+		}
+		for i, id := range page.IDs {
+			if i >= len(page.Documents) {
+				break
+			}
+			var metadata map[string]interface{}
+			if i < len(page.Metadatas) {
+				metadata = page.Metadatas[i]
+			}
+			s.keywordIndex.Add(id, page.Documents[i], metadata)
+		}
+		if len(page.IDs) < KeywordIndexPageSize {
+			break
+		}
+	}
+
+	slog.InfoContext(ctx, "Keyword index loaded", "documents", s.keywordIndex.Len())
+	return nil
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"iara-assistant/clients"
+	"iara-assistant/logging"
+)
+
+const (
+	// ConflictCandidateDistance is the maximum embedding distance for an
+	// existing fact to be considered as possibly updated by a new one.
+	ConflictCandidateDistance = 0.5
+	MaxConflictCandidates     = 5
+	// maxSupersedeChain bounds how many superseded_by links are followed
+	// to find the fact that currently replaces a superseded one.
+	maxSupersedeChain = 10
+)
+
+// activeFactsFilter excludes superseded facts from retrieval. Facts stored
+// before supersession existed have no "superseded" key and still match.
+var activeFactsFilter = map[string]interface{}{
+	"superseded": map[string]interface{}{"$ne": true},
+}
+
+type SupersededFact struct {
+	ID        string `json:"id"`
+	Text      string `json:"text"`
+	LearnedAt string `json:"learned_at,omitempty"`
+}
+
+// markSuperseded flags the given facts as replaced by newID. They are kept
+// for history but excluded from retrieval. Failures are logged and never fail
+// the learn request itself.
+func (s *RAGService) markSuperseded(ctx context.Context, newID string, facts []similarFact) []SupersededFact {
+	if len(facts) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	ids := make([]string, 0, len(facts))
+	metadatas := make([]map[string]interface{}, 0, len(facts))
+	superseded := make([]SupersededFact, 0, len(facts))
+	for _, f := range facts {
+		ids = append(ids, f.ID)
+		metadatas = append(metadatas, map[string]interface{}{
+			"superseded":    true,
+			"superseded_by": newID,
+			"superseded_at": now,
+		})
+		superseded = append(superseded, SupersededFact{ID: f.ID, Text: f.Text, LearnedAt: f.LearnedAt})
+	}
+
+	if err := s.updateMetadata(ctx, ids, metadatas); err != nil {
+		slog.WarnContext(ctx, "Failed to mark facts as superseded", logging.Err(err))
+		return nil
+	}
+
+	slog.InfoContext(ctx, "Marked facts as superseded", "count", len(superseded), "superseded_by", newID)
+	return superseded
+}
+
+// currentSuccessor follows the superseded_by links of a superseded fact to
+// the active fact that currently replaces it. It returns nil when the chain
+// ends in a missing fact or loops back to the fact itself.
+func (s *RAGService) currentSuccessor(ctx context.Context, id string, metadata map[string]interface{}) (*similarFact, error) {
+	next, _ := metadata["superseded_by"].(string)
+	for hops := 0; next != "" && next != id && hops < maxSupersedeChain; hops++ {
+		result, err := s.chromaClient.GetDocuments(ctx, s.activeCollection().Name(), []string{next})
+		if err != nil {
+			return nil, err
+		}
+		if len(result.IDs) == 0 || result.IDs[0] != next {
+			return nil, nil
+		}
+
+		var successorMetadata map[string]interface{}
+		if len(result.Metadatas) > 0 {
+			successorMetadata = result.Metadatas[0]
+		}
+		if !isSuperseded(successorMetadata) {
+			successor := &similarFact{ID: next}
+			if len(result.Documents) > 0 {
+				successor.Text = result.Documents[0]
+			}
+			successor.LearnedAt, _ = successorMetadata["timestamp"].(string)
+			return successor, nil
+		}
+		next, _ = successorMetadata["superseded_by"].(string)
+	}
+	return nil, nil
+}
+
+// reviveMetadata clears the supersession of a fact that is learned again.
+// Upserts merge metadata into the stored record, so the links left by
+// markSuperseded have to be reset explicitly.
+func reviveMetadata(metadata map[string]interface{}) {
+	metadata["superseded"] = false
+	metadata["superseded_by"] = ""
+	metadata["superseded_at"] = ""
+}
+
+// withoutFact drops the fact with the given ID from facts.
+func withoutFact(facts []similarFact, id string) []similarFact {
+	kept := facts[:0]
+	for _, f := range facts {
+		if f.ID != id {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// detectConflictingFacts asks the model which of the candidates are outdated
+// or contradicted by the new fact. Failures are logged and treated as no
+// conflicts.
+func (s *RAGService) detectConflictingFacts(ctx context.Context, newFact string, candidates []similarFact) []similarFact {
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	var existing strings.Builder
+	for i, c := range candidates {
+		fmt.Fprintf(&existing, "%d. %s", i+1, c.Text)
+		if c.LearnedAt != "" {
+			fmt.Fprintf(&existing, " (learned at %s)", c.LearnedAt)
+		}
+		existing.WriteString("\n")
+	}
+
+	prompt := fmt.Sprintf(`You maintain a personal knowledge base. A new fact is being learned:
+
+NEW FACT: %s
+
+EXISTING FACTS:
+%s
+Which existing facts are made obsolete by the new fact, because the new fact updates, corrects or contradicts them? Facts that merely talk about the same subject without conflicting are NOT obsolete.
+
+Reply with only a JSON array containing the numbers of the obsolete facts, for example [1, 3]. Reply with [] if none are obsolete.`, newFact, existing.String())
+
+	ctx, cancel := withStageTimeout(ctx, s.prompts.GenerationTimeout)
+	defer cancel()
+	answer, err := s.models.GenerateText(ctx, TaskClassify, prompt)
+	if err != nil {
+		slog.WarnContext(ctx, "Conflict detection failed", logging.Err(err))
+		return nil
+	}
+
+	indexes, err := parseIndexList(answer)
+	if err != nil {
+		slog.WarnContext(ctx, "Conflict detection failed", logging.Err(err))
+		return nil
+	}
+
+	var conflicting []similarFact
+	seen := make(map[int]bool)
+	for _, idx := range indexes {
+		if idx < 1 || idx > len(candidates) || seen[idx] {
+			continue
+		}
+		seen[idx] = true
+		conflicting = append(conflicting, candidates[idx-1])
+	}
+	return conflicting
+}
+
+// parseIndexList extracts a JSON array of integers from a model answer,
+// tolerating surrounding prose or Markdown code fences.
+func parseIndexList(answer string) ([]int, error) {
+	var indexes []int
+	if err := unmarshalJSONArray(answer, &indexes); err != nil {
+		return nil, err
+	}
+	return indexes, nil
+}
+
+// unmarshalJSONArray decodes the outermost JSON array found in a model
+// answer into v.
+func unmarshalJSONArray(answer string, v interface{}) error {
+	start := strings.Index(answer, "[")
+	end := strings.LastIndex(answer, "]")
+	if start == -1 || end < start {
+		return fmt.Errorf("no JSON array in model answer: %q", answer)
+	}
+	if err := json.Unmarshal([]byte(answer[start:end+1]), v); err != nil {
+		return fmt.Errorf("failed to parse model answer %q: %w", answer, err)
+	}
+	return nil
+}
+
+func resultMetadata(result *clients.QueryResponse, i int) map[string]interface{} {
+	if len(result.Metadatas) == 0 || i >= len(result.Metadatas[0]) {
+		return nil
+	}
+	return result.Metadatas[0][i]
+}
+
+func isSuperseded(metadata map[string]interface{}) bool {
+	superseded, _ := metadata["superseded"].(bool)
+	return superseded
+}
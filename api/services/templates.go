@@ -0,0 +1,121 @@
+package services
+
+import (
+	"embed"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"iara-assistant/logging"
+)
+
+const (
+	TemplateSystem    = "system.tmpl"
+	TemplateAnswer    = "answer.tmpl"
+	TemplateNoContext = "no_context.tmpl"
+	TemplateSummary   = "summary.tmpl"
+)
+
+//go:embed prompts/*.tmpl
+var defaultTemplates embed.FS
+
+// PromptData is what prompt templates are rendered with.
+type PromptData struct {
+	Persona  Persona
+	Question string
+	Context  []string
+	History  []ChatTurn
+	Summary  string
+	// MaxWords bounds the length of a summary.
+	MaxWords int
+}
+
+var templateFuncs = template.FuncMap{
+	"languageName": languageName,
+}
+
+type loadedTemplate struct {
+	tmpl    *template.Template
+	modTime time.Time
+}
+
+// PromptTemplates renders the prompt templates. The defaults are compiled
+// into the binary; a file with the same name in dir overrides its default
+// and is reloaded whenever its modification time changes, so prompts can be
+// tuned without a restart.
+type PromptTemplates struct {
+	mu        sync.Mutex
+	dir       string
+	defaults  map[string]*template.Template
+	overrides map[string]loadedTemplate
+}
+
+func NewPromptTemplates(dir string) *PromptTemplates {
+	t := &PromptTemplates{
+		dir:       dir,
+		defaults:  make(map[string]*template.Template),
+		overrides: make(map[string]loadedTemplate),
+	}
+	for _, name := range []string{TemplateSystem, TemplateAnswer, TemplateNoContext, TemplateSummary} {
+		source, err := defaultTemplates.ReadFile("prompts/" + name)
+		if err != nil {
+			panic(fmt.Sprintf("missing default prompt template %s: %v", name, err))
+		}
+		t.defaults[name] = template.Must(template.New(name).Funcs(templateFuncs).Parse(string(source)))
+	}
+	return t
+}
+
+// Render executes the named template.
+func (t *PromptTemplates) Render(name string, data PromptData) (string, error) {
+	tmpl, err := t.lookup(name)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt template %s: %w", name, err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+func (t *PromptTemplates) lookup(name string) (*template.Template, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.dir != "" {
+		path := filepath.Join(t.dir, name)
+		info, err := os.Stat(path)
+		if err == nil {
+			loaded, ok := t.overrides[name]
+			if ok && loaded.modTime.Equal(info.ModTime()) {
+				return loaded.tmpl, nil
+			}
+			tmpl, err := template.New(name).Funcs(templateFuncs).ParseFiles(path)
+			if err == nil {
+				slog.Info("Loaded prompt template", "path", path)
+				t.overrides[name] = loadedTemplate{tmpl: tmpl, modTime: info.ModTime()}
+				return tmpl, nil
+			}
+			// Keep serving the last good version while the file is being
+			// edited.
+			slog.Warn("Failed to parse prompt template", "path", path, logging.Err(err))
+			if ok {
+				return loaded.tmpl, nil
+			}
+		} else {
+			delete(t.overrides, name)
+		}
+	}
+
+	tmpl, ok := t.defaults[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown prompt template %s", name)
+	}
+	return tmpl, nil
+}
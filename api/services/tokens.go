@@ -0,0 +1,201 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"sync"
+	"unicode/utf8"
+
+	"iara-assistant/logging"
+)
+
+const (
+	TokenCounterEstimate = "estimate"
+	TokenCounterGemini   = "gemini"
+)
+
+const (
+	// TokenCountCacheSize bounds the counts the Gemini counter remembers.
+	// Context documents and history turns recur from one message to the
+	// next, so most of them are counted once.
+	TokenCountCacheSize = 5000
+	// tokenCountConcurrency is how many countTokens requests a batch keeps
+	// in flight at once.
+	tokenCountConcurrency = 4
+	// maxFitAttempts bounds how often fitToTokens shortens a text that
+	// still measures over its budget.
+	maxFitAttempts = 4
+)
+
+// TokenCounter measures text in model tokens.
+type TokenCounter interface {
+	CountTokens(ctx context.Context, text string) int
+	// CountTokensBatch counts each of the texts.
+	CountTokensBatch(ctx context.Context, texts []string) []int
+}
+
+type tokenCountingClient interface {
+	CountTokens(ctx context.Context, model, text string) (int, error)
+}
+
+// estimatingCounter approximates Gemini's tokenizer at about four characters
+// per token, which is close enough for budgeting and costs nothing.
+type estimatingCounter struct{}
+
+func (estimatingCounter) CountTokens(ctx context.Context, text string) int {
+	return estimateTokens(text)
+}
+
+func (estimatingCounter) CountTokensBatch(ctx context.Context, texts []string) []int {
+	counts := make([]int, len(texts))
+	for i, text := range texts {
+		counts[i] = estimateTokens(text)
+	}
+	return counts
+}
+
+// geminiCounter asks the countTokens API for exact counts, falling back to
+// the estimate when the API is unavailable. Counts are cached by text, since
+// the API takes one text per request.
+type geminiCounter struct {
+	client tokenCountingClient
+	model  string
+	usage  *UsageTracker
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type tokenCountEntry struct {
+	key   string
+	count int
+}
+
+func (c *geminiCounter) CountTokens(ctx context.Context, text string) int {
+	return c.CountTokensBatch(ctx, []string{text})[0]
+}
+
+func (c *geminiCounter) CountTokensBatch(ctx context.Context, texts []string) []int {
+	counts := make([]int, len(texts))
+	missing := make(map[string][]int)
+	for i, text := range texts {
+		if text == "" {
+			continue
+		}
+		if n, ok := c.cached(text); ok {
+			counts[i] = n
+			continue
+		}
+		missing[text] = append(missing[text], i)
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, tokenCountConcurrency)
+	for text, indexes := range missing {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(text string, indexes []int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			c.usage.RecordTokenCount(ctx)
+			n, err := c.client.CountTokens(ctx, c.model, text)
+			if err != nil {
+				slog.WarnContext(ctx, "Counting tokens failed, estimating instead", logging.Err(err))
+				n = estimateTokens(text)
+			} else {
+				c.store(text, n)
+			}
+			for _, i := range indexes {
+				counts[i] = n
+			}
+		}(text, indexes)
+	}
+	wg.Wait()
+	return counts
+}
+
+func (c *geminiCounter) cached(text string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[contentCacheKey(c.model, text)]
+	if !ok {
+		return 0, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*tokenCountEntry).count, true
+}
+
+func (c *geminiCounter) store(text string, count int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := contentCacheKey(c.model, text)
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&tokenCountEntry{key: key, count: count})
+	if c.order.Len() > TokenCountCacheSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*tokenCountEntry).key)
+	}
+}
+
+func newTokenCounter(name string, client tokenCountingClient, model string, usage *UsageTracker) TokenCounter {
+	if name == TokenCounterGemini {
+		return &geminiCounter{
+			client:  client,
+			model:   model,
+			usage:   usage,
+			entries: make(map[string]*list.Element),
+			order:   list.New(),
+		}
+	}
+	return estimatingCounter{}
+}
+
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// truncateToTokens cuts text down to roughly the given number of tokens,
+// preferring to stop at a word boundary. It assumes four characters per
+// token; use fitToTokens when the result has to be within budget.
+func truncateToTokens(text string, tokens int) string {
+	runes := []rune(text)
+	// Leave room for the ellipsis.
+	limit := tokens*4 - 1
+	if limit <= 0 {
+		return ""
+	}
+	if len(runes) <= limit {
+		return text
+	}
+	cut := limit
+	for i := limit; i > limit*3/4; i-- {
+		if runes[i] == ' ' || runes[i] == '\n' {
+			cut = i
+			break
+		}
+	}
+	return string(runes[:cut]) + "…"
+}
+
+// fitToTokens truncates text until counter measures it within the given
+// number of tokens, and returns it with its count. Text that tokenizes more
+// densely than truncateToTokens assumes is cut again in proportion to the
+// overshoot. An empty string is returned if nothing fits.
+func fitToTokens(ctx context.Context, counter TokenCounter, text string, tokens int) (string, int) {
+	budget := tokens
+	for attempt := 0; attempt < maxFitAttempts && budget > 0; attempt++ {
+		fitted := truncateToTokens(text, budget)
+		n := counter.CountTokens(ctx, fitted)
+		if n <= tokens {
+			return fitted, n
+		}
+		budget = min(budget*tokens/n, budget-1)
+	}
+	return "", 0
+}
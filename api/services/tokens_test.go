@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"unicode/utf8"
+)
+
+// denseCounter counts one token per two characters, twice as dense as the
+// estimate truncateToTokens assumes.
+type denseCounter struct{}
+
+func (denseCounter) CountTokens(ctx context.Context, text string) int {
+	return (utf8.RuneCountInString(text) + 1) / 2
+}
+
+func (c denseCounter) CountTokensBatch(ctx context.Context, texts []string) []int {
+	counts := make([]int, len(texts))
+	for i, text := range texts {
+		counts[i] = c.CountTokens(ctx, text)
+	}
+	return counts
+}
+
+func TestFitToTokens(t *testing.T) {
+	text := strings.Repeat("word ", 200)
+	tests := []struct {
+		name    string
+		counter TokenCounter
+		tokens  int
+	}{
+		{name: "estimate", counter: estimatingCounter{}, tokens: 50},
+		{name: "dense", counter: denseCounter{}, tokens: 50},
+		{name: "tiny budget", counter: denseCounter{}, tokens: 1},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			fitted, n := fitToTokens(context.Background(), tt.counter, text, tt.tokens)
+			if got := tt.counter.CountTokens(context.Background(), fitted); got != n {
+				t.Errorf("reported %d tokens, counter says %d", n, got)
+			}
+			if n > tt.tokens {
+				t.Errorf("got = %d tokens, want at most %d", n, tt.tokens)
+			}
+		})
+	}
+}
+
+type countingClient struct {
+	calls atomic.Int32
+}
+
+func (c *countingClient) CountTokens(ctx context.Context, model, text string) (int, error) {
+	c.calls.Add(1)
+	return estimateTokens(text), nil
+}
+
+func TestGeminiCounterCachesCounts(t *testing.T) {
+	client := &countingClient{}
+	counter := newTokenCounter(TokenCounterGemini, client, "model", nil)
+	texts := []string{"first document", "second document", "", "first document"}
+
+	for i := 0; i < 2; i++ {
+		counts := counter.CountTokensBatch(context.Background(), texts)
+		for j, text := range texts {
+			if counts[j] != estimateTokens(text) {
+				t.Errorf("count of %q = %d, want %d", text, counts[j], estimateTokens(text))
+			}
+		}
+	}
+	if got := client.calls.Load(); got != 2 {
+		t.Errorf("countTokens calls = %d, want 2", got)
+	}
+}
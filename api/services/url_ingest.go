@@ -0,0 +1,386 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"iara-assistant/logging"
+	"iara-assistant/metrics"
+)
+
+const (
+	SourceTypeURL      = "url"
+	MaxPageSize        = 10 << 20
+	URLIngestUserAgent = "Mozilla/5.0 (compatible; IaraAssistant/1.0)"
+	watchedURLsFile    = "watched_urls.json"
+	maxPageRedirects   = 10
+)
+
+// ErrNonPublicAddress is returned for pages on loopback, private, link-local
+// or otherwise internal addresses, which callers must not reach through the
+// server.
+var ErrNonPublicAddress = errors.New("address is not public")
+
+type IngestURLRequest struct {
+	URL    string `json:"url"`
+	Title  string `json:"title,omitempty"`
+	UserID string `json:"user_id,omitempty"`
+	Watch  bool   `json:"watch,omitempty"`
+}
+
+type WatchedURL struct {
+	DocumentID  string    `json:"document_id"`
+	URL         string    `json:"url"`
+	Title       string    `json:"title"`
+	UserID      string    `json:"user_id,omitempty"`
+	ContentHash string    `json:"content_hash"`
+	LastFetched time.Time `json:"last_fetched"`
+	LastChanged time.Time `json:"last_changed"`
+}
+
+// URLWatchList keeps the pages that are re-fetched on a schedule, persisted
+// as JSON in the data directory.
+type URLWatchList struct {
+	mu   sync.Mutex
+	path string
+	urls map[string]*WatchedURL
+}
+
+func NewURLWatchList(dataDir string) *URLWatchList {
+	w := &URLWatchList{
+		path: filepath.Join(dataDir, watchedURLsFile),
+		urls: make(map[string]*WatchedURL),
+	}
+	if err := readJSONFile(w.path, &w.urls); err != nil {
+		slog.Warn("Failed to load watched URLs", logging.Err(err))
+	}
+	return w
+}
+
+func (w *URLWatchList) Get(documentID string) (WatchedURL, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	entry, ok := w.urls[documentID]
+	if !ok {
+		return WatchedURL{}, false
+	}
+	return *entry, true
+}
+
+func (w *URLWatchList) List() []WatchedURL {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	list := make([]WatchedURL, 0, len(w.urls))
+	for _, entry := range w.urls {
+		list = append(list, *entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].URL < list[j].URL })
+	return list
+}
+
+func (w *URLWatchList) Put(entry WatchedURL) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.urls[entry.DocumentID] = &entry
+	return writeJSONFile(w.path, w.urls)
+}
+
+// restore stores the given entries over the current ones, or instead of all
+// current entries when replace is set.
+func (w *URLWatchList) restore(entries []WatchedURL, replace bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if replace {
+		w.urls = make(map[string]*WatchedURL, len(entries))
+	}
+	for i := range entries {
+		entry := entries[i]
+		w.urls[entry.DocumentID] = &entry
+	}
+	return writeJSONFile(w.path, w.urls)
+}
+
+func (w *URLWatchList) Remove(documentID string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.urls[documentID]; !ok {
+		return nil
+	}
+	delete(w.urls, documentID)
+	return writeJSONFile(w.path, w.urls)
+}
+
+type fetchedPage struct {
+	Title  string
+	Format string
+	Hash   string
+	Chunks []Chunk
+}
+
+// IngestURL fetches a web page, keeps only its readable content and stores it
+// as a document. With Watch set, the page is re-fetched by
+// RefreshWatchedURLs and updated whenever it changes.
+func (s *RAGService) IngestURL(ctx context.Context, req IngestURLRequest) (*IngestResponse, error) {
+	pageURL, err := normalizePageURL(req.URL)
+	if err != nil {
+		return &IngestResponse{
+			Success: false,
+			Error:   err.Error(),
+		}, nil
+	}
+
+	page, err := s.fetchPage(ctx, pageURL)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to fetch page", "url", pageURL, logging.Err(err))
+		return &IngestResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Could not fetch page: %v", err),
+		}, nil
+	}
+	if len(page.Chunks) == 0 {
+		return &IngestResponse{
+			Success: false,
+			Error:   "Page has no readable text",
+		}, nil
+	}
+
+	title := req.Title
+	if title == "" {
+		title = page.Title
+	}
+	if title == "" {
+		title = pageURL
+	}
+
+	doc := urlDocument(pageURL, title, req.UserID, page)
+	slog.InfoContext(ctx, "Ingesting page", "url", pageURL, "chunks", len(page.Chunks))
+	if err := s.storeDocument(ctx, doc, page.Chunks); err != nil {
+		return &IngestResponse{
+			Success: false,
+			Error:   "Failed to store page",
+		}, err
+	}
+
+	if req.Watch {
+		now := time.Now().UTC()
+		err := s.watchedURLs.Put(WatchedURL{
+			DocumentID:  doc.ID,
+			URL:         pageURL,
+			Title:       title,
+			UserID:      req.UserID,
+			ContentHash: page.Hash,
+			LastFetched: now,
+			LastChanged: now,
+		})
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to save watched URL", "url", pageURL, logging.Err(err))
+		}
+	}
+
+	return &IngestResponse{
+		Success:    true,
+		Message:    fmt.Sprintf("Page learned successfully in %d chunks!", len(page.Chunks)),
+		DocumentID: doc.ID,
+		Title:      title,
+		Chunks:     len(page.Chunks),
+	}, nil
+}
+
+func (s *RAGService) WatchedURLs() []WatchedURL {
+	return s.watchedURLs.List()
+}
+
+// RefreshWatchedURLs re-fetches every watched page and re-learns the ones
+// whose readable content changed since the last fetch.
+func (s *RAGService) RefreshWatchedURLs(ctx context.Context) (err error) {
+	defer func() {
+		metrics.ObserveCrawl(metrics.SourceWatchedURLs, metrics.Outcome(err))
+	}()
+
+	watched := s.watchedURLs.List()
+	slog.InfoContext(ctx, "Refreshing watched URLs", "count", len(watched))
+
+	var failed int
+	for _, entry := range watched {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.refreshWatchedURL(ctx, entry); err != nil {
+			slog.WarnContext(ctx, "Failed to refresh watched URL", "url", entry.URL, logging.Err(err))
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d watched URL(s) failed to refresh", failed, len(watched))
+	}
+	return nil
+}
+
+func (s *RAGService) refreshWatchedURL(ctx context.Context, entry WatchedURL) error {
+	page, err := s.fetchPage(ctx, entry.URL)
+	if err != nil {
+		return err
+	}
+
+	entry.LastFetched = time.Now().UTC()
+	if page.Hash == entry.ContentHash || len(page.Chunks) == 0 {
+		return s.watchedURLs.Put(entry)
+	}
+
+	slog.InfoContext(ctx, "Watched URL changed, re-learning it", "url", entry.URL)
+	if err := s.storeDocument(ctx, urlDocument(entry.URL, entry.Title, entry.UserID, page), page.Chunks); err != nil {
+		return err
+	}
+
+	entry.ContentHash = page.Hash
+	entry.LastChanged = entry.LastFetched
+	return s.watchedURLs.Put(entry)
+}
+
+func (s *RAGService) fetchPage(ctx context.Context, pageURL string) (*fetchedPage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", URLIngestUserAgent)
+	req.Header.Set("Accept", "text/html, application/pdf, text/plain;q=0.9, */*;q=0.5")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch page: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("page returned status: %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MaxPageSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page: %w", err)
+	}
+
+	format, _ := DetectFormat("", resp.Request.URL.Path, resp.Header.Get("Content-Type"))
+	if format == FormatText && strings.Contains(strings.ToLower(string(body[:min(len(body), 512)])), "<html") {
+		format = FormatHTML
+	}
+
+	page := &fetchedPage{Format: format}
+	var sections []documentSection
+	if format == FormatHTML {
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse HTML: %w", err)
+		}
+		page.Title, sections = extractReadable(doc)
+	} else {
+		sections, err = extractSections(format, body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Hash the extracted text rather than the raw body, so rotating ads or
+	// timestamps in the boilerplate do not count as a change.
+	hasher := sha256.New()
+	for _, section := range sections {
+		hasher.Write([]byte(strings.Join(section.Headings, "\x00")))
+		hasher.Write([]byte(section.Text))
+	}
+	page.Hash = hex.EncodeToString(hasher.Sum(nil))
+	page.Chunks = chunkSections(sections, DefaultChunkSize, DefaultChunkOverlap)
+
+	return page, nil
+}
+
+// newPageClient returns the client that fetches pages for IngestURL. It only
+// connects to public addresses, checked after DNS resolution so a name that
+// resolves to an internal host is refused too, and redirects are held to the
+// same rules as the requested URL.
+func newPageClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: dialPublicOnly,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// Through a proxy the dialer would check the proxy, not the page.
+	transport.Proxy = nil
+
+	return &http.Client{
+		Timeout:   RequestTimeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxPageRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxPageRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// dialPublicOnly is a net.Dialer Control hook refusing connections to
+// addresses that are not public.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("unexpected dial address %q: %w", address, err)
+	}
+	addr := addrPort.Addr().Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() || addr.IsLoopback() ||
+		addr.IsLinkLocalUnicast() || addr.IsUnspecified() {
+		return fmt.Errorf("%s: %w", addr, ErrNonPublicAddress)
+	}
+	return nil
+}
+
+func urlDocument(pageURL, title, userID string, page *fetchedPage) storedDocument {
+	hasher := sha256.New()
+	hasher.Write([]byte(userID))
+	hasher.Write([]byte{0})
+	hasher.Write([]byte(pageURL))
+
+	return storedDocument{
+		ID:         "url-" + hex.EncodeToString(hasher.Sum(nil))[:24],
+		Title:      title,
+		Source:     pageURL,
+		SourceType: SourceTypeURL,
+		Format:     page.Format,
+		UserID:     userID,
+		Extra: map[string]interface{}{
+			"url":          pageURL,
+			"content_hash": page.Hash,
+		},
+	}
+}
+
+func normalizePageURL(raw string) (string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("invalid URL %q: only http and https URLs are supported", raw)
+	}
+	parsed.Fragment = ""
+	return parsed.String(), nil
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDialPublicOnly(t *testing.T) {
+	tests := []struct {
+		address string
+		public  bool
+	}{
+		{address: "93.184.216.34:443", public: true},
+		{address: "[2606:2800:220:1:248:1893:25c8:1946]:443", public: true},
+		{address: "127.0.0.1:8000", public: false},
+		{address: "[::1]:80", public: false},
+		{address: "10.0.0.5:80", public: false},
+		{address: "172.18.0.3:8000", public: false},
+		{address: "192.168.1.1:80", public: false},
+		{address: "169.254.169.254:80", public: false},
+		{address: "[fe80::1]:80", public: false},
+		{address: "[fd00::1]:80", public: false},
+		{address: "0.0.0.0:80", public: false},
+		{address: "[::ffff:127.0.0.1]:80", public: false},
+	}
+
+	for _, tt := range tests {
+		err := dialPublicOnly("tcp", tt.address, nil)
+		if tt.public && err != nil {
+			t.Errorf("%s refused: %v", tt.address, err)
+		}
+		if !tt.public && !errors.Is(err, ErrNonPublicAddress) {
+			t.Errorf("%s: err = %v, want ErrNonPublicAddress", tt.address, err)
+		}
+	}
+}
+
+func TestFetchPageRefusesInternalHosts(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	defer internal.Close()
+
+	s := &RAGService{httpClient: newPageClient()}
+	if _, err := s.fetchPage(context.Background(), internal.URL); !errors.Is(err, ErrNonPublicAddress) {
+		t.Fatalf("fetching %s: err = %v, want ErrNonPublicAddress", internal.URL, err)
+	}
+}
+
+func TestPageClientRefusesRedirectScheme(t *testing.T) {
+	client := newPageClient()
+	req, _ := http.NewRequest(http.MethodGet, "file:///etc/passwd", nil)
+	via := []*http.Request{httptest.NewRequest(http.MethodGet, "https://example.com/", nil)}
+	if err := client.CheckRedirect(req, via); err == nil {
+		t.Fatalf("redirect to %s was allowed", req.URL)
+	}
+}
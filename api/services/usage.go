@@ -0,0 +1,310 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"iara-assistant/logging"
+)
+
+const (
+	usageFile = "usage.json"
+
+	// UsageSaveInterval is how often usage counters are written to disk, so
+	// a restart does not reset the day's quotas.
+	UsageSaveInterval = time.Minute
+
+	usageKeyPrefix  = "key:"
+	usageUserPrefix = "user:"
+)
+
+// ErrQuotaExceeded is returned by model calls once an API key or user has
+// used up its daily quota.
+var ErrQuotaExceeded = errors.New("daily quota exceeded")
+
+// QuotaError tells which quota ran out and when it resets.
+type QuotaError struct {
+	Subject    string
+	Quota      string
+	Limit      int64
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s: %s exceeded its daily %s quota of %d", ErrQuotaExceeded, e.Subject, e.Quota, e.Limit)
+}
+
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// QuotaConfig bounds what each API key and each user may use per day (UTC).
+// Zero disables a quota. Only generation is limited: embedding and
+// countTokens calls are counted in the usage report but never refused, since
+// learning and retrieval cannot work without them.
+type QuotaConfig struct {
+	DailyLLMCalls int64
+	DailyTokens   int64
+}
+
+func DefaultQuotaConfig() QuotaConfig {
+	return QuotaConfig{
+		DailyLLMCalls: 1000,
+		DailyTokens:   2000000,
+	}
+}
+
+type Usage struct {
+	Requests        int64 `json:"requests"`
+	LLMCalls        int64 `json:"llm_calls"`
+	Tokens          int64 `json:"tokens"`
+	EmbeddingCalls  int64 `json:"embedding_calls"`
+	TokenCountCalls int64 `json:"token_count_calls"`
+}
+
+type usageState struct {
+	Day      string            `json:"day"`
+	Subjects map[string]*Usage `json:"subjects"`
+}
+
+// UsageTracker counts requests, model calls and tokens per API key and per
+// user for the current day, and enforces the daily quotas. It is persisted as
+// JSON in the data directory.
+type UsageTracker struct {
+	mu     sync.Mutex
+	path   string
+	limits QuotaConfig
+	state  usageState
+	dirty  bool
+}
+
+func NewUsageTracker(dataDir string, limits QuotaConfig) *UsageTracker {
+	t := &UsageTracker{
+		path:   filepath.Join(dataDir, usageFile),
+		limits: limits,
+		state:  usageState{Subjects: make(map[string]*Usage)},
+	}
+	if err := readJSONFile(t.path, &t.state); err != nil {
+		slog.Warn("Failed to load usage counters", logging.Err(err))
+	}
+	if t.state.Subjects == nil {
+		t.state.Subjects = make(map[string]*Usage)
+	}
+	return t
+}
+
+func UsageKeySubject(keyID string) string {
+	return usageKeyPrefix + keyID
+}
+
+func UsageUserSubject(userID string) string {
+	return usageUserPrefix + userID
+}
+
+type usageSubjectsKey struct{}
+
+// WithUsageSubject attributes the model calls made with ctx to subject as
+// well as to the subjects ctx already carries.
+func WithUsageSubject(ctx context.Context, subject string) context.Context {
+	subjects, _ := ctx.Value(usageSubjectsKey{}).([]string)
+	for _, s := range subjects {
+		if s == subject {
+			return ctx
+		}
+	}
+	return context.WithValue(ctx, usageSubjectsKey{}, append(subjects[:len(subjects):len(subjects)], subject))
+}
+
+func withUsageUser(ctx context.Context, userID string) context.Context {
+	if userID == "" {
+		return ctx
+	}
+	return WithUsageSubject(ctx, UsageUserSubject(userID))
+}
+
+func usageSubjects(ctx context.Context) []string {
+	subjects, _ := ctx.Value(usageSubjectsKey{}).([]string)
+	return subjects
+}
+
+// CountRequest records a request made by subject.
+func (t *UsageTracker) CountRequest(subject string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.usage(subject).Requests++
+	t.dirty = true
+}
+
+// CheckQuota fails with a *QuotaError when any subject of ctx has used up a
+// daily quota.
+func (t *UsageTracker) CheckQuota(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, subject := range usageSubjects(ctx) {
+		usage := t.usage(subject)
+		if t.limits.DailyLLMCalls > 0 && usage.LLMCalls >= t.limits.DailyLLMCalls {
+			return &QuotaError{Subject: subject, Quota: "LLM call", Limit: t.limits.DailyLLMCalls, RetryAfter: untilNextDay()}
+		}
+		if t.limits.DailyTokens > 0 && usage.Tokens >= t.limits.DailyTokens {
+			return &QuotaError{Subject: subject, Quota: "token", Limit: t.limits.DailyTokens, RetryAfter: untilNextDay()}
+		}
+	}
+	return nil
+}
+
+// RecordLLMCall charges a model call and the tokens it used to every subject
+// of ctx.
+func (t *UsageTracker) RecordLLMCall(ctx context.Context, tokens int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, subject := range usageSubjects(ctx) {
+		usage := t.usage(subject)
+		usage.LLMCalls++
+		usage.Tokens += int64(tokens)
+		t.dirty = true
+	}
+}
+
+// RecordEmbeddingCall charges an embedding request to every subject of ctx.
+// It does not count towards the quotas.
+func (t *UsageTracker) RecordEmbeddingCall(ctx context.Context) {
+	t.record(ctx, func(usage *Usage) { usage.EmbeddingCalls++ })
+}
+
+// RecordTokenCount charges a countTokens request to every subject of ctx. It
+// does not count towards the quotas.
+func (t *UsageTracker) RecordTokenCount(ctx context.Context) {
+	t.record(ctx, func(usage *Usage) { usage.TokenCountCalls++ })
+}
+
+func (t *UsageTracker) record(ctx context.Context, charge func(*Usage)) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, subject := range usageSubjects(ctx) {
+		charge(t.usage(subject))
+		t.dirty = true
+	}
+}
+
+// UsageReport is the usage of some subjects for the current day.
+type UsageReport struct {
+	Day      string           `json:"day"`
+	ResetsAt time.Time        `json:"resets_at"`
+	Limits   QuotaLimits      `json:"limits"`
+	Usage    map[string]Usage `json:"usage"`
+}
+
+type QuotaLimits struct {
+	DailyLLMCalls int64 `json:"daily_llm_calls,omitempty"`
+	DailyTokens   int64 `json:"daily_tokens,omitempty"`
+}
+
+// Report returns the usage of the given subjects, or of every subject seen
+// today when none are given.
+func (t *UsageTracker) Report(subjects ...string) UsageReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover()
+
+	if len(subjects) == 0 {
+		for subject := range t.state.Subjects {
+			subjects = append(subjects, subject)
+		}
+		sort.Strings(subjects)
+	}
+	report := UsageReport{
+		Day:      t.state.Day,
+		ResetsAt: time.Now().UTC().Add(untilNextDay()).Truncate(time.Second),
+		Limits:   QuotaLimits{DailyLLMCalls: t.limits.DailyLLMCalls, DailyTokens: t.limits.DailyTokens},
+		Usage:    make(map[string]Usage, len(subjects)),
+	}
+	for _, subject := range subjects {
+		if usage, ok := t.state.Subjects[subject]; ok {
+			report.Usage[subject] = *usage
+		} else {
+			report.Usage[subject] = Usage{}
+		}
+	}
+	return report
+}
+
+// Save writes the counters to disk if they changed since the last save.
+func (t *UsageTracker) Save() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.dirty {
+		return nil
+	}
+	if err := writeJSONFile(t.path, t.state); err != nil {
+		return err
+	}
+	t.dirty = false
+	return nil
+}
+
+// usage returns the counters of subject for today. t.mu must be held.
+func (t *UsageTracker) usage(subject string) *Usage {
+	t.rollover()
+	usage, ok := t.state.Subjects[subject]
+	if !ok {
+		usage = &Usage{}
+		t.state.Subjects[subject] = usage
+	}
+	return usage
+}
+
+// rollover starts a new day's counters. t.mu must be held.
+func (t *UsageTracker) rollover() {
+	today := time.Now().UTC().Format("2006-01-02")
+	if t.state.Day != today {
+		t.state = usageState{Day: today, Subjects: make(map[string]*Usage)}
+		t.dirty = true
+	}
+}
+
+func untilNextDay() time.Duration {
+	now := time.Now().UTC()
+	return now.Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+}
+
+// Usage returns the tracker that counts requests and model calls.
+func (s *RAGService) Usage() *UsageTracker {
+	return s.usage
+}
+
+// saveUsage periodically persists the usage counters until ctx is done.
+func (s *RAGService) saveUsage(ctx context.Context) {
+	ticker := time.NewTicker(UsageSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.usage.Save(); err != nil {
+				slog.Warn("Failed to save usage counters", logging.Err(err))
+			}
+		}
+	}
+}
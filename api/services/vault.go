@@ -0,0 +1,564 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+
+	"iara-assistant/logging"
+)
+
+const (
+	SourceTypeVault   = "vault"
+	vaultManifestFile = "vault_manifest.json"
+	// VaultSyncDebounce groups the burst of events an editor produces when
+	// saving a note into a single sync.
+	VaultSyncDebounce = 2 * time.Second
+)
+
+// ErrVaultNotAllowed is returned for vault paths outside the configured vault
+// roots.
+var ErrVaultNotAllowed = errors.New("vault path is outside the allowed vault roots")
+
+var (
+	wikiLinkPattern  = regexp.MustCompile(`!?\[\[([^\]|#]*)(#[^\]|]*)?(\|[^\]]*)?\]\]`)
+	inlineTagPattern = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_/-]*\p{L}[\p{L}\p{N}_/-]*)`)
+)
+
+type VaultSyncRequest struct {
+	Path   string `json:"path"`
+	UserID string `json:"user_id,omitempty"`
+	Watch  bool   `json:"watch,omitempty"`
+}
+
+type VaultSyncResult struct {
+	Path      string   `json:"path"`
+	Files     int      `json:"files"`
+	Added     int      `json:"added"`
+	Updated   int      `json:"updated"`
+	Unchanged int      `json:"unchanged"`
+	Removed   int      `json:"removed"`
+	Failed    int      `json:"failed"`
+	Errors    []string `json:"errors,omitempty"`
+	Watching  bool     `json:"watching"`
+}
+
+type vaultFileState struct {
+	DocumentID  string `json:"document_id"`
+	ContentHash string `json:"content_hash"`
+	Chunks      int    `json:"chunks"`
+}
+
+type vaultNote struct {
+	Title string
+	Tags  []string
+	Links []string
+	Body  string
+}
+
+// VaultSyncer imports Markdown vaults (such as Obsidian's) and keeps them in
+// sync. It remembers the content hash of every note it stored for each vault
+// and user, so unchanged notes are skipped and deleted notes are removed from
+// memory. Only vaults inside its roots are read.
+type VaultSyncer struct {
+	rag          *RAGService
+	manifestPath string
+	roots        []string
+
+	// mu guards the maps below. It is never held across a sync; syncLocks
+	// keep two syncs of the same vault and user from interleaving.
+	mu        sync.Mutex
+	manifest  map[string]map[string]vaultFileState
+	watchers  map[string]*fsnotify.Watcher
+	syncLocks map[string]*sync.Mutex
+
+	// watchCtx bounds the syncs started by watchers; StopAll cancels it.
+	watchCtx    context.Context
+	cancelWatch context.CancelFunc
+}
+
+// NewVaultSyncer returns a syncer for the vaults in or below roots.
+func NewVaultSyncer(rag *RAGService, dataDir string, roots []string) *VaultSyncer {
+	watchCtx, cancelWatch := context.WithCancel(context.Background())
+	v := &VaultSyncer{
+		rag:          rag,
+		manifestPath: filepath.Join(dataDir, vaultManifestFile),
+		manifest:     make(map[string]map[string]vaultFileState),
+		watchers:     make(map[string]*fsnotify.Watcher),
+		syncLocks:    make(map[string]*sync.Mutex),
+		watchCtx:     watchCtx,
+		cancelWatch:  cancelWatch,
+	}
+	for _, root := range roots {
+		resolved, err := resolveDir(root)
+		if err != nil {
+			slog.Warn("Ignoring vault root", "root", root, logging.Err(err))
+			continue
+		}
+		v.roots = append(v.roots, resolved)
+	}
+	if err := readJSONFile(v.manifestPath, &v.manifest); err != nil {
+		slog.Warn("Failed to load vault manifest", logging.Err(err))
+	}
+	return v
+}
+
+// resolveVault resolves a requested vault path, following symbolic links, and
+// checks that it is inside one of the roots.
+func (v *VaultSyncer) resolveVault(path string) (string, error) {
+	root, err := resolveDir(path)
+	if err != nil {
+		return "", err
+	}
+	for _, allowed := range v.roots {
+		if rel, err := filepath.Rel(allowed, root); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return root, nil
+		}
+	}
+	return "", fmt.Errorf("%s: %w", root, ErrVaultNotAllowed)
+}
+
+func resolveDir(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid vault path: %w", err)
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", fmt.Errorf("vault path %s is not a directory", abs)
+	}
+	if info, err := os.Stat(resolved); err != nil || !info.IsDir() {
+		return "", fmt.Errorf("vault path %s is not a directory", abs)
+	}
+	return resolved, nil
+}
+
+// vaultKey keys the manifest and the watchers by vault and user, since the
+// same vault may be learned for several users. Vaults synced without a user
+// keep the bare path, as manifests written before users were told apart do.
+func vaultKey(root, userID string) string {
+	if userID == "" {
+		return root
+	}
+	return root + "\x00" + userID
+}
+
+// syncLock returns the lock serializing the syncs of a vault for a user.
+func (v *VaultSyncer) syncLock(key string) *sync.Mutex {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	lock, ok := v.syncLocks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		v.syncLocks[key] = lock
+	}
+	return lock
+}
+
+// Sync upserts new and changed notes of the vault and removes the chunks of
+// notes that no longer exist. Symbolic links inside the vault are not
+// followed.
+func (v *VaultSyncer) Sync(ctx context.Context, root, userID string) (*VaultSyncResult, error) {
+	root, err := v.resolveVault(root)
+	if err != nil {
+		return nil, err
+	}
+	key := vaultKey(root, userID)
+
+	lock := v.syncLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	v.mu.Lock()
+	previous := v.manifest[key]
+	v.mu.Unlock()
+	current := make(map[string]vaultFileState)
+	result := &VaultSyncResult{Path: root}
+
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !strings.EqualFold(filepath.Ext(path), ".md") {
+			return nil
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		rel = filepath.ToSlash(rel)
+		result.Files++
+
+		state, changed, err := v.syncNote(ctx, root, rel, userID, previous[rel])
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to sync vault note", "note", rel, logging.Err(err))
+			result.Failed++
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", rel, err))
+			if old, ok := previous[rel]; ok {
+				current[rel] = old
+			}
+			return nil
+		}
+
+		current[rel] = state
+		switch {
+		case !changed:
+			result.Unchanged++
+		case previous[rel].DocumentID == "":
+			result.Added++
+		default:
+			result.Updated++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk vault: %w", err)
+	}
+
+	for rel, state := range previous {
+		if _, ok := current[rel]; ok {
+			continue
+		}
+		if err := v.rag.deleteDocuments(ctx, nil, documentFilter(state.DocumentID)); err != nil {
+			slog.WarnContext(ctx, "Failed to remove deleted vault note", "note", rel, logging.Err(err))
+			current[rel] = state
+			result.Failed++
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", rel, err))
+			continue
+		}
+		result.Removed++
+	}
+
+	v.mu.Lock()
+	v.manifest[key] = current
+	if err := writeJSONFile(v.manifestPath, v.manifest); err != nil {
+		slog.WarnContext(ctx, "Failed to save vault manifest", logging.Err(err))
+	}
+	_, result.Watching = v.watchers[key]
+	v.mu.Unlock()
+
+	slog.InfoContext(ctx, "Vault synced",
+		"vault", root,
+		"added", result.Added,
+		"updated", result.Updated,
+		"unchanged", result.Unchanged,
+		"removed", result.Removed,
+		"failed", result.Failed)
+	return result, nil
+}
+
+func (v *VaultSyncer) syncNote(ctx context.Context, root, rel, userID string, previous vaultFileState) (vaultFileState, bool, error) {
+	content, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		return previous, false, err
+	}
+
+	hash := sha256.Sum256(content)
+	contentHash := hex.EncodeToString(hash[:])
+	documentID := vaultDocumentID(userID, root, rel)
+	// Entries of manifests written before users were told apart may belong
+	// to another user's document.
+	if contentHash == previous.ContentHash && documentID == previous.DocumentID {
+		return previous, false, nil
+	}
+
+	note := parseVaultNote(rel, string(content))
+	sections := markdownSections(note.Body)
+	for i := range sections {
+		if len(sections[i].Headings) == 0 || sections[i].Headings[0] != note.Title {
+			sections[i].Headings = append([]string{note.Title}, sections[i].Headings...)
+		}
+	}
+	chunks := chunkSections(sections, DefaultChunkSize, DefaultChunkOverlap)
+
+	doc := storedDocument{
+		ID:         documentID,
+		Title:      note.Title,
+		Source:     rel,
+		SourceType: SourceTypeVault,
+		Format:     FormatMarkdown,
+		UserID:     userID,
+		Extra: map[string]interface{}{
+			"vault":        root,
+			"file_path":    rel,
+			"content_hash": contentHash,
+			"tags":         strings.Join(note.Tags, ", "),
+			"links":        strings.Join(note.Links, ", "),
+		},
+	}
+
+	// An emptied note keeps no chunks, but stays in the manifest so it is not
+	// reported as deleted.
+	if len(chunks) == 0 {
+		if err := v.rag.deleteDocuments(ctx, nil, documentFilter(doc.ID)); err != nil {
+			return previous, false, err
+		}
+	} else if err := v.rag.storeDocument(ctx, doc, chunks); err != nil {
+		return previous, false, err
+	}
+
+	return vaultFileState{
+		DocumentID:  doc.ID,
+		ContentHash: contentHash,
+		Chunks:      len(chunks),
+	}, true, nil
+}
+
+// Watch keeps the vault in sync with the file system until StopAll is
+// called. Watching an already watched vault for the same user is a no-op.
+func (v *VaultSyncer) Watch(root, userID string) error {
+	root, err := v.resolveVault(root)
+	if err != nil {
+		return err
+	}
+	key := vaultKey(root, userID)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if _, ok := v.watchers[key]; ok {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	if err := addWatchDirs(watcher, root); err != nil {
+		watcher.Close()
+		return err
+	}
+	v.watchers[key] = watcher
+
+	go v.watchLoop(watcher, root, userID)
+	slog.Info("Watching vault for changes", "vault", root)
+	return nil
+}
+
+func (v *VaultSyncer) watchLoop(watcher *fsnotify.Watcher, root, userID string) {
+	var timer *time.Timer
+	resync := func() {
+		if _, err := v.Sync(v.watchCtx, root, userID); err != nil {
+			slog.Error("Vault sync failed", "vault", root, logging.Err(err))
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			}
+			// fsnotify is not recursive, so new folders need their own watch.
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := addWatchDirs(watcher, event.Name); err != nil {
+						slog.Warn("Failed to watch new vault folder", "vault", root, logging.Err(err))
+					}
+				}
+			}
+			if timer == nil {
+				timer = time.AfterFunc(VaultSyncDebounce, resync)
+			} else {
+				timer.Reset(VaultSyncDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Error("Vault watcher failed", "vault", root, logging.Err(err))
+		}
+	}
+}
+
+// snapshot returns a copy of the manifest, keyed by vaultKey and then by note
+// path.
+func (v *VaultSyncer) snapshot() map[string]map[string]vaultFileState {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	manifest := make(map[string]map[string]vaultFileState, len(v.manifest))
+	for root, files := range v.manifest {
+		manifest[root] = make(map[string]vaultFileState, len(files))
+		for rel, state := range files {
+			manifest[root][rel] = state
+		}
+	}
+	return manifest
+}
+
+// restore stores the manifests of the given vaults over the current ones, or
+// instead of all current manifests when replace is set.
+func (v *VaultSyncer) restore(manifest map[string]map[string]vaultFileState, replace bool) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if replace {
+		v.manifest = make(map[string]map[string]vaultFileState, len(manifest))
+	}
+	for root, files := range manifest {
+		v.manifest[root] = files
+	}
+	return writeJSONFile(v.manifestPath, v.manifest)
+}
+
+// StopAll stops watching every vault and cancels syncs they started.
+func (v *VaultSyncer) StopAll() {
+	v.cancelWatch()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for key, watcher := range v.watchers {
+		watcher.Close()
+		delete(v.watchers, key)
+		slog.Info("Stopped watching vault", "vault", strings.SplitN(key, "\x00", 2)[0])
+	}
+}
+
+func addWatchDirs(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(path); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// parseVaultNote splits off the YAML front matter and collects tags (from the
+// front matter and inline #tags) and [[wiki-links]]. Links are replaced with
+// their display text so the stored chunks read naturally.
+func parseVaultNote(rel, content string) vaultNote {
+	note := vaultNote{
+		Title: strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel)),
+		Body:  content,
+	}
+
+	var frontMatter struct {
+		Title string      `yaml:"title"`
+		Tags  interface{} `yaml:"tags"`
+	}
+	if fm, body, ok := splitFrontMatter(content); ok {
+		note.Body = body
+		if err := yaml.Unmarshal([]byte(fm), &frontMatter); err != nil {
+			slog.Warn("Invalid front matter", "note", rel, logging.Err(err))
+		}
+	}
+	if frontMatter.Title != "" {
+		note.Title = frontMatter.Title
+	}
+
+	tags := make(map[string]bool)
+	for _, tag := range yamlStrings(frontMatter.Tags) {
+		tags[strings.TrimPrefix(tag, "#")] = true
+	}
+	for _, match := range inlineTagPattern.FindAllStringSubmatch(note.Body, -1) {
+		tags[match[1]] = true
+	}
+	for tag := range tags {
+		if tag != "" {
+			note.Tags = append(note.Tags, tag)
+		}
+	}
+	sort.Strings(note.Tags)
+
+	links := make(map[string]bool)
+	note.Body = wikiLinkPattern.ReplaceAllStringFunc(note.Body, func(link string) string {
+		parts := wikiLinkPattern.FindStringSubmatch(link)
+		target := strings.TrimSpace(parts[1])
+		if target != "" && !links[target] {
+			links[target] = true
+			note.Links = append(note.Links, target)
+		}
+		if alias := strings.TrimPrefix(parts[3], "|"); alias != "" {
+			return alias
+		}
+		if target == "" {
+			return strings.TrimPrefix(parts[2], "#")
+		}
+		return target
+	})
+
+	return note
+}
+
+func splitFrontMatter(content string) (string, string, bool) {
+	content = strings.TrimPrefix(content, "\ufeff")
+	if !strings.HasPrefix(content, "---\n") && !strings.HasPrefix(content, "---\r\n") {
+		return "", content, false
+	}
+	rest := content[strings.Index(content, "\n")+1:]
+	for offset := 0; offset < len(rest); {
+		end := strings.Index(rest[offset:], "\n")
+		line := rest[offset:]
+		if end != -1 {
+			line = rest[offset : offset+end]
+		}
+		if strings.TrimSpace(line) == "---" {
+			if end == -1 {
+				return rest[:offset], "", true
+			}
+			return rest[:offset], rest[offset+end+1:], true
+		}
+		if end == -1 {
+			break
+		}
+		offset += end + 1
+	}
+	return "", content, false
+}
+
+// yamlStrings accepts both "tags: [a, b]" and "tags: a b" styles.
+func yamlStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+	case []interface{}:
+		var out []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, strings.TrimSpace(s))
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func vaultDocumentID(userID, root, rel string) string {
+	hasher := sha256.New()
+	hasher.Write([]byte(userID))
+	hasher.Write([]byte{0})
+	hasher.Write([]byte(root))
+	hasher.Write([]byte{0})
+	hasher.Write([]byte(rel))
+	return "vault-" + hex.EncodeToString(hasher.Sum(nil))[:24]
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVaultSyncerResolveVault(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "vaults")
+	outside := filepath.Join(base, "etc")
+	for _, dir := range []string{filepath.Join(root, "notes"), filepath.Join(root, "..notes"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	escape := filepath.Join(root, "escape")
+	if err := os.Symlink(outside, escape); err != nil {
+		t.Fatal(err)
+	}
+
+	v := NewVaultSyncer(nil, t.TempDir(), []string{root})
+	tests := []struct {
+		path    string
+		allowed bool
+	}{
+		{path: root, allowed: true},
+		{path: filepath.Join(root, "notes"), allowed: true},
+		{path: filepath.Join(root, "..notes"), allowed: true},
+		{path: filepath.Join(root, "notes", "..", ".."), allowed: false},
+		{path: outside, allowed: false},
+		{path: escape, allowed: false},
+	}
+
+	for _, tt := range tests {
+		_, err := v.resolveVault(tt.path)
+		if tt.allowed && err != nil {
+			t.Errorf("%s refused: %v", tt.path, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrVaultNotAllowed) {
+			t.Errorf("%s: err = %v, want ErrVaultNotAllowed", tt.path, err)
+		}
+	}
+}
+
+func TestVaultKeySeparatesUsers(t *testing.T) {
+	if vaultKey("/vault", "alice") == vaultKey("/vault", "bob") {
+		t.Fatal("two users of a vault share a manifest")
+	}
+	if got := vaultKey("/vault", ""); got != "/vault" {
+		t.Errorf("vaultKey without a user = %q, want the bare path", got)
+	}
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SignatureHeader carries "sha256=<hex>", the HMAC-SHA256 of
+	// "<timestamp>.<body>" keyed with the shared secret.
+	SignatureHeader = "X-Iara-Signature"
+	// TimestampHeader carries the Unix time in seconds the request was signed
+	// at.
+	TimestampHeader = "X-Iara-Timestamp"
+	// TelegramSecretHeader carries the secret_token given to setWebhook.
+	TelegramSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+	DefaultSignatureWindow = 5 * time.Minute
+
+	signaturePrefix = "sha256="
+)
+
+var (
+	ErrSignatureMissing  = errors.New("missing signature")
+	ErrSignatureInvalid  = errors.New("invalid signature")
+	ErrSignatureExpired  = errors.New("signature timestamp outside the allowed window")
+	ErrSignatureReplayed = errors.New("signature already used")
+)
+
+// SignatureVerifier checks HMAC signed requests. A signature is accepted once,
+// and only while its timestamp is within the window of the current time, so a
+// captured request cannot be replayed.
+type SignatureVerifier struct {
+	secret []byte
+	window time.Duration
+	seen   *replayCache
+}
+
+// NewSignatureVerifier returns nil, which verifies nothing, when secret is
+// empty.
+func NewSignatureVerifier(secret string, window time.Duration) *SignatureVerifier {
+	if secret == "" {
+		return nil
+	}
+	if window <= 0 {
+		window = DefaultSignatureWindow
+	}
+	return &SignatureVerifier{
+		secret: []byte(secret),
+		window: window,
+		seen:   newReplayCache(2 * window),
+	}
+}
+
+// Sign returns the signature header value for body signed at timestamp.
+func (v *SignatureVerifier) Sign(timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the timestamp and signature headers of a request against its
+// body.
+func (v *SignatureVerifier) Verify(timestamp, signature string, body []byte) error {
+	if timestamp == "" || signature == "" {
+		return ErrSignatureMissing
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	now := time.Now()
+	if age := now.Sub(time.Unix(ts, 0)); age > v.window || age < -v.window {
+		return ErrSignatureExpired
+	}
+
+	given, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(signature), signaturePrefix))
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	expected, _ := hex.DecodeString(strings.TrimPrefix(v.Sign(ts, body), signaturePrefix))
+	if !hmac.Equal(given, expected) {
+		return ErrSignatureInvalid
+	}
+	if v.seen.seenBefore(hex.EncodeToString(given), now) {
+		return ErrSignatureReplayed
+	}
+	return nil
+}
+
+// TelegramVerifier checks the secret token Telegram sends with every webhook
+// call and drops updates it has already handled, since Telegram redelivers an
+// update until it gets a successful response.
+type TelegramVerifier struct {
+	secret  []byte
+	updates *replayCache
+}
+
+// NewTelegramVerifier returns nil when secret is empty; the webhook must not
+// be served without one.
+func NewTelegramVerifier(secret string) *TelegramVerifier {
+	if secret == "" {
+		return nil
+	}
+	return &TelegramVerifier{secret: []byte(secret), updates: newReplayCache(24 * time.Hour)}
+}
+
+// Verify checks the secret token header.
+func (v *TelegramVerifier) Verify(token string) error {
+	if token == "" {
+		return ErrSignatureMissing
+	}
+	if subtle.ConstantTimeCompare([]byte(token), v.secret) != 1 {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+// Duplicate reports whether the update was seen before.
+func (v *TelegramVerifier) Duplicate(updateID int64) bool {
+	return v.updates.seenBefore(strconv.FormatInt(updateID, 10), time.Now())
+}
+
+// replayCache remembers ids for a while.
+type replayCache struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	seen map[string]time.Time
+}
+
+func newReplayCache(ttl time.Duration) *replayCache {
+	return &replayCache{ttl: ttl, seen: make(map[string]time.Time)}
+}
+
+// seenBefore records id and reports whether it was already recorded within
+// the cache's lifetime.
+func (c *replayCache) seenBefore(id string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, at := range c.seen {
+		if now.Sub(at) > c.ttl {
+			delete(c.seen, key)
+		}
+	}
+	if _, ok := c.seen[id]; ok {
+		return true
+	}
+	c.seen[id] = now
+	return false
+}
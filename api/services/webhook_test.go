@@ -0,0 +1,54 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignatureVerifierVerify(t *testing.T) {
+	body := []byte(`{"text":"oi"}`)
+	signer := NewSignatureVerifier("secret", time.Minute)
+	now := time.Now().Unix()
+
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+		want      error
+	}{
+		{name: "valid", timestamp: strconv.FormatInt(now, 10), signature: signer.Sign(now, body)},
+		{name: "missing signature", timestamp: strconv.FormatInt(now, 10), want: ErrSignatureMissing},
+		{name: "malformed timestamp", timestamp: "yesterday", signature: signer.Sign(now, body), want: ErrSignatureInvalid},
+		{name: "expired timestamp", timestamp: strconv.FormatInt(now-120, 10), signature: signer.Sign(now-120, body), want: ErrSignatureExpired},
+		{name: "future timestamp", timestamp: strconv.FormatInt(now+120, 10), signature: signer.Sign(now+120, body), want: ErrSignatureExpired},
+		{name: "bad hex", timestamp: strconv.FormatInt(now, 10), signature: "sha256=not-hex", want: ErrSignatureInvalid},
+		{name: "wrong secret", timestamp: strconv.FormatInt(now, 10), signature: NewSignatureVerifier("other", time.Minute).Sign(now, body), want: ErrSignatureInvalid},
+		{name: "other body", timestamp: strconv.FormatInt(now, 10), signature: signer.Sign(now, []byte(`{"text":"tchau"}`)), want: ErrSignatureInvalid},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewSignatureVerifier("secret", time.Minute)
+			if err := v.Verify(tt.timestamp, tt.signature, body); !errors.Is(err, tt.want) {
+				t.Errorf("Verify() err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignatureVerifierRejectsReplay(t *testing.T) {
+	body := []byte(`{"text":"oi"}`)
+	v := NewSignatureVerifier("secret", time.Minute)
+	now := time.Now().Unix()
+	timestamp, signature := strconv.FormatInt(now, 10), v.Sign(now, body)
+
+	if err := v.Verify(timestamp, signature, body); err != nil {
+		t.Fatalf("first Verify() err = %v", err)
+	}
+	if err := v.Verify(timestamp, signature, body); !errors.Is(err, ErrSignatureReplayed) {
+		t.Errorf("replayed Verify() err = %v, want %v", err, ErrSignatureReplayed)
+	}
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"fmt"
+)
+
+// matchesWhere evaluates a Chroma metadata filter locally, so in-process
+// indexes can apply the same filters as ChromaDB. It supports $and, $or and
+// the comparison operators $eq, $ne, $gt, $gte, $lt, $lte, $in and $nin.
+// Like ChromaDB's $ne, a missing key does not equal anything.
+func matchesWhere(metadata map[string]interface{}, where map[string]interface{}) bool {
+	for key, condition := range where {
+		switch key {
+		case "$and":
+			for _, sub := range whereList(condition) {
+				if !matchesWhere(metadata, sub) {
+					return false
+				}
+			}
+		case "$or":
+			matched := false
+			for _, sub := range whereList(condition) {
+				if matchesWhere(metadata, sub) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return false
+			}
+		default:
+			value, present := metadata[key]
+			ops, ok := condition.(map[string]interface{})
+			if !ok {
+				ops = map[string]interface{}{"$eq": condition}
+			}
+			for op, operand := range ops {
+				if !compareMetadata(value, present, op, operand) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func whereList(condition interface{}) []map[string]interface{} {
+	switch v := condition.(type) {
+	case []map[string]interface{}:
+		return v
+	case []interface{}:
+		list := make([]map[string]interface{}, 0, len(v))
+		for _, item := range v {
+			if m, ok := item.(map[string]interface{}); ok {
+				list = append(list, m)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+func compareMetadata(value interface{}, present bool, op string, operand interface{}) bool {
+	switch op {
+	case "$eq":
+		return present && metadataEqual(value, operand)
+	case "$ne":
+		return !present || !metadataEqual(value, operand)
+	case "$in", "$nin":
+		found := false
+		for _, candidate := range interfaceList(operand) {
+			if present && metadataEqual(value, candidate) {
+				found = true
+				break
+			}
+		}
+		return found == (op == "$in")
+	case "$gt", "$gte", "$lt", "$lte":
+		a, okA := toFloat(value)
+		b, okB := toFloat(operand)
+		if !present || !okA || !okB {
+			return false
+		}
+		switch op {
+		case "$gt":
+			return a > b
+		case "$gte":
+			return a >= b
+		case "$lt":
+			return a < b
+		default:
+			return a <= b
+		}
+	}
+	return false
+}
+
+func metadataEqual(a, b interface{}) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func interfaceList(v interface{}) []interface{} {
+	switch list := v.(type) {
+	case []interface{}:
+		return list
+	case []string:
+		out := make([]interface{}, len(list))
+		for i, s := range list {
+			out[i] = s
+		}
+		return out
+	}
+	return nil
+}